@@ -170,7 +170,9 @@ var _ Credentials = &CertCredentials{}
 type CertCredentials struct {
 	BaseCredentials
 	Certs []*x509.Certificate
-	Key   crypto.PrivateKey
+
+	// Key is the private key of the leaf certificate. Any crypto.Signer may be used, see NewSignerCredentials.
+	Key crypto.PrivateKey
 }
 
 // NewCertCredentials creates Credentials instance based upon an array of certificates. At least one certificate must
@@ -184,6 +186,14 @@ func NewCertCredentials(certs []*x509.Certificate, key crypto.PrivateKey) *CertC
 	}
 }
 
+// NewSignerCredentials creates a Credentials instance based upon an array of certificates and a crypto.Signer for the
+// leaf certificate. Only the signing operation of the signer is used, so the private key may be held outside the
+// process, such as by a hardware module or a signing agent, and never be exported. Identities configured through
+// ziti.Config.ID always use a key loaded into the process; set ziti.Config.Credentials to use a signer instead.
+func NewSignerCredentials(certs []*x509.Certificate, signer crypto.Signer) *CertCredentials {
+	return NewCertCredentials(certs, signer)
+}
+
 func (c *CertCredentials) Method() string {
 	return "cert"
 }
@@ -0,0 +1,205 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	ctx "context"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAuthQueryTimeout is how long an auth query handler has to answer a query, across all attempts
+	DefaultAuthQueryTimeout = 2 * time.Minute
+
+	// DefaultAuthQueryMaxAttempts is how many times an auth query handler is invoked for a query before giving up
+	DefaultAuthQueryMaxAttempts = 3
+)
+
+// ErrNoAuthQueryHandler is the cause of an AuthQueryError when no handler is registered for a query and the context is
+// non-interactive
+var ErrNoAuthQueryHandler = errors.New("no auth query handler registered")
+
+// AuthQueryResponse submits the answer to an auth query. For the ziti provider the answer is the TOTP code. Queries
+// of other providers are answered out of band, such as by completing a step-up with an external IdP or through a
+// hardware key agent, after which the response is called with an empty answer to have the context verify the query
+// was satisfied.
+type AuthQueryResponse func(answer string) error
+
+// AuthQueryHandler answers auth queries. The handler returns once the query is answered, or with an error to have the
+// query retried, up to the attempt limit. The given context is cancelled when the query times out or the ziti context
+// is closed.
+type AuthQueryHandler interface {
+	HandleAuthQuery(ctx ctx.Context, query *rest_model.AuthQueryDetail, response AuthQueryResponse) error
+}
+
+type AuthQueryHandlerFunc func(ctx ctx.Context, query *rest_model.AuthQueryDetail, response AuthQueryResponse) error
+
+func (f AuthQueryHandlerFunc) HandleAuthQuery(ctx ctx.Context, query *rest_model.AuthQueryDetail, response AuthQueryResponse) error {
+	return f(ctx, query, response)
+}
+
+// AuthQueryError is returned when an auth query couldn't be answered
+type AuthQueryError struct {
+	Query    *rest_model.AuthQueryDetail
+	Attempts int
+	Err      error
+}
+
+func (self *AuthQueryError) Error() string {
+	provider := ""
+	if self.Query.Provider != nil {
+		provider = string(*self.Query.Provider)
+	}
+	return fmt.Sprintf("unable to answer auth query for provider '%s' (format '%s') after %d attempt(s): %v",
+		provider, self.Query.Format, self.Attempts, self.Err)
+}
+
+func (self *AuthQueryError) Unwrap() error {
+	return self.Err
+}
+
+// authQueryRegistry holds auth query handlers by provider and format. Handlers registered without a format answer
+// queries of any format for their provider.
+type authQueryRegistry struct {
+	lock     sync.Mutex
+	handlers map[string]*authQueryRegistration
+}
+
+// authQueryRegistration identifies a registration, as handlers such as AuthQueryHandlerFunc aren't comparable
+type authQueryRegistration struct {
+	handler AuthQueryHandler
+}
+
+func newAuthQueryRegistry() *authQueryRegistry {
+	return &authQueryRegistry{
+		handlers: map[string]*authQueryRegistration{},
+	}
+}
+
+func authQueryKey(provider rest_model.MfaProviders, format rest_model.MfaFormats) string {
+	return string(provider) + "/" + string(format)
+}
+
+func (self *authQueryRegistry) register(provider rest_model.MfaProviders, format rest_model.MfaFormats, handler AuthQueryHandler) func() {
+	key := authQueryKey(provider, format)
+
+	registration := &authQueryRegistration{handler: handler}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.handlers[key] = registration
+
+	return func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		if self.handlers[key] == registration {
+			delete(self.handlers, key)
+		}
+	}
+}
+
+func (self *authQueryRegistry) get(query *rest_model.AuthQueryDetail) AuthQueryHandler {
+	if self == nil || query.Provider == nil {
+		return nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if registration, found := self.handlers[authQueryKey(*query.Provider, query.Format)]; found {
+		return registration.handler
+	}
+	if registration, found := self.handlers[authQueryKey(*query.Provider, "")]; found {
+		return registration.handler
+	}
+	return nil
+}
+
+func (context *ContextImpl) RegisterAuthQueryHandler(provider rest_model.MfaProviders, format rest_model.MfaFormats, handler AuthQueryHandler) func() {
+	return context.authQueryHandlers.register(provider, format, handler)
+}
+
+// answerAuthQuery invokes the handler until it answers the query, the attempts are used up, the query times out or the
+// context is closed
+func (context *ContextImpl) answerAuthQuery(handler AuthQueryHandler, query *rest_model.AuthQueryDetail, response AuthQueryResponse) error {
+	timeoutCtx, cancel := ctx.WithTimeout(ctx.Background(), context.options.getAuthQueryTimeout())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-context.closeNotify:
+			cancel()
+		case <-timeoutCtx.Done():
+		}
+	}()
+
+	maxAttempts := context.options.getAuthQueryMaxAttempts()
+	var lastErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		// handlers which don't honor cancellation are abandoned
+		errC := make(chan error, 1)
+		go func() {
+			errC <- handler.HandleAuthQuery(timeoutCtx, query, response)
+		}()
+
+		select {
+		case err := <-errC:
+			if err == nil {
+				return nil
+			}
+			lastErr = err
+			pfxlog.Logger().WithError(err).WithField("attempt", attempt).Warn("auth query handler failed to answer query")
+		case <-timeoutCtx.Done():
+			return &AuthQueryError{Query: query, Attempts: attempt, Err: timeoutCtx.Err()}
+		}
+
+		if timeoutCtx.Err() != nil {
+			return &AuthQueryError{Query: query, Attempts: attempt, Err: lastErr}
+		}
+	}
+
+	return &AuthQueryError{Query: query, Attempts: maxAttempts, Err: lastErr}
+}
+
+// authQueryResponse returns the response which submits answers to the given query
+func (context *ContextImpl) authQueryResponse(query *rest_model.AuthQueryDetail) AuthQueryResponse {
+	if query.Provider != nil && *query.Provider == rest_model.MfaProvidersZiti {
+		return context.authenticateMfa
+	}
+	return func(string) error {
+		return context.completeAuthQuery()
+	}
+}
+
+// completeAuthQuery checks whether the auth queries of the current api session have been satisfied out of band
+func (context *ContextImpl) completeAuthQuery() error {
+	if _, err := context.CtrlClt.Refresh(); err != nil {
+		return err
+	}
+
+	if apiSession := context.CtrlClt.CurrentAPISessionDetail; apiSession != nil && len(apiSession.AuthQueries) == 0 {
+		return context.onFullAuth()
+	}
+
+	return errors.New("auth queries are not yet satisfied")
+}
@@ -0,0 +1,151 @@
+package ziti
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func newAuthQueryTestContext(options *Options) *ContextImpl {
+	return &ContextImpl{
+		options:           options,
+		authQueryHandlers: newAuthQueryRegistry(),
+		closeNotify:       make(chan struct{}),
+		eventBus:          newEventBus(),
+		EventEmmiter:      events.New(),
+	}
+}
+
+func newAuthQuery(provider rest_model.MfaProviders, format rest_model.MfaFormats) *rest_model.AuthQueryDetail {
+	return &rest_model.AuthQueryDetail{
+		Provider: &provider,
+		Format:   format,
+	}
+}
+
+func Test_AuthQueryHandlers(t *testing.T) {
+	t.Run("handlers are found by provider and format", func(t *testing.T) {
+		req := require.New(t)
+		registry := newAuthQueryRegistry()
+
+		var called string
+		anyFormat := AuthQueryHandlerFunc(func(context.Context, *rest_model.AuthQueryDetail, AuthQueryResponse) error {
+			called = "any"
+			return nil
+		})
+		numeric := AuthQueryHandlerFunc(func(context.Context, *rest_model.AuthQueryDetail, AuthQueryResponse) error {
+			called = "numeric"
+			return nil
+		})
+
+		registry.register("idp", "", anyFormat)
+		unregister := registry.register("idp", rest_model.MfaFormatsNumeric, numeric)
+
+		req.NoError(registry.get(newAuthQuery("idp", rest_model.MfaFormatsNumeric)).HandleAuthQuery(context.Background(), nil, nil))
+		req.Equal("numeric", called)
+
+		req.NoError(registry.get(newAuthQuery("idp", rest_model.MfaFormatsAlpha)).HandleAuthQuery(context.Background(), nil, nil))
+		req.Equal("any", called)
+
+		req.Nil(registry.get(newAuthQuery("hardware-key", "")))
+
+		unregister()
+		req.NoError(registry.get(newAuthQuery("idp", rest_model.MfaFormatsNumeric)).HandleAuthQuery(context.Background(), nil, nil))
+		req.Equal("any", called)
+	})
+
+	t.Run("failed answers are retried up to the attempt limit", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newAuthQueryTestContext(&Options{AuthQueryMaxAttempts: 3})
+
+		var attempts atomic.Int32
+		handler := AuthQueryHandlerFunc(func(_ context.Context, _ *rest_model.AuthQueryDetail, response AuthQueryResponse) error {
+			attempts.Add(1)
+			return response("123456")
+		})
+
+		invalidCode := errors.New("invalid code")
+		var answers []string
+		response := func(answer string) error {
+			answers = append(answers, answer)
+			if len(answers) < 2 {
+				return invalidCode
+			}
+			return nil
+		}
+
+		req.NoError(ztx.answerAuthQuery(handler, newAuthQuery("idp", ""), response))
+		req.Equal(int32(2), attempts.Load())
+
+		attempts.Store(0)
+		err := ztx.answerAuthQuery(handler, newAuthQuery("idp", ""), func(string) error { return invalidCode })
+		var queryErr *AuthQueryError
+		req.ErrorAs(err, &queryErr)
+		req.Equal(3, queryErr.Attempts)
+		req.ErrorIs(err, invalidCode)
+		req.Equal(int32(3), attempts.Load())
+	})
+
+	t.Run("unanswered queries time out", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newAuthQueryTestContext(&Options{AuthQueryTimeout: 50 * time.Millisecond})
+
+		block := make(chan struct{})
+		defer close(block)
+		handler := AuthQueryHandlerFunc(func(context.Context, *rest_model.AuthQueryDetail, AuthQueryResponse) error {
+			<-block // ignores cancellation
+			return nil
+		})
+
+		err := ztx.answerAuthQuery(handler, newAuthQuery("idp", ""), nil)
+		req.ErrorIs(err, context.DeadlineExceeded)
+	})
+
+	t.Run("closing the context cancels handlers", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newAuthQueryTestContext(&Options{})
+
+		handler := AuthQueryHandlerFunc(func(ctx context.Context, _ *rest_model.AuthQueryDetail, _ AuthQueryResponse) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		time.AfterFunc(50*time.Millisecond, func() { close(ztx.closeNotify) })
+		err := ztx.answerAuthQuery(handler, newAuthQuery("idp", ""), nil)
+		req.ErrorIs(err, context.Canceled)
+	})
+
+	t.Run("non-interactive contexts fail fast without a handler", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newAuthQueryTestContext(&Options{NonInteractive: true})
+
+		err := ztx.handleAuthQuery(newAuthQuery(rest_model.MfaProvidersZiti, rest_model.MfaFormatsNumeric))
+		req.ErrorIs(err, ErrNoAuthQueryHandler)
+
+		ztx.options.NonInteractive = false
+		req.NoError(ztx.handleAuthQuery(newAuthQuery(rest_model.MfaProvidersZiti, rest_model.MfaFormatsNumeric)))
+	})
+
+	t.Run("registered handlers answer queries of other providers", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newAuthQueryTestContext(&Options{AuthQueryMaxAttempts: 1})
+
+		var received *rest_model.AuthQueryDetail
+		ztx.RegisterAuthQueryHandler("idp", "", AuthQueryHandlerFunc(
+			func(_ context.Context, query *rest_model.AuthQueryDetail, _ AuthQueryResponse) error {
+				received = query
+				return nil
+			}))
+
+		query := newAuthQuery("idp", "")
+		query.HTTPURL = "https://idp.example.com/step-up"
+		req.NoError(ztx.handleAuthQuery(query))
+		req.Same(query, received)
+	})
+}
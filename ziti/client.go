@@ -17,12 +17,14 @@
 package ziti
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"github.com/openziti/foundation/v2/genext"
@@ -60,6 +62,10 @@ type CtrlClient struct {
 	ApiSessionPrivateKey        *ecdsa.PrivateKey
 	ApiSessionCertInstance      string
 
+	// ApiSessionSigner, if set, is used instead of ApiSessionPrivateKey for API session certificates, so that their
+	// key may be held outside the process, such as by a hardware module.
+	ApiSessionSigner crypto.Signer
+
 	PostureCache *posture.Cache
 	ConfigTypes  []string
 }
@@ -99,11 +105,17 @@ func (self *CtrlClient) IsServiceListUpdateAvailable() (bool, *strfmt.DateTime,
 
 // Authenticate attempts to use authenticate, overwriting any existing ApiSession.
 func (self *CtrlClient) Authenticate() (*rest_model.CurrentAPISessionDetail, error) {
+	return self.authenticate(defaultConfigRegistry)
+}
+
+// authenticate requests ConfigTypes along with the config types of the given registry, which is the registry of the
+// context owning the client
+func (self *CtrlClient) authenticate(registry *configRegistry) (*rest_model.CurrentAPISessionDetail, error) {
 	var err error
 
 	self.ApiSessionCertificate = nil
 
-	apiSession, err := self.ClientApiClient.Authenticate(self.Credentials, self.ConfigTypes)
+	apiSession, err := self.ClientApiClient.Authenticate(self.Credentials, registry.withRegisteredConfigTypes(self.ConfigTypes))
 
 	if err != nil {
 		return nil, rest_util.WrapErr(err)
@@ -200,7 +212,15 @@ func (self *CtrlClient) GetIdentity() (identity.Identity, error) {
 		}
 	}
 
-	return identity.NewClientTokenIdentityWithPool([]*x509.Certificate{self.ApiSessionCertificate}, self.ApiSessionPrivateKey, self.HttpTransport.TLSClientConfig.RootCAs), nil
+	return identity.NewClientTokenIdentityWithPool([]*x509.Certificate{self.ApiSessionCertificate}, self.apiSessionSigner(), self.HttpTransport.TLSClientConfig.RootCAs), nil
+}
+
+// apiSessionSigner returns the key of the API session certificate, ApiSessionSigner if set
+func (self *CtrlClient) apiSessionSigner() crypto.Signer {
+	if self.ApiSessionSigner != nil {
+		return self.ApiSessionSigner
+	}
+	return self.ApiSessionPrivateKey
 }
 
 // EnsureApiSessionCertificate will create an ApiSessionCertificate if one does not already exist.
@@ -214,13 +234,13 @@ func (self *CtrlClient) EnsureApiSessionCertificate() error {
 
 // NewApiSessionCertificate will create a new ephemeral private key used to generate an ephemeral certificate
 // that may be used with the current ApiSession. The generated certificate and private key are scoped to the
-// ApiSession used to create it.
+// ApiSession used to create it. If ApiSessionSigner is set, it is used instead of a generated key.
 func (self *CtrlClient) NewApiSessionCertificate() error {
 	if self.ApiSessionCertInstance == "" {
 		self.ApiSessionCertInstance = uuid.NewString()
 	}
 
-	if self.ApiSessionPrivateKey == nil {
+	if self.ApiSessionSigner == nil && self.ApiSessionPrivateKey == nil {
 		var err error
 		self.ApiSessionPrivateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 
@@ -237,7 +257,7 @@ func (self *CtrlClient) NewApiSessionCertificate() error {
 		},
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, self.ApiSessionPrivateKey)
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, self.apiSessionSigner())
 	if err != nil {
 		panic(err)
 	}
@@ -379,6 +399,49 @@ func (self *CtrlClient) VerifyMfa(code string) error {
 	return rest_util.WrapErr(err)
 }
 
+// NewMfaRecoveryCodes will replace the recovery codes of the currently enrolled TOTP MFA. The new codes are read with
+// GetMfaRecoveryCodes.
+func (self *CtrlClient) NewMfaRecoveryCodes(code string) error {
+	params := current_identity.NewCreateMfaRecoveryCodesParams()
+
+	params.MfaValidation = &rest_model.MfaCode{
+		Code: &code,
+	}
+
+	_, err := self.API.CurrentIdentity.CreateMfaRecoveryCodes(params, nil)
+
+	return rest_util.WrapErr(err)
+}
+
+// GetMfaRecoveryCodes returns the recovery codes of the currently enrolled TOTP MFA
+func (self *CtrlClient) GetMfaRecoveryCodes(code string) ([]string, error) {
+	params := current_identity.NewDetailMfaRecoveryCodesParams()
+	params.MfaValidationCode = &code
+
+	resp, err := self.API.CurrentIdentity.DetailMfaRecoveryCodes(params, nil)
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	// the generated response doesn't describe its data, so it is decoded as recovery codes here
+	data, err := json.Marshal(resp.Payload.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &rest_model.DetailMfaRecoveryCodes{}
+	if err = json.Unmarshal(data, detail); err != nil {
+		return nil, fmt.Errorf("could not read recovery codes: %v", err)
+	}
+
+	if len(detail.RecoveryCodes) == 0 {
+		return nil, fmt.Errorf("no recovery codes returned")
+	}
+
+	return detail.RecoveryCodes, nil
+}
+
 // RemoveMfa will remove the currently enrolled TOTP MFA added by EnrollMfa() and verified by VerifyMfa()
 func (self *CtrlClient) RemoveMfa(code string) error {
 	params := current_identity.NewDeleteMfaParams()
@@ -18,10 +18,15 @@ package ziti
 
 import (
 	"context"
+	"fmt"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"math"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -49,8 +54,9 @@ import (
 // collection.Add(ctx) //manual collection add
 // ```
 type CtxCollection struct {
-	contexts    cmap.ConcurrentMap[string, Context]
-	ConfigTypes []string
+	contexts        cmap.ConcurrentMap[string, Context]
+	hostnameLookups hostnameLookups
+	ConfigTypes     []string
 }
 
 // NewSdkCollection creates a new empty collection.
@@ -122,6 +128,75 @@ func (set *CtxCollection) ForAll(f func(ctx Context)) {
 	})
 }
 
+// GetServiceForHostname finds the service with the intercept address that best matches the given hostname in any of
+// the contexts, on any protocol and port. False is returned if no intercept matches.
+func (set *CtxCollection) GetServiceForHostname(hostname string) (*rest_model.ServiceDetail, bool) {
+	var result *rest_model.ServiceDetail
+	set.ForAll(func(ctx Context) {
+		if result == nil {
+			result, _ = ctx.GetServiceForHostname(hostname)
+		}
+	})
+	return result, result != nil
+}
+
+// DialAddr finds the context and service whose intercept best matches the given address and dials it. Matching is
+// based on Match() logic in edge.InterceptV1Config. Virtual IPs known to the lookups registered with
+// RegisterHostnameLookup are dialed by the hostname they were handed out for.
+func (set *CtxCollection) DialAddr(network string, addr string) (edge.Conn, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := ipOf(host)
+	host = set.hostnameLookups.resolve(host)
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, err
+	}
+
+	network = normalizeProtocol(network)
+
+	ztx, service := set.getServiceForAddr(network, host, uint16(port))
+	if ztx == nil {
+		return nil, fmt.Errorf("address [%s:%s:%d] is not intercepted by any ziti context", network, host, port)
+	}
+
+	if impl, ok := ztx.(*ContextImpl); ok {
+		return impl.dialServiceFromAddr(context.Background(), service, network, host, ip, uint16(port))
+	}
+	return ztx.DialAddr(network, net.JoinHostPort(host, portString))
+}
+
+// getServiceForAddr returns the context and service with the intercept that best matches the given address, or nil
+func (set *CtxCollection) getServiceForAddr(network, host string, port uint16) (Context, *rest_model.ServiceDetail) {
+	var ztx Context
+	var service *rest_model.ServiceDetail
+	var bestFound = false
+	best := math.MaxInt
+	set.ForAll(func(ctx Context) {
+		if bestFound {
+			return
+		}
+
+		srv, score, err := ctx.GetServiceForAddr(network, host, port)
+		if err == nil {
+			if score < best {
+				best = score
+				ztx = ctx
+				service = srv
+			}
+
+			if score == 0 { // best possible score
+				bestFound = true
+			}
+		}
+	})
+
+	return ztx, service
+}
+
 // NewContextFromFile is the same as ziti.NewContextFromFile but will also add the resulting
 // context to the current collection.
 func (set *CtxCollection) NewContextFromFile(file string) (Context, error) {
@@ -35,12 +35,22 @@ type Config struct {
 	ConfigTypes []string `json:"configTypes"`
 
 	//The ID field allows configurations is maintained for backwards compatability with previous SDK versions.
-	//If set, it will be used to set the Credentials field.
+	//If set, it will be used to set the Credentials field. Its key is loaded into the process from a file or PEM, so
+	//identities whose key is held by an external signer must set Credentials instead, see
+	//edge_apis.NewSignerCredentials.
 	ID identity.Config `json:"id"`
 
 	//The Credentials field is used to authenticate with the Edge Client API. If the ID field is set, it will be used
 	//to populate this field with credentials.
 	Credentials apis.Credentials `json:"-"`
+
+	//ControllerPins, if set, restricts the certificates trusted for the controller's Edge Client API to those with
+	//matching public keys, in addition to normal certificate verification.
+	ControllerPins *PinSet `json:"controllerPins,omitempty"`
+
+	//RouterPins, if set, restricts the certificates trusted for edge routers to those with matching public keys, in
+	//addition to normal certificate verification.
+	RouterPins *PinSet `json:"routerPins,omitempty"`
 }
 
 // NewConfig will create a new Config object from a provided Ziti Edge Client API URL and identity configuration.
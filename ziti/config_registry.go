@@ -0,0 +1,215 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"encoding/json"
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+type configType struct {
+	name   string
+	goType reflect.Type
+	schema *spec.Schema
+	parse  func(service *rest_model.ServiceDetail) (interface{}, bool, error)
+}
+
+// configRegistry holds registered config types. Contexts use defaultConfigRegistry, which RegisterConfigType adds to.
+type configRegistry struct {
+	sync.RWMutex
+	byName map[string]*configType
+	byType map[reflect.Type]*configType
+}
+
+func newConfigRegistry() *configRegistry {
+	return &configRegistry{
+		byName: map[string]*configType{},
+		byType: map[reflect.Type]*configType{},
+	}
+}
+
+var defaultConfigRegistry = newConfigRegistry()
+
+// RegisterConfigType registers a service config type, so that configs of that type are requested when contexts
+// authenticate and are parsed into a T once per service update. They can then be retrieved with ServiceConfig. If
+// jsonSchema is not empty, configs are validated against it before being parsed. Configs which fail validation or
+// parsing are not available from ServiceConfig, and are reported with EventServiceConfigInvalid.
+//
+// Each config type may only be registered once, and each Go type may only be used for one config type. Types should be
+// registered before contexts authenticate, usually from an init function.
+func RegisterConfigType[T any](name string, jsonSchema string) error {
+	return registerConfigType[T](defaultConfigRegistry, name, jsonSchema)
+}
+
+func registerConfigType[T any](registry *configRegistry, name string, jsonSchema string) error {
+	var schema *spec.Schema
+	if jsonSchema != "" {
+		schema = &spec.Schema{}
+		if err := json.Unmarshal([]byte(jsonSchema), schema); err != nil {
+			return errors.Wrapf(err, "invalid JSON schema for config type '%s'", name)
+		}
+	}
+
+	goType := reflect.TypeOf((*T)(nil)).Elem()
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, found := registry.byName[name]; found {
+		return errors.Errorf("config type '%s' is already registered", name)
+	}
+
+	if existing, found := registry.byType[goType]; found {
+		return errors.Errorf("type %v is already registered for config type '%s'", goType, existing.name)
+	}
+
+	registration := &configType{
+		name:   name,
+		goType: goType,
+		schema: schema,
+		parse: func(service *rest_model.ServiceDetail) (interface{}, bool, error) {
+			result := new(T)
+			found, err := edge.ParseServiceConfig(service, name, result)
+			return result, found, err
+		},
+	}
+	registry.byName[name] = registration
+	registry.byType[goType] = registration
+
+	return nil
+}
+
+// RegisteredConfigTypes returns the names of all registered config types
+func RegisteredConfigTypes() []string {
+	return defaultConfigRegistry.names()
+}
+
+func (self *configRegistry) names() []string {
+	self.RLock()
+	defer self.RUnlock()
+
+	var result []string
+	for name := range self.byName {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ServiceConfig returns the config of the service with the given name whose config type was registered for T with
+// RegisterConfigType. False is returned if the type isn't registered, the service is unknown, or the service has no
+// valid config of that type.
+func ServiceConfig[T any](ztx Context, serviceName string) (*T, bool) {
+	goType := reflect.TypeOf((*T)(nil)).Elem()
+
+	provider, ok := ztx.(parsedConfigProvider)
+	if !ok {
+		return nil, false
+	}
+
+	registry := provider.getConfigRegistry()
+	registry.RLock()
+	registration, found := registry.byType[goType]
+	registry.RUnlock()
+
+	if !found {
+		return nil, false
+	}
+
+	config, found := provider.getParsedConfig(serviceName, registration.name)
+	if !found {
+		return nil, false
+	}
+	return config.(*T), true
+}
+
+type parsedConfigProvider interface {
+	getConfigRegistry() *configRegistry
+	getParsedConfig(serviceName, configType string) (interface{}, bool)
+}
+
+func (context *ContextImpl) getConfigRegistry() *configRegistry {
+	if context.configRegistry == nil {
+		return defaultConfigRegistry
+	}
+	return context.configRegistry
+}
+
+func (context *ContextImpl) getParsedConfig(serviceName, configType string) (interface{}, bool) {
+	configs, found := context.serviceConfigs.Get(serviceName)
+	if !found {
+		return nil, false
+	}
+	config, found := configs[configType]
+	return config, found
+}
+
+// parseServiceConfigs validates and parses the configs of a service for all registered config types, reporting any
+// which are invalid
+func (context *ContextImpl) parseServiceConfigs(service *rest_model.ServiceDetail) {
+	registry := context.getConfigRegistry()
+	registry.RLock()
+	registrations := make([]*configType, 0, len(registry.byName))
+	for _, registration := range registry.byName {
+		registrations = append(registrations, registration)
+	}
+	registry.RUnlock()
+
+	parsed := map[string]interface{}{}
+	for _, registration := range registrations {
+		raw, found := service.Config[registration.name]
+		if !found {
+			continue
+		}
+
+		if registration.schema != nil {
+			if err := validate.AgainstSchema(registration.schema, raw, strfmt.Default); err != nil {
+				context.publish(&ServiceConfigInvalidEvent{Service: service, ConfigType: registration.name, Err: err})
+				continue
+			}
+		}
+
+		config, _, err := registration.parse(service)
+		if err != nil {
+			context.publish(&ServiceConfigInvalidEvent{Service: service, ConfigType: registration.name, Err: err})
+			continue
+		}
+		parsed[registration.name] = config
+	}
+
+	context.serviceConfigs.Set(*service.Name, parsed)
+}
+
+// withRegisteredConfigTypes returns the given config types along with any registered ones which are missing
+func (self *configRegistry) withRegisteredConfigTypes(requested []string) []string {
+	result := append([]string(nil), requested...)
+	for _, name := range self.names() {
+		if !stringz.Contains(result, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package ziti
+
+import (
+	"testing"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type testRegistryConfig struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+const testRegistryConfigSchema = `{
+	"type": "object",
+	"required": ["port"],
+	"properties": {
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"protocol": {"type": "string", "enum": ["tcp", "udp"]}
+	}
+}`
+
+func Test_ConfigRegistry(t *testing.T) {
+	req := require.New(t)
+	registry := newConfigRegistry()
+	req.NoError(registerConfigType[testRegistryConfig](registry, "test-registry.v1", testRegistryConfigSchema))
+
+	ztx := &ContextImpl{
+		configRegistry: registry,
+		serviceConfigs: cmap.New[map[string]interface{}](),
+		EventEmmiter:   events.New(),
+		eventBus:       newEventBus(),
+	}
+
+	var invalid []*ServiceConfigInvalidEvent
+	ztx.AddServiceConfigInvalidListener(func(_ Context, service *rest_model.ServiceDetail, configType string, err error) {
+		invalid = append(invalid, &ServiceConfigInvalidEvent{Service: service, ConfigType: configType, Err: err})
+	})
+
+	newService := func(name string, config map[string]interface{}) *rest_model.ServiceDetail {
+		return &rest_model.ServiceDetail{
+			BaseEntity: rest_model.BaseEntity{ID: ToPtr(name + "-id")},
+			Name:       ToPtr(name),
+			Config:     map[string]map[string]interface{}{"test-registry.v1": config},
+		}
+	}
+
+	t.Run("registered types are requested", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal([]string{"test-registry.v1"}, registry.names())
+		req.Equal([]string{"intercept.v1", "test-registry.v1"}, registry.withRegisteredConfigTypes([]string{"intercept.v1", "test-registry.v1"}))
+		req.Equal([]string{"intercept.v1", "test-registry.v1"}, registry.withRegisteredConfigTypes([]string{"intercept.v1"}))
+	})
+
+	t.Run("valid config is parsed", func(t *testing.T) {
+		req := require.New(t)
+		invalid = nil
+		ztx.parseServiceConfigs(newService("valid", map[string]interface{}{"port": 443, "protocol": "tcp"}))
+
+		config, found := ServiceConfig[testRegistryConfig](ztx, "valid")
+		req.True(found)
+		req.Equal(&testRegistryConfig{Port: 443, Protocol: "tcp"}, config)
+		req.Empty(invalid)
+	})
+
+	t.Run("config failing schema validation is reported", func(t *testing.T) {
+		req := require.New(t)
+		invalid = nil
+		ztx.parseServiceConfigs(newService("invalid", map[string]interface{}{"port": 70000, "protocol": "icmp"}))
+
+		_, found := ServiceConfig[testRegistryConfig](ztx, "invalid")
+		req.False(found)
+		req.Len(invalid, 1)
+		req.Equal("invalid", *invalid[0].Service.Name)
+		req.Equal("test-registry.v1", invalid[0].ConfigType)
+		req.Error(invalid[0].Err)
+	})
+
+	t.Run("unknown service and unregistered type are not found", func(t *testing.T) {
+		req := require.New(t)
+		_, found := ServiceConfig[testRegistryConfig](ztx, "missing")
+		req.False(found)
+
+		_, found = ServiceConfig[struct{ Other string }](ztx, "valid")
+		req.False(found)
+	})
+
+	t.Run("duplicate registrations are refused", func(t *testing.T) {
+		req := require.New(t)
+		req.Error(registerConfigType[testRegistryConfig](registry, "test-registry.v2", ""))
+		req.Error(registerConfigType[struct{ Other string }](registry, "test-registry.v1", ""))
+		req.Error(registerConfigType[struct{ Invalid string }](registry, "test-registry.invalid", "{not json"))
+	})
+}
@@ -27,7 +27,6 @@ package ziti
 
 import (
 	"github.com/kataras/go-events"
-	"github.com/openziti/edge-api/rest_model"
 	edge_apis "github.com/openziti/sdk-golang/edge-apis"
 	"github.com/openziti/sdk-golang/ziti/edge"
 	"github.com/openziti/sdk-golang/ziti/edge/posture"
@@ -79,10 +78,12 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		Id:                NewId(),
 		routerConnections: cmap.New[edge.RouterConn](),
 		options:           options,
-		authQueryHandlers: map[string]func(query *rest_model.AuthQueryDetail, response MfaCodeResponse) error{},
+		authQueryHandlers: newAuthQueryRegistry(),
 		closeNotify:       make(chan struct{}),
 		EventEmmiter:      events.New(),
 	}
+	newContext.routerPool = newRouterPool(newContext)
+	newContext.eventBus = newEventBus()
 
 	if cfg == nil {
 		return nil, errors.New("a config is required")
@@ -106,7 +107,21 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		ConfigTypes:     cfg.ConfigTypes,
 	}
 
-	newContext.CtrlClt.PostureCache = posture.NewCache(newContext.CtrlClt, newContext.closeNotify)
+	newContext.CtrlClt.PostureCache = posture.NewCacheWithOptions(newContext.CtrlClt, newContext.closeNotify, &posture.CacheOptions{
+		Providers:    options.PostureProviders,
+		PollInterval: options.PosturePollInterval,
+		Simulate:     options.SimulatePosture,
+		OnSubmitted:  newContext.onPostureSubmitted,
+	})
+
+	if cfg.ControllerPins.isEnabled() {
+		newContext.CtrlClt.HttpTransport.TLSClientConfig.VerifyConnection =
+			cfg.ControllerPins.verifier(PinTargetController, cfg.ZtAPI, "", newContext.onPinViolation)
+	}
+
+	if cfg.RouterPins.isEnabled() {
+		newContext.routerPins = cfg.RouterPins
+	}
 
 	return newContext, nil
 }
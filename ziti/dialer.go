@@ -3,8 +3,6 @@ package ziti
 import (
 	"context"
 	"fmt"
-	"github.com/openziti/edge-api/rest_model"
-	"math"
 	"net"
 	"strconv"
 )
@@ -63,31 +61,8 @@ func (dialer *dialer) Dial(network, address string) (net.Conn, error) {
 
 	network = normalizeProtocol(network)
 
-	var ztx Context
-	var service *rest_model.ServiceDetail
-	var bestFound = false
-	best := math.MaxInt
-	dialer.collection.ForAll(func(ctx Context) {
-		if bestFound {
-			return
-		}
-
-		srv, score, err := ctx.GetServiceForAddr(network, host, uint16(port))
-		if err == nil {
-			if score < best {
-				best = score
-				ztx = ctx
-				service = srv
-			}
-
-			if score == 0 { // best possible score
-				bestFound = true
-			}
-		}
-	})
-
-	if ztx != nil && service != nil {
-		return ztx.(*ContextImpl).dialServiceFromAddr(*service.Name, network, host, uint16(port))
+	if ztx, service := dialer.collection.getServiceForAddr(network, host, uint16(port)); ztx != nil {
+		return ztx.(*ContextImpl).dialServiceFromAddr(context.Background(), service, network, host, ipOf(host), uint16(port))
 	}
 
 	if dialer.fallback != nil {
@@ -0,0 +1,145 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package dns
+
+import (
+	"container/list"
+	"github.com/pkg/errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReclaimAfter is how long a virtual IP has to go unused before it can be reclaimed for another hostname
+const DefaultReclaimAfter = 10 * time.Minute
+
+// IpPool allocates virtual IPs for hostnames from a CIDR. A hostname keeps its IP while it is in use, so that a virtual
+// IP can be mapped back to the hostname it was allocated for. Once the CIDR is exhausted, the least recently used IP
+// is reclaimed for a new hostname, if it hasn't been allocated or looked up for the pool's reclaim time.
+type IpPool struct {
+	lock         sync.Mutex
+	prefix       netip.Prefix
+	next         netip.Addr
+	reclaimAfter time.Duration
+	byHostname   map[string]*list.Element
+	byIp         map[netip.Addr]*list.Element
+	lru          *list.List // of *poolEntry, most recently used first
+	now          func() time.Time
+}
+
+type poolEntry struct {
+	hostname string
+	addr     netip.Addr
+	lastUsed time.Time
+}
+
+// NewIpPool returns a pool which allocates from the given CIDR, such as 100.64.0.0/10 or fd00:7a69::/64. The network
+// address itself is never allocated. IPs are reclaimed after DefaultReclaimAfter.
+func NewIpPool(cidr string) (*IpPool, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid virtual ip pool '%s'", cidr)
+	}
+	prefix = prefix.Masked()
+
+	return &IpPool{
+		prefix:       prefix,
+		next:         prefix.Addr().Next(),
+		reclaimAfter: DefaultReclaimAfter,
+		byHostname:   map[string]*list.Element{},
+		byIp:         map[netip.Addr]*list.Element{},
+		lru:          list.New(),
+		now:          time.Now,
+	}, nil
+}
+
+// SetReclaimAfter sets how long an IP has to go unused before it can be reclaimed for another hostname. It should be
+// longer than the TTL of the answers handing out the IPs, so that clients don't connect to IPs from expired answers.
+func (self *IpPool) SetReclaimAfter(reclaimAfter time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.reclaimAfter = reclaimAfter
+}
+
+// Allocate returns the virtual IP of the given hostname, allocating one if the hostname doesn't have one yet. An error
+// is returned if the pool is exhausted and no IP has been unused for long enough to be reclaimed.
+func (self *IpPool) Allocate(hostname string) (net.IP, error) {
+	hostname = strings.ToLower(hostname)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := self.now()
+
+	if element, found := self.byHostname[hostname]; found {
+		return self.touch(element, now).addr.AsSlice(), nil
+	}
+
+	var addr netip.Addr
+	if self.next.IsValid() && self.prefix.Contains(self.next) {
+		addr = self.next
+		self.next = addr.Next()
+	} else if oldest := self.lru.Back(); oldest != nil && now.Sub(oldest.Value.(*poolEntry).lastUsed) >= self.reclaimAfter {
+		entry := self.lru.Remove(oldest).(*poolEntry)
+		delete(self.byHostname, entry.hostname)
+		delete(self.byIp, entry.addr)
+		addr = entry.addr
+	} else {
+		return nil, errors.Errorf("virtual ip pool %v is exhausted", self.prefix)
+	}
+
+	element := self.lru.PushFront(&poolEntry{
+		hostname: hostname,
+		addr:     addr,
+		lastUsed: now,
+	})
+	self.byHostname[hostname] = element
+	self.byIp[addr] = element
+
+	return addr.AsSlice(), nil
+}
+
+// Lookup returns the hostname the given virtual IP was allocated for. A lookup counts as a use of the IP.
+func (self *IpPool) Lookup(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if element, found := self.byIp[addr.Unmap()]; found {
+		return self.touch(element, self.now()).hostname, true
+	}
+	return "", false
+}
+
+func (self *IpPool) touch(element *list.Element, now time.Time) *poolEntry {
+	entry := element.Value.(*poolEntry)
+	entry.lastUsed = now
+	self.lru.MoveToFront(element)
+	return entry
+}
+
+// Contains returns true if the given IP is in the pool's CIDR
+func (self *IpPool) Contains(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	return ok && self.prefix.Contains(addr.Unmap())
+}
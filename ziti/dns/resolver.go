@@ -0,0 +1,218 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package dns
+
+import (
+	ctx "context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver returns a resolver which answers lookups of intercepted hostnames with the server's virtual IPs, without
+// any network traffic. Other lookups are sent to Config.Upstream if it is set, or else to the nameservers of the
+// system configuration. The server doesn't need to be serving for the resolver to work.
+func (self *Server) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(_ ctx.Context, network, address string) (net.Conn, error) {
+			upstream := self.config.Upstream
+			if upstream == "" {
+				upstream = address
+			}
+
+			conn := newResolverConn(self, upstream, strings.HasPrefix(network, "tcp"))
+			if conn.stream {
+				return conn, nil
+			}
+			return &resolverPacketConn{resolverConn: conn}, nil
+		},
+	}
+}
+
+type resolverAddr struct{}
+
+func (resolverAddr) Network() string {
+	return "ziti-dns"
+}
+
+func (resolverAddr) String() string {
+	return "ziti-dns"
+}
+
+// resolverConn passes the queries written by a net.Resolver to the server in process. For udp lookups it is wrapped
+// in a resolverPacketConn, so that the resolver exchanges whole messages with it. For tcp lookups, which the resolver
+// makes when a udp response was truncated, messages are prefixed with their two byte length in both directions, and
+// queries which aren't intercepted are forwarded to the upstream server over tcp.
+type resolverConn struct {
+	server   *Server
+	upstream string
+	stream   bool
+
+	lock      sync.Mutex
+	responses chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	deadline  time.Time
+
+	// pending holds the part of a stream query which has been written so far, unread the rest of a stream response
+	pending []byte
+	unread  []byte
+}
+
+func newResolverConn(server *Server, upstream string, stream bool) *resolverConn {
+	return &resolverConn{
+		server:    server,
+		upstream:  upstream,
+		stream:    stream,
+		responses: make(chan []byte, 4),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (self *resolverConn) Write(b []byte) (int, error) {
+	select {
+	case <-self.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	if !self.stream {
+		self.respond(self.server.handle(b, self.upstream, false))
+		return len(b), nil
+	}
+
+	self.lock.Lock()
+	self.pending = append(self.pending, b...)
+	var queries [][]byte
+	for len(self.pending) >= 2 {
+		length := int(binary.BigEndian.Uint16(self.pending))
+		if len(self.pending) < 2+length {
+			break
+		}
+		queries = append(queries, self.pending[2:2+length])
+		self.pending = self.pending[2+length:]
+	}
+	self.lock.Unlock()
+
+	for _, query := range queries {
+		if response := self.server.handle(query, self.upstream, true); response != nil {
+			if len(response) > maxPacketSize {
+				return 0, errors.New("dns response is too large")
+			}
+			framed := make([]byte, 2, 2+len(response))
+			binary.BigEndian.PutUint16(framed, uint16(len(response)))
+			self.respond(append(framed, response...))
+		}
+	}
+
+	return len(b), nil
+}
+
+func (self *resolverConn) respond(response []byte) {
+	if response != nil {
+		select {
+		case self.responses <- response:
+		default:
+			// the resolver isn't reading responses, drop it like a lost packet
+		}
+	}
+}
+
+func (self *resolverConn) Read(b []byte) (int, error) {
+	self.lock.Lock()
+	deadline := self.deadline
+	if len(self.unread) > 0 {
+		n := copy(b, self.unread)
+		self.unread = self.unread[n:]
+		self.lock.Unlock()
+		return n, nil
+	}
+	self.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case response := <-self.responses:
+		n := copy(b, response)
+		if self.stream {
+			// a stream response may be read in parts
+			self.lock.Lock()
+			self.unread = response[n:]
+			self.lock.Unlock()
+		}
+		return n, nil
+	case <-self.closed:
+		return 0, net.ErrClosed
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (self *resolverConn) Close() error {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+	return nil
+}
+
+func (self *resolverConn) LocalAddr() net.Addr {
+	return resolverAddr{}
+}
+
+func (self *resolverConn) RemoteAddr() net.Addr {
+	return resolverAddr{}
+}
+
+func (self *resolverConn) SetDeadline(t time.Time) error {
+	return self.SetReadDeadline(t)
+}
+
+func (self *resolverConn) SetReadDeadline(t time.Time) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.deadline = t
+	return nil
+}
+
+func (self *resolverConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// resolverPacketConn implements net.PacketConn, so that a net.Resolver exchanges whole messages with it rather than
+// length prefixed ones
+type resolverPacketConn struct {
+	*resolverConn
+}
+
+func (self *resolverPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := self.Read(b)
+	return n, resolverAddr{}, err
+}
+
+func (self *resolverPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return self.Write(b)
+}
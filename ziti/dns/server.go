@@ -0,0 +1,499 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package dns provides a DNS server which resolves the hostnames intercepted by Ziti services to virtual IPs, so that
+// applications which resolve names before connecting can be routed over Ziti.
+//
+// Hostnames and wildcard domains from the intercept.v1 configs of the services of a set of contexts are answered with
+// IPs from a virtual IP pool. Each hostname gets its own IP, even when it matches a wildcard domain, so that a
+// connection to a virtual IP can be mapped back to the service and the hostname which was resolved. The server
+// registers its lookup with contexts which support it, such as *ziti.CtxCollection and *ziti.ContextImpl, so DialAddr
+// of the contexts accepts virtual IPs as well as Server.Dial:
+//
+//	collection := ziti.NewSdkCollection()
+//	...
+//	server, err := dns.NewServer(collection, &dns.Config{ListenAddr: "127.0.0.1:5353"})
+//	go server.ListenAndServe()
+//	...
+//	conn, err := server.Dial("tcp", "100.64.0.1:443")
+package dns
+
+import (
+	ctx "context"
+	"encoding/binary"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultListenAddr = "127.0.0.1:53"
+	DefaultIPv4Pool   = "100.64.0.0/10"
+	DefaultTTL        = time.Minute
+
+	upstreamTimeout = 5 * time.Second
+	maxPacketSize   = 65535
+)
+
+// Config configures a Server. Zero values are replaced with defaults.
+type Config struct {
+	// ListenAddr is the UDP address ListenAndServe listens on, DefaultListenAddr by default
+	ListenAddr string
+
+	// IPv4Pool is the CIDR virtual IPv4 addresses are allocated from, DefaultIPv4Pool by default
+	IPv4Pool string
+
+	// IPv6Pool is the CIDR virtual IPv6 addresses are allocated from. If empty, AAAA queries for intercepted hostnames
+	// are answered without any records, so clients fall back to IPv4.
+	IPv6Pool string
+
+	// TTL is the time to live of answers, DefaultTTL by default
+	TTL time.Duration
+
+	// ReclaimAfter is how long a virtual IP has to go unused before it can be handed out for another hostname once the
+	// pool is exhausted, DefaultReclaimAfter by default. It is never shorter than TTL.
+	ReclaimAfter time.Duration
+
+	// Upstream is the address of a DNS server, such as 1.1.1.1:53, which queries for hostnames that aren't intercepted
+	// are forwarded to. If empty, those queries are answered with NXDOMAIN.
+	Upstream string
+}
+
+// Contexts provides the intercepts which are resolved, and dials intercepted addresses. It is implemented by
+// *ziti.CtxCollection, as well as by a single ziti.Context.
+type Contexts interface {
+	GetServiceForHostname(hostname string) (*rest_model.ServiceDetail, bool)
+	DialAddr(network string, addr string) (edge.Conn, error)
+}
+
+// hostnameLookupRegistrar is implemented by contexts which can map virtual IPs back to hostnames in DialAddr
+type hostnameLookupRegistrar interface {
+	RegisterHostnameLookup(lookup func(ip net.IP) (string, bool)) func()
+}
+
+// Server answers DNS queries for intercepted hostnames with virtual IPs, and dials connections to those IPs over Ziti
+type Server struct {
+	contexts Contexts
+	config   Config
+	ipv4     *IpPool
+	ipv6     *IpPool
+
+	unregister func()
+
+	lock   sync.Mutex
+	conn   net.PacketConn
+	closed atomic.Bool
+}
+
+// NewServer returns a server resolving the intercepts of the given contexts. Call ListenAndServe or Serve to start
+// answering queries, and Close to release the server's lookup from the contexts.
+func NewServer(contexts Contexts, config *Config) (*Server, error) {
+	result := &Server{
+		contexts: contexts,
+	}
+
+	if config != nil {
+		result.config = *config
+	}
+
+	if result.config.ListenAddr == "" {
+		result.config.ListenAddr = DefaultListenAddr
+	}
+
+	if result.config.IPv4Pool == "" {
+		result.config.IPv4Pool = DefaultIPv4Pool
+	}
+
+	if result.config.TTL <= 0 {
+		result.config.TTL = DefaultTTL
+	}
+
+	if result.config.ReclaimAfter <= 0 {
+		result.config.ReclaimAfter = DefaultReclaimAfter
+	}
+
+	if result.config.ReclaimAfter < result.config.TTL {
+		result.config.ReclaimAfter = result.config.TTL
+	}
+
+	var err error
+	if result.ipv4, err = NewIpPool(result.config.IPv4Pool); err != nil {
+		return nil, err
+	}
+
+	if !result.ipv4.prefix.Addr().Is4() {
+		return nil, errors.Errorf("virtual ipv4 pool '%s' is not an ipv4 cidr", result.config.IPv4Pool)
+	}
+
+	if result.config.IPv6Pool != "" {
+		if result.ipv6, err = NewIpPool(result.config.IPv6Pool); err != nil {
+			return nil, err
+		}
+
+		if !result.ipv6.prefix.Addr().Is6() {
+			return nil, errors.Errorf("virtual ipv6 pool '%s' is not an ipv6 cidr", result.config.IPv6Pool)
+		}
+		result.ipv6.SetReclaimAfter(result.config.ReclaimAfter)
+	}
+	result.ipv4.SetReclaimAfter(result.config.ReclaimAfter)
+
+	if registrar, ok := contexts.(hostnameLookupRegistrar); ok {
+		result.unregister = registrar.RegisterHostnameLookup(result.LookupHostname)
+	}
+
+	return result, nil
+}
+
+// ListenAndServe listens on Config.ListenAddr and serves queries until the server is closed
+func (self *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", self.config.ListenAddr)
+	if err != nil {
+		return err
+	}
+	return self.Serve(conn)
+}
+
+// Serve answers queries received on conn until the server is closed. Serve closes conn when it returns.
+func (self *Server) Serve(conn net.PacketConn) error {
+	self.lock.Lock()
+	if self.closed.Load() {
+		self.lock.Unlock()
+		_ = conn.Close()
+		return net.ErrClosed
+	}
+	self.conn = conn
+	self.lock.Unlock()
+
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if self.closed.Load() {
+				return nil
+			}
+			return err
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		go func() {
+			if response := self.handle(query, self.config.Upstream, false); response != nil {
+				if _, err := conn.WriteTo(response, addr); err != nil {
+					pfxlog.Logger().WithError(err).WithField("client", addr.String()).Debug("failed to write dns response")
+				}
+			}
+		}()
+	}
+}
+
+// Addr returns the address the server is serving on, or nil if it isn't serving
+func (self *Server) Addr() net.Addr {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.conn == nil {
+		return nil
+	}
+	return self.conn.LocalAddr()
+}
+
+// Close stops the server and removes its lookup from the contexts
+func (self *Server) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	if self.unregister != nil {
+		self.unregister()
+	}
+
+	if self.conn != nil {
+		return self.conn.Close()
+	}
+	return nil
+}
+
+// LookupIP returns the virtual IPs of an intercepted hostname, allocating them if necessary. False is returned if the
+// hostname isn't intercepted by any of the contexts.
+func (self *Server) LookupIP(hostname string) ([]net.IP, bool, error) {
+	hostname = normalizeHostname(hostname)
+	if !self.isIntercepted(hostname) {
+		return nil, false, nil
+	}
+
+	ipv4, err := self.ipv4.Allocate(hostname)
+	if err != nil {
+		return nil, true, err
+	}
+
+	result := []net.IP{ipv4}
+	if self.ipv6 != nil {
+		ipv6, err := self.ipv6.Allocate(hostname)
+		if err != nil {
+			return nil, true, err
+		}
+		result = append(result, ipv6)
+	}
+
+	return result, true, nil
+}
+
+// LookupHostname returns the intercepted hostname a virtual IP was allocated for
+func (self *Server) LookupHostname(ip net.IP) (string, bool) {
+	if hostname, found := self.ipv4.Lookup(ip); found {
+		return hostname, true
+	}
+
+	if self.ipv6 != nil {
+		return self.ipv6.Lookup(ip)
+	}
+
+	return "", false
+}
+
+// Dial connects to the given address over Ziti. If the address is a virtual IP, the hostname it was allocated for is
+// dialed instead, using DialAddr of the contexts.
+func (self *Server) Dial(network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if hostname, found := self.LookupHostname(ip); found {
+			host = hostname
+		} else if self.ipv4.Contains(ip) || (self.ipv6 != nil && self.ipv6.Contains(ip)) {
+			return nil, errors.Errorf("virtual ip %s has not been allocated", ip)
+		}
+	}
+
+	conn, err := self.contexts.DialAddr(network, net.JoinHostPort(host, portStr))
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DialContext is the same as Dial. It allows the server to be used as the dialer of an http.Transport.
+func (self *Server) DialContext(_ ctx.Context, network, address string) (net.Conn, error) {
+	return self.Dial(network, address)
+}
+
+func (self *Server) isIntercepted(hostname string) bool {
+	_, found := self.contexts.GetServiceForHostname(hostname)
+	return found
+}
+
+// handle returns the response to a query, or nil if no response should be sent. Queries for names which aren't
+// intercepted are forwarded to upstream, if it isn't empty. They are forwarded over tcp if stream is set, as the query
+// was then received over a stream, usually after a truncated udp response.
+func (self *Server) handle(query []byte, upstream string, stream bool) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		pfxlog.Logger().WithError(err).Debug("received invalid dns query")
+		return nil
+	}
+
+	if header.Response {
+		return nil
+	}
+
+	questions, err := parser.AllQuestions()
+	if err != nil || header.OpCode != 0 || len(questions) != 1 {
+		rcode := dnsmessage.RCodeFormatError
+		if err == nil && header.OpCode != 0 {
+			rcode = dnsmessage.RCodeNotImplemented
+		}
+		return self.response(header, questions, rcode, nil)
+	}
+
+	question := questions[0]
+	hostname := normalizeHostname(question.Name.String())
+
+	if question.Class != dnsmessage.ClassINET || !self.isIntercepted(hostname) {
+		if upstream != "" {
+			return self.forward(upstream, stream, header, questions, query)
+		}
+		return self.response(header, questions, dnsmessage.RCodeNameError, nil)
+	}
+
+	var pool *IpPool
+	switch question.Type {
+	case dnsmessage.TypeA:
+		pool = self.ipv4
+	case dnsmessage.TypeAAAA:
+		pool = self.ipv6
+	}
+
+	if pool == nil {
+		// the name exists, but has no records of the requested type
+		return self.response(header, questions, dnsmessage.RCodeSuccess, nil)
+	}
+
+	ip, err := pool.Allocate(hostname)
+	if err != nil {
+		pfxlog.Logger().WithError(err).WithField("hostname", hostname).Error("unable to allocate virtual ip")
+		return self.response(header, questions, dnsmessage.RCodeServerFailure, nil)
+	}
+
+	return self.response(header, questions, dnsmessage.RCodeSuccess, ip)
+}
+
+func (self *Server) response(query dnsmessage.Header, questions []dnsmessage.Question, rcode dnsmessage.RCode, ip net.IP) []byte {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 query.ID,
+		Response:           true,
+		OpCode:             query.OpCode,
+		Authoritative:      rcode == dnsmessage.RCodeSuccess || rcode == dnsmessage.RCodeNameError,
+		RecursionDesired:   query.RecursionDesired,
+		RecursionAvailable: self.config.Upstream != "",
+		RCode:              rcode,
+	})
+	builder.EnableCompression()
+
+	if err := self.buildResponse(&builder, questions, ip); err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to build dns response")
+		return nil
+	}
+
+	result, err := builder.Finish()
+	if err != nil {
+		pfxlog.Logger().WithError(err).Error("unable to build dns response")
+		return nil
+	}
+	return result
+}
+
+func (self *Server) buildResponse(builder *dnsmessage.Builder, questions []dnsmessage.Question, ip net.IP) error {
+	if err := builder.StartQuestions(); err != nil {
+		return err
+	}
+
+	for _, question := range questions {
+		if err := builder.Question(question); err != nil {
+			return err
+		}
+	}
+
+	if err := builder.StartAnswers(); err != nil {
+		return err
+	}
+
+	if ip == nil {
+		return nil
+	}
+
+	header := dnsmessage.ResourceHeader{
+		Name:  questions[0].Name,
+		Class: dnsmessage.ClassINET,
+		TTL:   uint32(self.config.TTL / time.Second),
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		resource := dnsmessage.AResource{}
+		copy(resource.A[:], ipv4)
+		return builder.AResource(header, resource)
+	}
+
+	resource := dnsmessage.AAAAResource{}
+	copy(resource.AAAA[:], ip.To16())
+	return builder.AAAAResource(header, resource)
+}
+
+// forward relays a query to the upstream server, answering with SERVFAIL if the upstream server can't be reached
+func (self *Server) forward(upstream string, stream bool, header dnsmessage.Header, questions []dnsmessage.Question, query []byte) []byte {
+	response, err := func() ([]byte, error) {
+		network := "udp"
+		if stream {
+			network = "tcp"
+		}
+
+		conn, err := net.DialTimeout(network, upstream, upstreamTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = conn.Close() }()
+
+		if err = conn.SetDeadline(time.Now().Add(upstreamTimeout)); err != nil {
+			return nil, err
+		}
+
+		if stream {
+			return streamRoundTrip(conn, query)
+		}
+
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, maxPacketSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}()
+
+	if err != nil {
+		pfxlog.Logger().WithError(err).WithField("upstream", upstream).Warn("unable to forward dns query")
+		return self.response(header, questions, dnsmessage.RCodeServerFailure, nil)
+	}
+
+	return response
+}
+
+// streamRoundTrip exchanges a message over a stream, where each message is prefixed with its two byte length
+func streamRoundTrip(conn net.Conn, query []byte) ([]byte, error) {
+	if len(query) > maxPacketSize {
+		return nil, errors.New("dns query is too large")
+	}
+
+	msg := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
@@ -0,0 +1,294 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testContext intercepts a fixed set of addresses on tcp port 443, and records DialAddr calls
+type testContext struct {
+	intercept *edge.InterceptV1Config
+	dialed    []string
+}
+
+func newTestContext(addresses ...string) *testContext {
+	serviceName := "svc"
+	intercept := &edge.InterceptV1Config{
+		Protocols:  []string{"tcp"},
+		PortRanges: []*edge.PortRange{{Low: 443, High: 443}},
+		Service:    &rest_model.ServiceDetail{Name: &serviceName},
+	}
+	for _, address := range addresses {
+		addr, err := edge.NewZitiAddress(address)
+		if err != nil {
+			panic(err)
+		}
+		intercept.Addresses = append(intercept.Addresses, *addr)
+	}
+	return &testContext{intercept: intercept}
+}
+
+func (self *testContext) GetServiceForHostname(hostname string) (*rest_model.ServiceDetail, bool) {
+	return self.intercept.Service, self.intercept.MatchAddress(hostname) != -1
+}
+
+func (self *testContext) DialAddr(network string, addr string) (edge.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if port != "443" || self.intercept.Match(network, host, 443) == -1 {
+		return nil, errors.Errorf("address %s is not intercepted", addr)
+	}
+
+	self.dialed = append(self.dialed, addr)
+	return nil, errors.New("dial not supported")
+}
+
+func newQuery(t *testing.T, hostname string, qtype dnsmessage.Type) []byte {
+	req := require.New(t)
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1234, RecursionDesired: true})
+	req.NoError(builder.StartQuestions())
+	req.NoError(builder.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(hostname + "."),
+		Type:  qtype,
+		Class: dnsmessage.ClassINET,
+	}))
+	packet, err := builder.Finish()
+	req.NoError(err)
+	return packet
+}
+
+func query(t *testing.T, server *Server, hostname string, qtype dnsmessage.Type) *dnsmessage.Message {
+	req := require.New(t)
+	packet := newQuery(t, hostname, qtype)
+
+	conn, err := net.Dial("udp", server.Addr().String())
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	req.NoError(conn.SetDeadline(time.Now().Add(5 * time.Second)))
+	_, err = conn.Write(packet)
+	req.NoError(err)
+
+	buf := make([]byte, maxPacketSize)
+	n, err := conn.Read(buf)
+	req.NoError(err)
+
+	response := &dnsmessage.Message{}
+	req.NoError(response.Unpack(buf[:n]))
+	req.Equal(uint16(1234), response.ID)
+	req.True(response.Response)
+	return response
+}
+
+func Test_Server(t *testing.T) {
+	req := require.New(t)
+
+	ztx := newTestContext("db.ziti", "*.apps.ziti")
+
+	server, err := NewServer(ztx, &Config{IPv4Pool: "100.64.0.0/24", IPv6Pool: "fd00:7a69::/120"})
+	req.NoError(err)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	req.NoError(err)
+	go func() { _ = server.Serve(conn) }()
+	defer func() { _ = server.Close() }()
+	req.Eventually(func() bool { return server.Addr() != nil }, time.Second, 10*time.Millisecond)
+
+	var dbIp net.IP
+
+	t.Run("hostnames are resolved to stable virtual ips", func(t *testing.T) {
+		req := require.New(t)
+		response := query(t, server, "DB.ziti", dnsmessage.TypeA)
+		req.Equal(dnsmessage.RCodeSuccess, response.RCode)
+		req.Len(response.Answers, 1)
+
+		a := response.Answers[0].Body.(*dnsmessage.AResource)
+		dbIp = net.IP(a.A[:])
+		req.Equal("100.64.0.1", dbIp.String())
+		req.Equal(uint32(DefaultTTL/time.Second), response.Answers[0].Header.TTL)
+
+		response = query(t, server, "db.ziti", dnsmessage.TypeA)
+		req.Equal(dbIp, net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]))
+	})
+
+	t.Run("wildcard hostnames each get their own ip", func(t *testing.T) {
+		req := require.New(t)
+		first := query(t, server, "one.apps.ziti", dnsmessage.TypeA)
+		second := query(t, server, "two.apps.ziti", dnsmessage.TypeA)
+		req.Len(first.Answers, 1)
+		req.Len(second.Answers, 1)
+		req.NotEqual(first.Answers[0].Body, second.Answers[0].Body)
+
+		hostname, found := server.LookupHostname(first.Answers[0].Body.(*dnsmessage.AResource).A[:])
+		req.True(found)
+		req.Equal("one.apps.ziti", hostname)
+	})
+
+	t.Run("AAAA queries are answered from the ipv6 pool", func(t *testing.T) {
+		req := require.New(t)
+		response := query(t, server, "db.ziti", dnsmessage.TypeAAAA)
+		req.Equal(dnsmessage.RCodeSuccess, response.RCode)
+		req.Len(response.Answers, 1)
+
+		aaaa := response.Answers[0].Body.(*dnsmessage.AAAAResource)
+		req.Equal("fd00:7a69::1", net.IP(aaaa.AAAA[:]).String())
+	})
+
+	t.Run("other names are not found", func(t *testing.T) {
+		req := require.New(t)
+		response := query(t, server, "example.com", dnsmessage.TypeA)
+		req.Equal(dnsmessage.RCodeNameError, response.RCode)
+		req.Empty(response.Answers)
+	})
+
+	t.Run("dials to virtual ips use the original hostname", func(t *testing.T) {
+		req := require.New(t)
+		_, err := server.Dial("tcp", net.JoinHostPort(dbIp.String(), "443"))
+		req.Error(err)
+		req.Equal([]string{"db.ziti:443"}, ztx.dialed)
+
+		_, err = server.Dial("tcp", "100.64.0.200:443")
+		req.ErrorContains(err, "has not been allocated")
+
+		_, err = server.Dial("tcp", net.JoinHostPort(dbIp.String(), "80"))
+		req.ErrorContains(err, "not intercepted")
+	})
+}
+
+func Test_Server_Resolver(t *testing.T) {
+	server, err := NewServer(newTestContext("db.ziti"), &Config{IPv4Pool: "100.64.0.0/24"})
+	require.NoError(t, err)
+	resolver := server.Resolver()
+
+	t.Run("udp lookups exchange whole messages", func(t *testing.T) {
+		req := require.New(t)
+
+		conn, err := resolver.Dial(context.Background(), "udp", "127.0.0.1:53")
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+		_, isPacketConn := conn.(net.PacketConn)
+		req.True(isPacketConn)
+
+		addrs, err := resolver.LookupHost(context.Background(), "db.ziti")
+		req.NoError(err)
+		req.Equal([]string{"100.64.0.1"}, addrs)
+	})
+
+	t.Run("tcp lookups exchange length prefixed messages", func(t *testing.T) {
+		req := require.New(t)
+
+		conn, err := resolver.Dial(context.Background(), "tcp", "127.0.0.1:53")
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+		_, isPacketConn := conn.(net.PacketConn)
+		req.False(isPacketConn)
+		req.NoError(conn.SetDeadline(time.Now().Add(5 * time.Second)))
+
+		packet := newQuery(t, "db.ziti", dnsmessage.TypeA)
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+		framed = append(framed, packet...)
+
+		// the query may arrive in parts
+		_, err = conn.Write(framed[:5])
+		req.NoError(err)
+		_, err = conn.Write(framed[5:])
+		req.NoError(err)
+
+		var length [2]byte
+		_, err = io.ReadFull(conn, length[:])
+		req.NoError(err)
+
+		body := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = io.ReadFull(conn, body)
+		req.NoError(err)
+
+		response := &dnsmessage.Message{}
+		req.NoError(response.Unpack(body))
+		req.Equal(uint16(1234), response.ID)
+		req.Len(response.Answers, 1)
+		req.Equal("100.64.0.1", net.IP(response.Answers[0].Body.(*dnsmessage.AResource).A[:]).String())
+	})
+}
+
+func Test_IpPool(t *testing.T) {
+	req := require.New(t)
+
+	pool, err := NewIpPool("10.0.0.0/30")
+	req.NoError(err)
+
+	for i, hostname := range []string{"a.ziti", "b.ziti", "c.ziti"} {
+		ip, err := pool.Allocate(hostname)
+		req.NoError(err)
+		req.Equal(net.IPv4(10, 0, 0, byte(i+1)).To4(), ip)
+	}
+
+	_, err = pool.Allocate("d.ziti")
+	req.ErrorContains(err, "exhausted")
+
+	ip, err := pool.Allocate("B.ziti")
+	req.NoError(err)
+	req.Equal("10.0.0.2", ip.String())
+
+	hostname, found := pool.Lookup(net.ParseIP("10.0.0.3"))
+	req.True(found)
+	req.Equal("c.ziti", hostname)
+}
+
+func Test_IpPool_reclaim(t *testing.T) {
+	req := require.New(t)
+
+	pool, err := NewIpPool("10.0.0.0/30")
+	req.NoError(err)
+	pool.SetReclaimAfter(time.Minute)
+
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	_, err = pool.Allocate("a.ziti")
+	req.NoError(err)
+	_, err = pool.Allocate("b.ziti")
+	req.NoError(err)
+	_, err = pool.Allocate("c.ziti")
+	req.NoError(err)
+
+	now = now.Add(30 * time.Second)
+	_, err = pool.Allocate("d.ziti")
+	req.ErrorContains(err, "exhausted")
+
+	// a.ziti is looked up, so b.ziti becomes the least recently used
+	_, found := pool.Lookup(net.ParseIP("10.0.0.1"))
+	req.True(found)
+
+	now = now.Add(time.Minute)
+	ip, err := pool.Allocate("d.ziti")
+	req.NoError(err)
+	req.Equal("10.0.0.2", ip.String())
+
+	hostname, found := pool.Lookup(net.ParseIP("10.0.0.2"))
+	req.True(found)
+	req.Equal("d.ziti", hostname)
+
+	_, found = pool.Lookup(net.ParseIP("10.0.0.1"))
+	req.True(found)
+
+	ip, err = pool.Allocate("b.ziti")
+	req.NoError(err)
+	req.Equal("10.0.0.3", ip.String())
+
+	_, err = pool.Allocate("e.ziti")
+	req.ErrorContains(err, "exhausted")
+}
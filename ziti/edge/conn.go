@@ -17,6 +17,7 @@
 package edge
 
 import (
+	"context"
 	"fmt"
 	"github.com/openziti/edge-api/rest_model"
 	"io"
@@ -29,6 +30,7 @@ import (
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/channel/v2"
 	"github.com/openziti/foundation/v2/sequence"
+	"github.com/pkg/errors"
 )
 
 func init() {
@@ -47,6 +49,33 @@ type RouterConn interface {
 	IsClosed() bool
 	Key() string
 	GetRouterName() string
+	GetConnections() []*ConnInfo
+}
+
+const (
+	ConnInfoTypeDial     = "dial"
+	ConnInfoTypeBind     = "bind"
+	ConnInfoTypeAccepted = "accepted"
+)
+
+// ConnInfo is a point-in-time snapshot of an edge connection. Byte counts are payload bytes as sent over the router
+// connection, so they include encryption overhead for end-to-end encrypted connections.
+type ConnInfo struct {
+	Id             uint32
+	Type           string
+	ServiceName    string
+	RouterName     string
+	RouterKey      string
+	SourceIdentity string
+	Encrypted      bool
+	OpenedAt       time.Time
+	LastActivity   time.Time
+	BytesIn        uint64
+	BytesOut       uint64
+	MessagesIn     uint64
+	MessagesOut    uint64
+	ReadQueueLen   int
+	ReadQueueBytes int64
 }
 
 type Identifiable interface {
@@ -91,6 +120,15 @@ type Conn interface {
 	CompleteAcceptFailed(err error)
 }
 
+// TraceContextProvider is implemented by connections which can carry the trace context propagated by the dialing
+// side. Connections returned by the SDK implement it, check for it with a type assertion on the Conn.
+type TraceContextProvider interface {
+	// TraceContext returns a context carrying the trace context propagated by the dialing side, so that work done
+	// for an accepted connection can be recorded as part of the caller's trace. If the dialer did not propagate a
+	// trace, or the connection was not accepted, context.Background() is returned.
+	TraceContext() context.Context
+}
+
 const forever = time.Hour * 24 * 365 * 100
 
 type MsgChannel struct {
@@ -199,11 +237,122 @@ type ConnOptions interface {
 	GetConnectTimeout() time.Duration
 }
 
+// ReadQueueOverflowPolicy determines what a connection does when a message arrives from the router and its read
+// queue is already full.
+type ReadQueueOverflowPolicy byte
+
+const (
+	// ReadQueueOverflowBlock blocks delivery until the reader frees space. This provides flow control, but a slow
+	// reader will stall every other connection multiplexed on the same router connection.
+	ReadQueueOverflowBlock ReadQueueOverflowPolicy = iota
+
+	// ReadQueueOverflowDropAndClose closes the connection when the queue overflows, releasing the router connection.
+	// Reads and writes then fail with a read queue overflow error rather than io.EOF, so truncated data can be told
+	// apart from a normal close.
+	ReadQueueOverflowDropAndClose
+
+	// ReadQueueOverflowSpill moves overflowing messages into an unbounded in-memory buffer which is drained as the
+	// reader catches up. Memory use is bounded only by how far behind the reader falls.
+	ReadQueueOverflowSpill
+)
+
+const DefaultReadQueueMaxMessages = 4
+
+func (p ReadQueueOverflowPolicy) String() string {
+	switch p {
+	case ReadQueueOverflowBlock:
+		return "block"
+	case ReadQueueOverflowDropAndClose:
+		return "drop-and-close"
+	case ReadQueueOverflowSpill:
+		return "spill"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(p))
+}
+
+// ReadQueueOptions configures the buffering between the router connection and a connection's Read calls.
+type ReadQueueOptions struct {
+	// MaxMessages is the number of messages that may be queued. Values less than one use DefaultReadQueueMaxMessages.
+	MaxMessages int
+
+	// MaxBytes is the number of payload bytes that may be queued. Zero means no byte limit. A single message larger
+	// than MaxBytes is still accepted into an empty queue.
+	MaxBytes int
+
+	// OverflowPolicy is applied when either limit would be exceeded.
+	OverflowPolicy ReadQueueOverflowPolicy
+}
+
+func (options *ReadQueueOptions) GetMaxMessages() int {
+	if options == nil || options.MaxMessages < 1 {
+		return DefaultReadQueueMaxMessages
+	}
+	return options.MaxMessages
+}
+
+func (options *ReadQueueOptions) GetMaxBytes() int {
+	if options == nil || options.MaxBytes < 0 {
+		return 0
+	}
+	return options.MaxBytes
+}
+
+func (options *ReadQueueOptions) GetOverflowPolicy() ReadQueueOverflowPolicy {
+	if options == nil {
+		return ReadQueueOverflowBlock
+	}
+	return options.OverflowPolicy
+}
+
+var (
+	// ErrConnIdleTimeout is returned from reads and writes on a connection which was closed because it saw no traffic
+	// for longer than its idle timeout.
+	ErrConnIdleTimeout = errors.New("connection closed after idle timeout")
+
+	// ErrConnKeepAliveFailed is returned from reads and writes on a connection which was closed because the far side
+	// did not answer a keepalive probe.
+	ErrConnKeepAliveFailed = errors.New("connection closed after keepalive probe failed")
+)
+
+const DefaultKeepAliveTimeout = 10 * time.Second
+
+// LivenessOptions configures detection of abandoned connections and of connections whose far side has silently gone
+// away, without waiting for the whole router connection to fail.
+type LivenessOptions struct {
+	// IdleTimeout closes the connection with ErrConnIdleTimeout if no data is sent or received for this long. Zero
+	// disables the idle timeout.
+	IdleTimeout time.Duration
+
+	// KeepAliveInterval sends a probe over the connection, which the far side answers, whenever nothing has been
+	// received for this long. If the probe isn't answered the connection is closed with ErrConnKeepAliveFailed. Zero
+	// disables keepalives.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveTimeout is how long to wait for a probe to be answered. Defaults to DefaultKeepAliveTimeout.
+	KeepAliveTimeout time.Duration
+
+	// OnExpired, if set, is called after a connection is closed by an idle timeout or failed keepalive.
+	OnExpired func(conn Conn, err error)
+}
+
+func (options *LivenessOptions) IsEnabled() bool {
+	return options != nil && (options.IdleTimeout > 0 || options.KeepAliveInterval > 0)
+}
+
+func (options *LivenessOptions) GetKeepAliveTimeout() time.Duration {
+	if options.KeepAliveTimeout <= 0 {
+		return DefaultKeepAliveTimeout
+	}
+	return options.KeepAliveTimeout
+}
+
 type DialOptions struct {
 	ConnectTimeout time.Duration
 	Identity       string
 	CallerId       string
 	AppData        []byte
+	ReadQueue      *ReadQueueOptions
+	Liveness       *LivenessOptions
 }
 
 func (d DialOptions) GetConnectTimeout() time.Duration {
@@ -219,6 +368,12 @@ type ListenOptions struct {
 	IdentitySecret        string
 	BindUsingEdgeIdentity bool
 	ManualStart           bool
+	ReadQueue             *ReadQueueOptions
+	Liveness              *LivenessOptions
+
+	// TraceExtractor, if set, is used to turn trace context propagated in the app data of incoming dials into the
+	// context returned by TraceContextProvider.TraceContext on accepted connections.
+	TraceExtractor func(carrier map[string]string) context.Context
 }
 
 func (options *ListenOptions) GetConnectTimeout() time.Duration {
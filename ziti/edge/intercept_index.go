@@ -0,0 +1,305 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// InterceptIndex finds the intercept which best matches an address without evaluating every intercept. Exact IPs and
+// hostnames are hashed, CIDRs are held in a binary trie per address family, wildcard domains are hashed by suffix and
+// the port ranges of each intercept are sorted, so a lookup only scores the intercepts which can match.
+//
+// Results are identical to calling Match on each intercept and picking the lowest score, with ties going to the
+// alphabetically first service name. An index is immutable, and is rebuilt when intercepts change.
+type InterceptIndex struct {
+	intercepts []*indexedIntercept
+
+	ips       map[netip.Addr][]int
+	cidrs4    *cidrTrieNode
+	cidrs6    *cidrTrieNode
+	hostnames map[string][]int
+	wildcards map[string][]int // keyed by the wildcard domain without the '*', such as '.example.com'
+}
+
+type indexedIntercept struct {
+	intercept  *InterceptV1Config
+	name       string
+	protocols  map[string]struct{}
+	portRanges []*PortRange // sorted by Low
+}
+
+type cidrTrieNode struct {
+	children [2]*cidrTrieNode
+	groups   []addressGroup
+}
+
+// addressGroup holds the ids of the intercepts with an address matching a target with the same score
+type addressGroup struct {
+	score int
+	ids   []int
+}
+
+// NewInterceptIndex indexes the given intercepts. Intercepts without a service are indexed with an empty service name.
+func NewInterceptIndex(intercepts []*InterceptV1Config) *InterceptIndex {
+	index := &InterceptIndex{
+		ips:       map[netip.Addr][]int{},
+		cidrs4:    &cidrTrieNode{},
+		cidrs6:    &cidrTrieNode{},
+		hostnames: map[string][]int{},
+		wildcards: map[string][]int{},
+	}
+
+	for _, intercept := range intercepts {
+		if intercept == nil {
+			continue
+		}
+
+		entry := &indexedIntercept{
+			intercept:  intercept,
+			protocols:  map[string]struct{}{},
+			portRanges: make([]*PortRange, 0, len(intercept.PortRanges)),
+		}
+
+		if intercept.Service != nil && intercept.Service.Name != nil {
+			entry.name = *intercept.Service.Name
+		}
+
+		for _, protocol := range intercept.Protocols {
+			entry.protocols[protocol] = struct{}{}
+		}
+
+		for _, portRange := range intercept.PortRanges {
+			if portRange != nil {
+				entry.portRanges = append(entry.portRanges, portRange)
+			}
+		}
+		sort.Slice(entry.portRanges, func(i, j int) bool {
+			return entry.portRanges[i].Low < entry.portRanges[j].Low
+		})
+
+		id := len(index.intercepts)
+		index.intercepts = append(index.intercepts, entry)
+
+		for i := range intercept.Addresses {
+			index.addAddress(id, &intercept.Addresses[i])
+		}
+	}
+
+	return index
+}
+
+func (self *InterceptIndex) addAddress(id int, address *ZitiAddress) {
+	if address.ip != nil {
+		if addr, ok := netip.AddrFromSlice(address.ip); ok {
+			addr = addr.Unmap()
+			self.ips[addr] = appendId(self.ips[addr], id)
+		}
+		return
+	}
+
+	if address.cidr != nil {
+		ones, bits := address.cidr.Mask.Size()
+		score := bits - ones
+
+		// like net.IPNet.Contains, IPv4-mapped IPv6 CIDRs match IPv4 addresses using the last 32 bits of their mask
+		if ip4 := address.cidr.IP.To4(); ip4 != nil {
+			ones4 := ones - (bits - 8*net.IPv4len)
+			if ones4 < 0 {
+				ones4 = 0
+			}
+			self.cidrs4.add(ip4, ones4, score, id)
+		} else {
+			self.cidrs6.add(address.cidr.IP, ones, score, id)
+		}
+		return
+	}
+
+	if len(address.domain) == 0 {
+		return
+	}
+
+	if address.domain[0] == '*' {
+		suffix := string(address.domain[1:])
+		self.wildcards[suffix] = appendId(self.wildcards[suffix], id)
+	} else {
+		hostname := string(address.domain)
+		self.hostnames[hostname] = appendId(self.hostnames[hostname], id)
+	}
+}
+
+// appendId adds an intercept id to a list, ignoring an intercept listing the same address twice
+func appendId(ids []int, id int) []int {
+	if len(ids) > 0 && ids[len(ids)-1] == id {
+		return ids
+	}
+	return append(ids, id)
+}
+
+func (self *cidrTrieNode) add(ip net.IP, ones int, score int, id int) {
+	node := self
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrTrieNode{}
+		}
+		node = node.children[bit]
+	}
+
+	for i := range node.groups {
+		if node.groups[i].score == score {
+			node.groups[i].ids = appendId(node.groups[i].ids, id)
+			return
+		}
+	}
+	node.groups = append(node.groups, addressGroup{score: score, ids: []int{id}})
+}
+
+// appendMatches adds the groups of every CIDR containing ip to matches
+func (self *cidrTrieNode) appendMatches(ip net.IP, matches []addressGroup) []addressGroup {
+	node := self
+	for i := 0; node != nil; i++ {
+		matches = append(matches, node.groups...)
+
+		if i == len(ip)*8 {
+			break
+		}
+
+		node = node.children[ip[i/8]>>(7-i%8)&1]
+	}
+	return matches
+}
+
+// Match returns the intercept which best matches the given address, and its score as computed by
+// InterceptV1Config.Match. Nil and -1 are returned if no intercept matches.
+func (self *InterceptIndex) Match(network, hostname string, port uint16) (*InterceptV1Config, int) {
+	best := -1
+	var result *indexedIntercept
+
+	for _, group := range self.addressMatches(hostname) {
+		// port scores fit in the lower 16 bits, so an intercept with a worse address score can't win
+		if result != nil && group.score > best>>16 {
+			break
+		}
+
+		for _, id := range group.ids {
+			entry := self.intercepts[id]
+			if _, found := entry.protocols[network]; !found {
+				continue
+			}
+
+			portScore := entry.matchPort(port)
+			if portScore == -1 {
+				continue
+			}
+
+			score := int(uint(group.score)<<16 | (uint(portScore) & 0xFFFF))
+			if best == -1 || score < best || (score == best && entry.name < result.name) {
+				best = score
+				result = entry
+			}
+		}
+	}
+
+	if result == nil {
+		return nil, -1
+	}
+	return result.intercept, best
+}
+
+// MatchAddress returns the intercept with the address which best matches the given hostname or IP, regardless of
+// protocol and port, and its score as computed by InterceptV1Config.MatchAddress. Nil and -1 are returned if no
+// intercept matches.
+func (self *InterceptIndex) MatchAddress(hostname string) (*InterceptV1Config, int) {
+	best := -1
+	var result *indexedIntercept
+
+	for _, group := range self.addressMatches(hostname) {
+		if result != nil && group.score > best {
+			break
+		}
+
+		for _, id := range group.ids {
+			if entry := self.intercepts[id]; result == nil || entry.name < result.name {
+				best = group.score
+				result = entry
+			}
+		}
+	}
+
+	if result == nil {
+		return nil, -1
+	}
+	return result.intercept, best
+}
+
+// addressMatches returns the groups of intercepts having an address which matches hostname, ordered by score. An
+// intercept may appear in several groups, in which case its first group holds its best address score.
+func (self *InterceptIndex) addressMatches(hostname string) []addressGroup {
+	var matches []addressGroup
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			if ids := self.ips[addr.Unmap()]; len(ids) > 0 {
+				matches = append(matches, addressGroup{score: 0, ids: ids})
+			}
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			matches = self.cidrs4.appendMatches(ip4, matches)
+		} else {
+			matches = self.cidrs6.appendMatches(ip, matches)
+		}
+	} else {
+		hostname = strings.ToLower(hostname)
+		if ids := self.hostnames[hostname]; len(ids) > 0 {
+			matches = append(matches, addressGroup{score: 0, ids: ids})
+		}
+
+		for i := 0; i < len(hostname); i++ {
+			if hostname[i] == '.' {
+				if ids := self.wildcards[hostname[i:]]; len(ids) > 0 {
+					matches = append(matches, addressGroup{score: i, ids: ids})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+	return matches
+}
+
+// matchPort returns the width of the narrowest port range containing port, or -1 if none does
+func (self *indexedIntercept) matchPort(port uint16) int {
+	// only ranges starting at or below the port can contain it
+	end := sort.Search(len(self.portRanges), func(i int) bool {
+		return self.portRanges[i].Low > port
+	})
+
+	result := -1
+	for _, portRange := range self.portRanges[:end] {
+		if score := portRange.Match(port); score != -1 && (result == -1 || score < result) {
+			result = score
+		}
+	}
+	return result
+}
@@ -0,0 +1,220 @@
+package edge
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+// linearMatch is the reference implementation the index must agree with
+func linearMatch(intercepts []*InterceptV1Config, network, hostname string, port uint16) (*InterceptV1Config, int) {
+	var result *InterceptV1Config
+	best := -1
+	for _, intercept := range intercepts {
+		score := intercept.Match(network, hostname, port)
+		if score == -1 {
+			continue
+		}
+		if best == -1 || score < best || (score == best && *intercept.Service.Name < *result.Service.Name) {
+			best = score
+			result = intercept
+		}
+	}
+	return result, best
+}
+
+func newTestIntercept(name string, protocols []string, ports []*PortRange, addresses ...string) *InterceptV1Config {
+	result := &InterceptV1Config{
+		Protocols:  protocols,
+		PortRanges: ports,
+		Service:    &rest_model.ServiceDetail{Name: &name},
+	}
+	for _, address := range addresses {
+		addr, err := NewZitiAddress(address)
+		if err != nil {
+			panic(err)
+		}
+		result.Addresses = append(result.Addresses, *addr)
+	}
+	return result
+}
+
+func randomIntercepts(r *rand.Rand, count int) []*InterceptV1Config {
+	var result []*InterceptV1Config
+	for i := 0; i < count; i++ {
+		var addresses []string
+		for j := 0; j < 1+r.Intn(3); j++ {
+			switch r.Intn(6) {
+			case 0:
+				addresses = append(addresses, fmt.Sprintf("10.%d.%d.%d", r.Intn(4), r.Intn(4), r.Intn(8)))
+			case 1:
+				addresses = append(addresses, fmt.Sprintf("10.%d.%d.0/%d", r.Intn(4), r.Intn(4), 8+r.Intn(25)))
+			case 2:
+				addresses = append(addresses, fmt.Sprintf("fd00::%x/%d", r.Intn(16), 64+r.Intn(65)))
+			case 3:
+				addresses = append(addresses, fmt.Sprintf("svc%d.zone%d.ziti", r.Intn(count), r.Intn(3)))
+			case 4:
+				addresses = append(addresses, fmt.Sprintf("*.zone%d.ziti", r.Intn(3)))
+			default:
+				addresses = append(addresses, "*.ziti")
+			}
+		}
+
+		var ports []*PortRange
+		for j := 0; j < 1+r.Intn(3); j++ {
+			low := uint16(r.Intn(1000))
+			ports = append(ports, &PortRange{Low: low, High: low + uint16(r.Intn(200))})
+		}
+
+		protocols := []string{"tcp"}
+		if r.Intn(2) == 0 {
+			protocols = append(protocols, "udp")
+		}
+
+		result = append(result, newTestIntercept(fmt.Sprintf("svc%04d", i), protocols, ports, addresses...))
+	}
+	return result
+}
+
+func randomTarget(r *rand.Rand, count int) (string, string, uint16) {
+	var hostname string
+	switch r.Intn(5) {
+	case 0:
+		hostname = fmt.Sprintf("10.%d.%d.%d", r.Intn(4), r.Intn(4), r.Intn(8))
+	case 1:
+		hostname = fmt.Sprintf("::ffff:10.%d.%d.%d", r.Intn(4), r.Intn(4), r.Intn(8))
+	case 2:
+		hostname = fmt.Sprintf("fd00::%x", r.Intn(16))
+	case 3:
+		hostname = fmt.Sprintf("SVC%d.zone%d.ziti", r.Intn(count), r.Intn(3))
+	default:
+		hostname = fmt.Sprintf("host%d.zone%d.ziti", r.Intn(10), r.Intn(4))
+	}
+
+	network := "tcp"
+	if r.Intn(3) == 0 {
+		network = "udp"
+	}
+
+	return network, hostname, uint16(r.Intn(1200))
+}
+
+func Test_InterceptIndex(t *testing.T) {
+	t.Run("results are identical to linear matching", func(t *testing.T) {
+		req := require.New(t)
+		r := rand.New(rand.NewSource(1))
+
+		for round := 0; round < 20; round++ {
+			intercepts := randomIntercepts(r, 200)
+			index := NewInterceptIndex(intercepts)
+
+			for i := 0; i < 500; i++ {
+				network, hostname, port := randomTarget(r, 200)
+
+				expected, expectedScore := linearMatch(intercepts, network, hostname, port)
+				actual, actualScore := index.Match(network, hostname, port)
+				req.Equal(expectedScore, actualScore, "%s:%s:%d", network, hostname, port)
+				req.Same(expected, actual, "%s:%s:%d", network, hostname, port)
+			}
+		}
+	})
+
+	t.Run("ties go to the alphabetically first service", func(t *testing.T) {
+		req := require.New(t)
+		tcp := []string{"tcp"}
+		ports := []*PortRange{{Low: 80, High: 80}}
+		index := NewInterceptIndex([]*InterceptV1Config{
+			newTestIntercept("b", tcp, ports, "app.ziti"),
+			newTestIntercept("a", tcp, ports, "app.ziti"),
+			newTestIntercept("c", tcp, ports, "*.ziti"),
+		})
+
+		intercept, score := index.Match("tcp", "App.Ziti", 80)
+		req.Equal("a", *intercept.Service.Name)
+		req.Equal(0, score)
+
+		intercept, score = index.Match("tcp", "other.ziti", 80)
+		req.Equal("c", *intercept.Service.Name)
+		req.Equal(5<<16, score)
+
+		intercept, score = index.Match("udp", "app.ziti", 80)
+		req.Nil(intercept)
+		req.Equal(-1, score)
+
+		intercept, score = index.MatchAddress("app.ziti")
+		req.Equal("a", *intercept.Service.Name)
+		req.Equal(0, score)
+	})
+
+	t.Run("cidrs match like net.IPNet", func(t *testing.T) {
+		req := require.New(t)
+		tcp := []string{"tcp"}
+		ports := []*PortRange{{Low: 0, High: 65535}}
+		intercepts := []*InterceptV1Config{
+			newTestIntercept("mapped", tcp, ports, "::ffff:192.168.0.0/112"),
+			newTestIntercept("v6", tcp, ports, "::/0"),
+			newTestIntercept("v4", tcp, ports, "192.168.1.0/24"),
+		}
+		index := NewInterceptIndex(intercepts)
+
+		for _, hostname := range []string{"192.168.1.1", "192.168.2.1", "::ffff:192.168.2.1", "2001:db8::1"} {
+			expected, expectedScore := linearMatch(intercepts, "tcp", hostname, 443)
+			actual, actualScore := index.Match("tcp", hostname, 443)
+			req.Equal(expectedScore, actualScore, hostname)
+			req.Same(expected, actual, hostname)
+		}
+	})
+}
+
+func benchmarkIntercepts(b *testing.B, count int, match func(intercepts []*InterceptV1Config) func(network, hostname string, port uint16)) {
+	r := rand.New(rand.NewSource(1))
+	intercepts := randomIntercepts(r, count)
+	matcher := match(intercepts)
+
+	type target struct {
+		network, hostname string
+		port              uint16
+	}
+	targets := make([]target, 1024)
+	for i := range targets {
+		targets[i].network, targets[i].hostname, targets[i].port = randomTarget(r, count)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := targets[i%len(targets)]
+		matcher(t.network, t.hostname, t.port)
+	}
+}
+
+func BenchmarkInterceptMatch(b *testing.B) {
+	for _, count := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("linear-%d", count), func(b *testing.B) {
+			benchmarkIntercepts(b, count, func(intercepts []*InterceptV1Config) func(string, string, uint16) {
+				return func(network, hostname string, port uint16) {
+					linearMatch(intercepts, network, hostname, port)
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("index-%d", count), func(b *testing.B) {
+			benchmarkIntercepts(b, count, func(intercepts []*InterceptV1Config) func(string, string, uint16) {
+				index := NewInterceptIndex(intercepts)
+				return func(network, hostname string, port uint16) {
+					index.Match(network, hostname, port)
+				}
+			})
+		})
+	}
+
+	b.Run("build-10000", func(b *testing.B) {
+		intercepts := randomIntercepts(rand.New(rand.NewSource(1)), 10000)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			NewInterceptIndex(intercepts)
+		}
+	})
+}
@@ -39,6 +39,7 @@ type MsgMux interface {
 	RemoveMsgSinkById(sinkId uint32)
 	Close()
 	GetNextId() uint32
+	GetSinks() []MsgSink
 }
 
 func NewCowMapMsgMux() MsgMux {
@@ -161,6 +162,16 @@ func (mux *CowMapMsgMux) Close() {
 	}
 }
 
+// GetSinks returns a snapshot of the currently registered sinks
+func (mux *CowMapMsgMux) GetSinks() []MsgSink {
+	sinks := mux.getSinks()
+	result := make([]MsgSink, 0, len(sinks))
+	for _, sink := range sinks {
+		result = append(result, sink)
+	}
+	return result
+}
+
 func (mux *CowMapMsgMux) getSinks() map[uint32]MsgSink {
 	return mux.sinks.Load().(map[uint32]MsgSink)
 }
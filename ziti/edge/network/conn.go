@@ -17,6 +17,7 @@
 package network
 
 import (
+	"context"
 	"fmt"
 	"github.com/openziti/edge-api/rest_model"
 	"io"
@@ -26,6 +27,7 @@ import (
 	"time"
 
 	"github.com/openziti/foundation/v2/info"
+	"github.com/openziti/metrics"
 	"github.com/sirupsen/logrus"
 
 	"github.com/michaelquigley/pfxlog"
@@ -46,6 +48,7 @@ const (
 )
 
 var _ edge.Conn = &edgeConn{}
+var _ edge.TraceContextProvider = &edgeConn{}
 
 type edgeConn struct {
 	edge.MsgChannel
@@ -60,6 +63,23 @@ type edgeConn struct {
 	sourceIdentity        string
 	acceptCompleteHandler *newConnHandler
 	connType              ConnType
+	accepted              bool
+	routerName            string
+	routerKey             string
+	metrics               metrics.Registry
+	readQDepth            metrics.Histogram
+	routerMetrics         *routerMetrics
+	connMetrics           *connMetrics
+
+	openedAt     time.Time
+	lastActivity atomic.Int64
+	lastRx       atomic.Int64
+	closeReason  atomic.Pointer[error]
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	msgsIn       atomic.Uint64
+	msgsOut      atomic.Uint64
+	encrypted    atomic.Bool
 
 	crypto   bool
 	keyPair  *kx.KeyPair
@@ -67,9 +87,14 @@ type edgeConn struct {
 	receiver secretstream.Decryptor
 	sender   secretstream.Encryptor
 	appData  []byte
+	traceCtx context.Context
 }
 
 func (conn *edgeConn) Write(data []byte) (int, error) {
+	if reason := conn.getCloseReason(); reason != nil {
+		return 0, reason
+	}
+
 	if conn.sentFIN.Load() {
 		return 0, errors.New("calling Write() after CloseWrite()")
 	}
@@ -80,10 +105,159 @@ func (conn *edgeConn) Write(data []byte) (int, error) {
 			return 0, err
 		}
 
-		_, err = conn.MsgChannel.Write(cipherData)
+		n, err := conn.MsgChannel.Write(cipherData)
+		if err == nil {
+			conn.recordWrite(n)
+		}
 		return len(data), err
 	} else {
-		return conn.MsgChannel.Write(data)
+		n, err := conn.MsgChannel.Write(data)
+		if err == nil {
+			conn.recordWrite(n)
+		}
+		return n, err
+	}
+}
+
+const (
+	MetricConnBytesIn      = "edge.conn.%s.%d.bytes.in"
+	MetricConnBytesOut     = "edge.conn.%s.%d.bytes.out"
+	MetricConnMsgsIn       = "edge.conn.%s.%d.msgs.in"
+	MetricConnMsgsOut      = "edge.conn.%s.%d.msgs.out"
+	MetricConnOpened       = "edge.conn.%s.%d.opened"
+	MetricConnLastActivity = "edge.conn.%s.%d.last_activity"
+
+	MetricReadQueueDepth = "edge.conn.read_queue.depth"
+
+	// MetricReadQueueOverflow counts messages dropped because the read queue was full
+	MetricReadQueueOverflow = "edge.conn.read_queue.overflow"
+)
+
+func messageSize(msg *channel.Message) int {
+	return len(msg.Body)
+}
+
+// connMetrics holds the registry series of a single connection, named by router and connection id. Opened and
+// last activity are reported as unix timestamps in seconds.
+type connMetrics struct {
+	bytesIn      metrics.Meter
+	bytesOut     metrics.Meter
+	msgsIn       metrics.Meter
+	msgsOut      metrics.Meter
+	opened       metrics.Gauge
+	lastActivity metrics.Gauge
+}
+
+func newConnMetrics(registry metrics.Registry, conn *edgeConn) *connMetrics {
+	id := conn.Id()
+	return &connMetrics{
+		bytesIn:  registry.Meter(fmt.Sprintf(MetricConnBytesIn, conn.routerName, id)),
+		bytesOut: registry.Meter(fmt.Sprintf(MetricConnBytesOut, conn.routerName, id)),
+		msgsIn:   registry.Meter(fmt.Sprintf(MetricConnMsgsIn, conn.routerName, id)),
+		msgsOut:  registry.Meter(fmt.Sprintf(MetricConnMsgsOut, conn.routerName, id)),
+		opened: registry.FuncGauge(fmt.Sprintf(MetricConnOpened, conn.routerName, id), func() int64 {
+			return conn.openedAt.Unix()
+		}),
+		lastActivity: registry.FuncGauge(fmt.Sprintf(MetricConnLastActivity, conn.routerName, id), func() int64 {
+			return time.Unix(0, conn.lastActivity.Load()).Unix()
+		}),
+	}
+}
+
+func (self *connMetrics) recordRead(n int) {
+	if self != nil {
+		self.msgsIn.Mark(1)
+		self.bytesIn.Mark(int64(n))
+	}
+}
+
+func (self *connMetrics) recordWrite(n int) {
+	if self != nil {
+		self.msgsOut.Mark(1)
+		self.bytesOut.Mark(int64(n))
+	}
+}
+
+func (self *connMetrics) dispose() {
+	if self != nil {
+		self.bytesIn.Dispose()
+		self.bytesOut.Dispose()
+		self.msgsIn.Dispose()
+		self.msgsOut.Dispose()
+		self.opened.Dispose()
+		self.lastActivity.Dispose()
+	}
+}
+
+// initReadQueue creates the read queue for the connection and, if a metrics registry is available, hooks up queue
+// depth and drop reporting.
+func (conn *edgeConn) initReadQueue(options *edge.ReadQueueOptions) {
+	conn.readQ = NewReadQueue[*channel.Message](options, messageSize)
+	if conn.metrics != nil {
+		conn.readQDepth = conn.metrics.Histogram(MetricReadQueueDepth)
+		overflow := conn.metrics.Meter(MetricReadQueueOverflow)
+		conn.readQ.SetDropHandler(func() {
+			overflow.Mark(1)
+		})
+	}
+}
+
+// initConnMetrics starts the connection's counters. If a metrics registry is available, the counters are also
+// registered per connection, and released again when the connection closes. Traffic is also added to the router's
+// totals, which outlive the connection.
+func (conn *edgeConn) initConnMetrics(routerMetrics *routerMetrics) {
+	conn.openedAt = time.Now()
+	conn.lastActivity.Store(conn.openedAt.UnixNano())
+	conn.lastRx.Store(conn.openedAt.UnixNano())
+	conn.routerMetrics = routerMetrics
+	if conn.metrics != nil {
+		conn.connMetrics = newConnMetrics(conn.metrics, conn)
+	}
+}
+
+func (conn *edgeConn) recordRead(msg *channel.Message) {
+	now := time.Now().UnixNano()
+	conn.msgsIn.Add(1)
+	conn.bytesIn.Add(uint64(len(msg.Body)))
+	conn.lastActivity.Store(now)
+	conn.lastRx.Store(now)
+	conn.routerMetrics.recordRead(len(msg.Body))
+	conn.connMetrics.recordRead(len(msg.Body))
+}
+
+func (conn *edgeConn) recordWrite(n int) {
+	conn.msgsOut.Add(1)
+	conn.bytesOut.Add(uint64(n))
+	conn.lastActivity.Store(time.Now().UnixNano())
+	conn.routerMetrics.recordWrite(n)
+	conn.connMetrics.recordWrite(n)
+}
+
+// GetConnInfo returns a snapshot of the connection's state and counters
+func (conn *edgeConn) GetConnInfo() *edge.ConnInfo {
+	connType := edge.ConnInfoTypeDial
+	if conn.connType == ConnTypeBind {
+		connType = edge.ConnInfoTypeBind
+	} else if conn.accepted {
+		connType = edge.ConnInfoTypeAccepted
+	}
+
+	return &edge.ConnInfo{
+		Id:             conn.Id(),
+		Type:           connType,
+		ServiceName:    conn.serviceId,
+		RouterName:     conn.routerName,
+		RouterKey:      conn.routerKey,
+		SourceIdentity: conn.sourceIdentity,
+		Encrypted:      conn.encrypted.Load(),
+		OpenedAt:       conn.openedAt,
+		LastActivity:   time.Unix(0, conn.lastActivity.Load()),
+		BytesIn:        conn.bytesIn.Load(),
+		BytesOut:       conn.bytesOut.Load(),
+		MessagesIn:     conn.msgsIn.Load(),
+		MessagesOut:    conn.msgsOut.Load(),
+		ReadQueueLen:   conn.readQ.Len(),
+		ReadQueueBytes: conn.readQ.Bytes(),
 	}
 }
 
@@ -133,13 +307,26 @@ func (conn *edgeConn) Accept(msg *channel.Message) {
 			return
 		}
 
-		if err := conn.readQ.PutSequenced(msg); err != nil {
+		if msg.ContentType == edge.ContentTypeData {
+			conn.recordRead(msg)
+		}
+
+		if err := conn.readQ.PutSequenced(msg); err == ErrQueueOverflow {
+			logrus.WithFields(edge.GetLoggerFields(msg)).
+				Warnf("read queue overflow (%d messages, %d bytes queued), closing connection", conn.readQ.Len(), conn.readQ.Bytes())
+			// closing sends a message to the router, so it must not hold up the channel receive loop
+			go conn.expire(ErrQueueOverflow, nil)
+		} else if err != nil {
 			logrus.WithFields(edge.GetLoggerFields(msg)).WithError(err).
 				Error("error pushing edge message to sequencer")
 		} else {
 			logrus.WithFields(edge.GetLoggerFields(msg)).Debugf("received %v bytes (msg type: %v)", len(msg.Body), msg.ContentType)
 		}
 
+		if conn.readQDepth != nil {
+			conn.readQDepth.Update(int64(conn.readQ.Len()))
+		}
+
 	case ConnTypeBind:
 		if msg.ContentType == edge.ContentTypeDial {
 			logrus.WithFields(edge.GetLoggerFields(msg)).Debug("received dial request")
@@ -274,6 +461,7 @@ func (conn *edgeConn) establishClientCrypto(keypair *kx.KeyPair, peerKey []byte,
 		return errors.Wrap(err, "failed to write crypto header")
 	}
 
+	conn.encrypted.Store(true)
 	pfxlog.Logger().WithField("connId", conn.Id()).Debug("crypto established")
 	return nil
 }
@@ -295,6 +483,7 @@ func (conn *edgeConn) establishServerCrypto(keypair *kx.KeyPair, peerKey []byte,
 	}
 
 	conn.rxKey = rx
+	conn.encrypted.Store(true)
 
 	return txHeader, nil
 }
@@ -311,9 +500,12 @@ func (conn *edgeConn) Listen(session *rest_model.SessionDetail, service *rest_mo
 			acceptC: make(chan edge.Conn, 10),
 			errorC:  make(chan error, 1),
 		},
-		token:       *session.Token,
-		edgeChan:    conn,
-		manualStart: options.ManualStart,
+		token:          *session.Token,
+		edgeChan:       conn,
+		manualStart:    options.ManualStart,
+		readQueue:      options.ReadQueue,
+		liveness:       options.Liveness,
+		traceExtractor: options.TraceExtractor,
 	}
 	logger.Debug("adding listener for session")
 	conn.hosting.Store(*session.Token, listener)
@@ -359,6 +551,9 @@ func (conn *edgeConn) Listen(session *rest_model.SessionDetail, service *rest_mo
 func (conn *edgeConn) Read(p []byte) (int, error) {
 	log := pfxlog.Logger().WithField("connId", conn.Id())
 	if conn.closed.Load() {
+		if reason := conn.getCloseReason(); reason != nil {
+			return 0, reason
+		}
 		return 0, io.EOF
 	}
 
@@ -379,6 +574,9 @@ func (conn *edgeConn) Read(p []byte) (int, error) {
 		if err == ErrClosed {
 			log.Debug("sequencer closed, closing connection")
 			conn.closed.Store(true)
+			if reason := conn.getCloseReason(); reason != nil {
+				return 0, reason
+			}
 			return 0, io.EOF
 		} else if err != nil {
 			log.Debugf("unexpected sequencer err (%v)", err)
@@ -463,6 +661,7 @@ func (conn *edgeConn) close(closedByRemote bool) {
 	}
 
 	conn.readQ.Close()
+	conn.connMetrics.dispose()
 	conn.msgMux.RemoveMsgSink(conn) // if we switch back to ChMsgMux will need to be done async again, otherwise we may deadlock
 
 	conn.hosting.Range(func(key, value interface{}) bool {
@@ -511,12 +710,25 @@ func (conn *edgeConn) newChildConnection(message *channel.Message) {
 
 	edgeCh := &edgeConn{
 		MsgChannel:     *edge.NewEdgeMsgChannel(conn.Channel, id),
-		readQ:          NewNoopSequencer[*channel.Message](4),
 		msgMux:         conn.msgMux,
+		serviceId:      conn.serviceId,
 		sourceIdentity: sourceIdentity,
 		crypto:         conn.crypto,
 		appData:        message.Headers[edge.AppDataHeader],
 		connType:       ConnTypeDial,
+		accepted:       true,
+		routerName:     conn.routerName,
+		routerKey:      conn.routerKey,
+		metrics:        conn.metrics,
+	}
+	edgeCh.initReadQueue(listener.readQueue)
+	edgeCh.initConnMetrics(conn.routerMetrics)
+	liveness := listener.liveness
+
+	if listener.traceExtractor != nil {
+		if carrier := edge.ExtractTraceContext(edgeCh.appData); carrier != nil {
+			edgeCh.traceCtx = listener.traceExtractor(carrier)
+		}
 	}
 
 	newConnLogger := pfxlog.Logger().
@@ -574,6 +786,7 @@ func (conn *edgeConn) newChildConnection(message *channel.Message) {
 		return
 	}
 
+	edgeCh.startLivenessMonitor(liveness)
 	listener.acceptC <- edgeCh
 }
 
@@ -581,6 +794,13 @@ func (conn *edgeConn) GetAppData() []byte {
 	return conn.appData
 }
 
+func (conn *edgeConn) TraceContext() context.Context {
+	if conn.traceCtx == nil {
+		return context.Background()
+	}
+	return conn.traceCtx
+}
+
 func (conn *edgeConn) CompleteAcceptSuccess() error {
 	if conn.acceptCompleteHandler != nil {
 		result := conn.acceptCompleteHandler.dialSucceeded()
@@ -1,16 +1,166 @@
 package network
 
 import (
+	"context"
 	"crypto/x509"
+	"fmt"
 	"github.com/openziti/channel/v2"
+	"github.com/openziti/edge-api/rest_model"
 	"github.com/openziti/foundation/v2/sequencer"
+	"github.com/openziti/metrics"
 	"github.com/openziti/sdk-golang/ziti/edge"
+	metrics2 "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
 	"sync/atomic"
 	"testing"
 	"time"
 )
 
+func TestConnInfoAndMetrics(t *testing.T) {
+	req := require.New(t)
+
+	registry := metrics.NewRegistry("test", nil)
+	mux := edge.NewCowMapMsgMux()
+	router := &routerConn{
+		routerName: "er1",
+		key:        "tls:er1:3022",
+		ch:         &WireNotifyTestChannel{},
+		msgMux:     mux,
+		owner:      &testRouterConnOwner{registry: registry},
+	}
+
+	svcName := "test-service"
+	encrypt := false
+	conn := router.NewConn(&rest_model.ServiceDetail{Name: &svcName, EncryptionRequired: &encrypt}, ConnTypeDial, nil)
+
+	_, err := conn.Write(make([]byte, 100))
+	req.NoError(err)
+	conn.Accept(edge.NewDataMsg(conn.Id(), 1, make([]byte, 30)))
+
+	infos := router.GetConnections()
+	req.Len(infos, 1)
+	info := infos[0]
+	req.Equal(conn.Id(), info.Id)
+	req.Equal(edge.ConnInfoTypeDial, info.Type)
+	req.Equal(svcName, info.ServiceName)
+	req.Equal("er1", info.RouterName)
+	req.Equal("tls:er1:3022", info.RouterKey)
+	req.False(info.Encrypted)
+	req.Equal(uint64(100), info.BytesOut)
+	req.Equal(uint64(1), info.MessagesOut)
+	req.Equal(uint64(30), info.BytesIn)
+	req.Equal(uint64(1), info.MessagesIn)
+	req.Equal(1, info.ReadQueueLen)
+
+	meterCount := func(name string) int64 {
+		meter := registry.GetMeter(fmt.Sprintf(name, "er1"))
+		req.NotNil(meter)
+		return meter.(metrics2.Meter).Count()
+	}
+
+	// a second connection is counted in the same per router series
+	other := router.NewConn(&rest_model.ServiceDetail{Name: &svcName, EncryptionRequired: &encrypt}, ConnTypeDial, nil)
+	_, err = other.Write(make([]byte, 20))
+	req.NoError(err)
+
+	req.Equal(int64(120), meterCount(MetricRouterBytesOut))
+	req.Equal(int64(2), meterCount(MetricRouterMsgsOut))
+	req.Equal(int64(30), meterCount(MetricRouterBytesIn))
+	req.Equal(int64(1), meterCount(MetricRouterMsgsIn))
+
+	// each connection also has its own series
+	connMeterCount := func(name string, id uint32) int64 {
+		meter := registry.GetMeter(fmt.Sprintf(name, "er1", id))
+		req.NotNil(meter)
+		return meter.(metrics2.Meter).Count()
+	}
+	req.Equal(int64(100), connMeterCount(MetricConnBytesOut, conn.Id()))
+	req.Equal(int64(1), connMeterCount(MetricConnMsgsOut, conn.Id()))
+	req.Equal(int64(30), connMeterCount(MetricConnBytesIn, conn.Id()))
+	req.Equal(int64(1), connMeterCount(MetricConnMsgsIn, conn.Id()))
+	req.Equal(int64(20), connMeterCount(MetricConnBytesOut, other.Id()))
+	req.Equal(int64(0), connMeterCount(MetricConnBytesIn, other.Id()))
+	req.NotNil(registry.GetGauge(fmt.Sprintf(MetricConnOpened, "er1", conn.Id())))
+	req.NotNil(registry.GetGauge(fmt.Sprintf(MetricConnLastActivity, "er1", conn.Id())))
+
+	req.NoError(conn.Close())
+	req.NoError(other.Close())
+	req.Nil(registry.GetMeter(fmt.Sprintf(MetricConnBytesOut, "er1", conn.Id())))
+	req.Nil(registry.GetGauge(fmt.Sprintf(MetricConnOpened, "er1", conn.Id())))
+	req.Nil(registry.GetMeter(fmt.Sprintf(MetricConnBytesOut, "er1", other.Id())))
+	req.Empty(router.GetConnections())
+	req.Equal(int64(120), meterCount(MetricRouterBytesOut))
+
+	// the router's series are released with the router connection
+	router.HandleClose(nil)
+	req.Nil(registry.GetMeter(fmt.Sprintf(MetricRouterBytesOut, "er1")))
+	req.Nil(router.getRouterMetrics())
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	req := require.New(t)
+
+	router := &routerConn{
+		routerName: "er1",
+		key:        "tls:er1:3022",
+		ch:         &WireNotifyTestChannel{},
+		msgMux:     edge.NewCowMapMsgMux(),
+		owner:      &testRouterConnOwner{registry: metrics.NewRegistry("test", nil)},
+	}
+
+	svcName := "test-service"
+	encrypt := false
+	conn := router.NewConn(&rest_model.ServiceDetail{Name: &svcName, EncryptionRequired: &encrypt}, ConnTypeDial, nil)
+
+	expired := make(chan error, 1)
+	conn.startLivenessMonitor(&edge.LivenessOptions{
+		IdleTimeout: 50 * time.Millisecond,
+		OnExpired: func(c edge.Conn, reason error) {
+			expired <- reason
+		},
+	})
+
+	select {
+	case reason := <-expired:
+		req.ErrorIs(reason, edge.ErrConnIdleTimeout)
+	case <-time.After(time.Second):
+		req.Fail("connection was not expired")
+	}
+
+	req.True(conn.IsClosed())
+	_, err := conn.Read(make([]byte, 10))
+	req.ErrorIs(err, edge.ErrConnIdleTimeout)
+	_, err = conn.Write([]byte("hello"))
+	req.ErrorIs(err, edge.ErrConnIdleTimeout)
+}
+
+func TestConnReadQueueOverflow(t *testing.T) {
+	req := require.New(t)
+
+	router := &routerConn{
+		routerName: "er1",
+		key:        "tls:er1:3022",
+		ch:         &WireNotifyTestChannel{},
+		msgMux:     edge.NewCowMapMsgMux(),
+		owner:      &testRouterConnOwner{registry: metrics.NewRegistry("test", nil)},
+	}
+
+	svcName := "test-service"
+	encrypt := false
+	conn := router.NewConn(&rest_model.ServiceDetail{Name: &svcName, EncryptionRequired: &encrypt}, ConnTypeDial,
+		&edge.ReadQueueOptions{MaxMessages: 1, OverflowPolicy: edge.ReadQueueOverflowDropAndClose})
+
+	conn.Accept(edge.NewDataMsg(conn.Id(), 1, make([]byte, 10)))
+	conn.Accept(edge.NewDataMsg(conn.Id(), 2, make([]byte, 10)))
+
+	req.Eventually(conn.IsClosed, time.Second, time.Millisecond)
+
+	_, err := conn.Read(make([]byte, 10))
+	req.ErrorIs(err, ErrQueueOverflow)
+	_, err = conn.Write([]byte("hello"))
+	req.ErrorIs(err, ErrQueueOverflow)
+}
+
 func BenchmarkConnWriteBaseLine(b *testing.B) {
 	testChannel := &NoopTestChannel{}
 
@@ -176,3 +326,43 @@ func (ch *NoopTestChannel) IsClosed() bool {
 func (ch *NoopTestChannel) GetTimeSinceLastRead() time.Duration {
 	return 0
 }
+
+// WireNotifyTestChannel discards messages, but reports them as written so that senders waiting for the wire proceed
+type WireNotifyTestChannel struct {
+	NoopTestChannel
+}
+
+func (ch *WireNotifyTestChannel) Send(s channel.Sendable) error {
+	if listener := s.SendListener(); listener != nil {
+		listener.NotifyAfterWrite()
+	}
+	return nil
+}
+
+func (ch *WireNotifyTestChannel) IsClosed() bool {
+	return false
+}
+
+type testRouterConnOwner struct {
+	registry metrics.Registry
+}
+
+func (self *testRouterConnOwner) OnClose(edge.RouterConn) {}
+
+func (self *testRouterConnOwner) Metrics() metrics.Registry {
+	return self.registry
+}
+
+func TestConnTraceContext(t *testing.T) {
+	req := require.New(t)
+
+	var conn edge.Conn = &edgeConn{}
+	provider, ok := conn.(edge.TraceContextProvider)
+	req.True(ok)
+	req.Equal(context.Background(), provider.TraceContext())
+
+	type traceKey struct{}
+	traceCtx := context.WithValue(context.Background(), traceKey{}, "parent")
+	conn.(*edgeConn).traceCtx = traceCtx
+	req.Equal(traceCtx, provider.TraceContext())
+}
@@ -17,15 +17,67 @@
 package network
 
 import (
+	"fmt"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/channel/v2"
 	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/metrics"
 	"github.com/openziti/sdk-golang/ziti/edge"
 	"github.com/openziti/secretstream/kx"
+	"sync"
 )
 
+const (
+	MetricRouterBytesIn  = "edge.router.%s.bytes.in"
+	MetricRouterBytesOut = "edge.router.%s.bytes.out"
+	MetricRouterMsgsIn   = "edge.router.%s.msgs.in"
+	MetricRouterMsgsOut  = "edge.router.%s.msgs.out"
+)
+
+// routerMetrics counts the traffic of all connections over a router connection. The meters are shared with other
+// connections to the same router, and released when the router connection closes.
+type routerMetrics struct {
+	bytesIn  metrics.Meter
+	bytesOut metrics.Meter
+	msgsIn   metrics.Meter
+	msgsOut  metrics.Meter
+}
+
+func newRouterMetrics(registry metrics.Registry, routerName string) *routerMetrics {
+	return &routerMetrics{
+		bytesIn:  registry.Meter(fmt.Sprintf(MetricRouterBytesIn, routerName)),
+		bytesOut: registry.Meter(fmt.Sprintf(MetricRouterBytesOut, routerName)),
+		msgsIn:   registry.Meter(fmt.Sprintf(MetricRouterMsgsIn, routerName)),
+		msgsOut:  registry.Meter(fmt.Sprintf(MetricRouterMsgsOut, routerName)),
+	}
+}
+
+func (self *routerMetrics) recordRead(n int) {
+	if self != nil {
+		self.msgsIn.Mark(1)
+		self.bytesIn.Mark(int64(n))
+	}
+}
+
+func (self *routerMetrics) recordWrite(n int) {
+	if self != nil {
+		self.msgsOut.Mark(1)
+		self.bytesOut.Mark(int64(n))
+	}
+}
+
+func (self *routerMetrics) dispose() {
+	if self != nil {
+		self.bytesIn.Dispose()
+		self.bytesOut.Dispose()
+		self.msgsIn.Dispose()
+		self.msgsOut.Dispose()
+	}
+}
+
 type RouterConnOwner interface {
 	OnClose(factory edge.RouterConn)
+	Metrics() metrics.Registry
 }
 
 type routerConn struct {
@@ -34,6 +86,10 @@ type routerConn struct {
 	ch         channel.Channel
 	msgMux     edge.MsgMux
 	owner      RouterConnOwner
+
+	metricsLock   sync.Mutex
+	routerMetrics *routerMetrics
+	closed        bool
 }
 
 func (conn *routerConn) Key() string {
@@ -45,6 +101,12 @@ func (conn *routerConn) GetRouterName() string {
 }
 
 func (conn *routerConn) HandleClose(channel.Channel) {
+	conn.metricsLock.Lock()
+	conn.closed = true
+	conn.routerMetrics.dispose()
+	conn.routerMetrics = nil
+	conn.metricsLock.Unlock()
+
 	if conn.owner != nil {
 		conn.owner.OnClose(conn)
 	}
@@ -76,16 +138,41 @@ func (conn *routerConn) BindChannel(binding channel.Binding) error {
 	return nil
 }
 
-func (conn *routerConn) NewConn(service *rest_model.ServiceDetail, connType ConnType) *edgeConn {
+func (conn *routerConn) metrics() metrics.Registry {
+	if conn.owner == nil {
+		return nil
+	}
+	return conn.owner.Metrics()
+}
+
+// getRouterMetrics returns the router's meters, creating them on first use, as the owner's registry may not exist
+// when the router connection is created. Returns nil without a registry or once the router connection has closed.
+func (conn *routerConn) getRouterMetrics() *routerMetrics {
+	conn.metricsLock.Lock()
+	defer conn.metricsLock.Unlock()
+
+	if conn.routerMetrics == nil && !conn.closed {
+		if registry := conn.metrics(); registry != nil {
+			conn.routerMetrics = newRouterMetrics(registry, conn.routerName)
+		}
+	}
+	return conn.routerMetrics
+}
+
+func (conn *routerConn) NewConn(service *rest_model.ServiceDetail, connType ConnType, readQueue *edge.ReadQueueOptions) *edgeConn {
 	id := conn.msgMux.GetNextId()
 
 	edgeCh := &edgeConn{
 		MsgChannel: *edge.NewEdgeMsgChannel(conn.ch, id),
-		readQ:      NewNoopSequencer[*channel.Message](4),
 		msgMux:     conn.msgMux,
 		serviceId:  *service.Name,
 		connType:   connType,
+		routerName: conn.routerName,
+		routerKey:  conn.key,
+		metrics:    conn.metrics(),
 	}
+	edgeCh.initReadQueue(readQueue)
+	edgeCh.initConnMetrics(conn.getRouterMetrics())
 
 	var err error
 	if *service.EncryptionRequired {
@@ -104,18 +191,20 @@ func (conn *routerConn) NewConn(service *rest_model.ServiceDetail, connType Conn
 }
 
 func (conn *routerConn) Connect(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
-	ec := conn.NewConn(service, ConnTypeDial)
+	ec := conn.NewConn(service, ConnTypeDial, options.ReadQueue)
 	dialConn, err := ec.Connect(session, options)
 	if err != nil {
 		if err2 := ec.Close(); err2 != nil {
 			pfxlog.Logger().Errorf("failed to cleanup connection for service '%v' (%v)", service.Name, err2)
 		}
+		return dialConn, err
 	}
+	ec.startLivenessMonitor(options.Liveness)
 	return dialConn, err
 }
 
 func (conn *routerConn) Listen(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.ListenOptions) (edge.Listener, error) {
-	ec := conn.NewConn(service, ConnTypeBind)
+	ec := conn.NewConn(service, ConnTypeBind, options.ReadQueue)
 	listener, err := ec.Listen(session, service, options)
 	if err != nil {
 		if err2 := ec.Close(); err2 != nil {
@@ -125,6 +214,17 @@ func (conn *routerConn) Listen(service *rest_model.ServiceDetail, session *rest_
 	return listener, err
 }
 
+// GetConnections returns a snapshot of the dial, bind and accepted connections multiplexed over this router connection
+func (conn *routerConn) GetConnections() []*edge.ConnInfo {
+	var result []*edge.ConnInfo
+	for _, sink := range conn.msgMux.GetSinks() {
+		if ec, ok := sink.(*edgeConn); ok && !ec.IsClosed() {
+			result = append(result, ec.GetConnInfo())
+		}
+	}
+	return result
+}
+
 func (conn *routerConn) Close() error {
 	if !conn.ch.IsClosed() {
 		return conn.ch.Close()
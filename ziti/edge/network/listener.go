@@ -17,6 +17,7 @@
 package network
 
 import (
+	"context"
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/edge-api/rest_model"
@@ -88,9 +89,12 @@ func (listener *baseListener) AcceptEdge() (edge.Conn, error) {
 
 type edgeListener struct {
 	baseListener
-	token       string
-	edgeChan    *edgeConn
-	manualStart bool
+	token          string
+	edgeChan       *edgeConn
+	manualStart    bool
+	readQueue      *edge.ReadQueueOptions
+	liveness       *edge.LivenessOptions
+	traceExtractor func(carrier map[string]string) context.Context
 }
 
 func (listener *edgeListener) UpdateCost(cost uint16) error {
@@ -0,0 +1,104 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package network
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"time"
+)
+
+// keepAliveHops is large enough for a trace route probe to reach the far side of the circuit, which answers it
+const keepAliveHops = 255
+
+// startLivenessMonitor closes the connection if it stays idle for too long or stops answering keepalive probes
+func (conn *edgeConn) startLivenessMonitor(options *edge.LivenessOptions) {
+	if !options.IsEnabled() {
+		return
+	}
+	go conn.monitorLiveness(*options)
+}
+
+func (conn *edgeConn) monitorLiveness(options edge.LivenessOptions) {
+	checkInterval := options.KeepAliveInterval
+	if options.IdleTimeout > 0 && (checkInterval == 0 || options.IdleTimeout/2 < checkInterval) {
+		checkInterval = options.IdleTimeout / 2
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	lastProbe := time.Now()
+
+	for {
+		select {
+		case <-conn.readQ.CloseNotify():
+			return
+		case <-ticker.C:
+		}
+
+		if conn.IsClosed() {
+			return
+		}
+
+		now := time.Now()
+
+		if options.IdleTimeout > 0 && now.Sub(time.Unix(0, conn.lastActivity.Load())) >= options.IdleTimeout {
+			conn.expire(edge.ErrConnIdleTimeout, options.OnExpired)
+			return
+		}
+
+		if options.KeepAliveInterval > 0 &&
+			now.Sub(time.Unix(0, conn.lastRx.Load())) >= options.KeepAliveInterval &&
+			now.Sub(lastProbe) >= options.KeepAliveInterval {
+
+			lastProbe = now
+			if _, err := conn.TraceRoute(keepAliveHops, options.GetKeepAliveTimeout()); err != nil {
+				if conn.IsClosed() {
+					return
+				}
+				pfxlog.Logger().WithField("connId", conn.Id()).WithError(err).Debug("keepalive probe failed")
+				conn.expire(edge.ErrConnKeepAliveFailed, options.OnExpired)
+				return
+			}
+		}
+	}
+}
+
+// expire closes the connection, making reason the error returned by subsequent reads and writes
+func (conn *edgeConn) expire(reason error, onExpired func(edge.Conn, error)) {
+	if !conn.closeReason.CompareAndSwap(nil, &reason) {
+		return
+	}
+
+	pfxlog.Logger().WithField("connId", conn.Id()).WithField("serviceName", conn.serviceId).
+		WithError(reason).Info("closing connection")
+
+	conn.close(false)
+
+	if onExpired != nil {
+		onExpired(conn, reason)
+	}
+}
+
+// getCloseReason returns the error set when the connection was expired, or nil if it wasn't
+func (conn *edgeConn) getCloseReason() error {
+	if reason := conn.closeReason.Load(); reason != nil {
+		return *reason
+	}
+	return nil
+}
@@ -2,13 +2,19 @@ package network
 
 import (
 	"github.com/openziti/foundation/v2/concurrenz"
+	"github.com/openziti/sdk-golang/ziti/edge"
 	"github.com/pkg/errors"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var ErrClosed = errors.New("sequencer closed")
 
+// ErrQueueOverflow is returned by PutSequenced when the queue is full and the overflow policy is
+// edge.ReadQueueOverflowDropAndClose.
+var ErrQueueOverflow = errors.New("read queue overflow")
+
 type ReadTimout struct{}
 
 func (r ReadTimout) Error() string {
@@ -24,31 +30,247 @@ func (r ReadTimout) Temporary() bool {
 }
 
 func NewNoopSequencer[T any](channelDepth int) *noopSeq[T] {
-	return &noopSeq[T]{
+	seq := &noopSeq[T]{
 		ch:             make(chan T, channelDepth),
 		closeNotify:    make(chan struct{}),
 		deadlineNotify: make(chan struct{}),
+		spaceNotify:    make(chan struct{}, 1),
+		sizer:          func(T) int { return 0 },
 	}
+	seq.pumpIdle = sync.NewCond(&seq.spillLock)
+	return seq
+}
+
+// NewReadQueue returns a sequencer bounded by the given options. The sizer reports the payload size of an event and is
+// used to enforce the byte limit.
+func NewReadQueue[T any](options *edge.ReadQueueOptions, sizer func(T) int) *noopSeq[T] {
+	seq := NewNoopSequencer[T](options.GetMaxMessages())
+	seq.maxBytes = int64(options.GetMaxBytes())
+	seq.policy = options.GetOverflowPolicy()
+	if sizer != nil {
+		seq.sizer = sizer
+	}
+	return seq
 }
 
 type noopSeq[T any] struct {
 	ch             chan T
 	closeNotify    chan struct{}
 	deadlineNotify chan struct{}
+	spaceNotify    chan struct{}
 	deadline       concurrenz.AtomicValue[time.Time]
 	closed         atomic.Bool
 	readInProgress atomic.Bool
+
+	sizer    func(T) int
+	maxBytes int64
+	policy   edge.ReadQueueOverflowPolicy
+	bytes    atomic.Int64
+	onDrop   func()
+
+	spillLock  sync.Mutex
+	pumpIdle   *sync.Cond // signalled when the spill pump no longer holds an event
+	spill      []T
+	spillBytes atomic.Int64
+	inFlight   int
+	pumping    bool
+}
+
+// SetDropHandler registers a callback which is invoked each time an event is dropped because it does not fit in the
+// queue, which only happens with edge.ReadQueueOverflowDropAndClose. Events which wait for space or are spilled aren't
+// dropped. It must be set before the queue is in use.
+func (seq *noopSeq[T]) SetDropHandler(f func()) {
+	seq.onDrop = f
+}
+
+// Len returns the number of queued events, including any that have been spilled.
+func (seq *noopSeq[T]) Len() int {
+	seq.spillLock.Lock()
+	spilled := len(seq.spill) + seq.inFlight
+	seq.spillLock.Unlock()
+	return len(seq.ch) + spilled
+}
+
+// Bytes returns the payload size of queued events, including any that have been spilled.
+func (seq *noopSeq[T]) Bytes() int64 {
+	return seq.bytes.Load() + seq.spillBytes.Load()
 }
 
 func (seq *noopSeq[T]) PutSequenced(event T) error {
+	if seq.closed.Load() {
+		return ErrClosed
+	}
+
+	size := int64(seq.sizer(event))
+
+	if seq.policy == edge.ReadQueueOverflowSpill {
+		return seq.putOrSpill(event, size)
+	}
+
+	if seq.tryPut(event, size) {
+		return nil
+	}
+
+	if seq.policy == edge.ReadQueueOverflowDropAndClose {
+		seq.notifyDrop()
+		return ErrQueueOverflow
+	}
+
+	return seq.putBlocking(event, size, false)
+}
+
+func (seq *noopSeq[T]) hasSpace(size int64) bool {
+	if seq.maxBytes <= 0 {
+		return true
+	}
+	current := seq.bytes.Load()
+	return current == 0 || current+size <= seq.maxBytes
+}
+
+func (seq *noopSeq[T]) tryPut(event T, size int64) bool {
+	if !seq.hasSpace(size) {
+		return false
+	}
+
+	seq.bytes.Add(size)
 	select {
 	case seq.ch <- event:
+		return true
+	default:
+		seq.bytes.Add(-size)
+		return false
+	}
+}
+
+// putBlocking waits for space in the queue. If the event comes from the spill buffer, its size is moved from the
+// spilled byte count to the queued byte count, so the total reported by Bytes never undercounts. If the queue is
+// closed first, the size of a spilled event is left in the spilled byte count.
+func (seq *noopSeq[T]) putBlocking(event T, size int64, fromSpill bool) error {
+	for {
+		if seq.hasSpace(size) {
+			seq.bytes.Add(size)
+			if fromSpill {
+				seq.spillBytes.Add(-size)
+			}
+			select {
+			case seq.ch <- event:
+				return nil
+			case <-seq.closeNotify:
+				seq.bytes.Add(-size)
+				if fromSpill {
+					seq.spillBytes.Add(size)
+				}
+				return ErrClosed
+			}
+		}
+
+		select {
+		case <-seq.spaceNotify:
+		case <-seq.closeNotify:
+			return ErrClosed
+		}
+	}
+}
+
+func (seq *noopSeq[T]) putOrSpill(event T, size int64) error {
+	seq.spillLock.Lock()
+	defer seq.spillLock.Unlock()
+
+	// while the pump is running, events must go through the spill buffer to preserve ordering
+	if !seq.pumping && seq.tryPut(event, size) {
 		return nil
-	case <-seq.closeNotify:
-		return ErrClosed
+	}
+
+	seq.spill = append(seq.spill, event)
+	seq.spillBytes.Add(size)
+
+	if !seq.pumping {
+		seq.pumping = true
+		go seq.pumpSpill()
+	}
+	return nil
+}
+
+func (seq *noopSeq[T]) pumpSpill() {
+	for {
+		seq.spillLock.Lock()
+		if len(seq.spill) == 0 {
+			seq.pumping = false
+			seq.spillLock.Unlock()
+			return
+		}
+		// the head is counted as in flight until it reaches the queue, so it's never missing from Len or Bytes
+		head := seq.removeSpillHead()
+		seq.inFlight = 1
+		seq.spillLock.Unlock()
+
+		err := seq.putBlocking(head, int64(seq.sizer(head)), true)
+
+		seq.spillLock.Lock()
+		seq.inFlight = 0
+		if err != nil {
+			// the queue was closed, so put the head back for GetNext to drain
+			seq.spill = append([]T{head}, seq.spill...)
+			seq.pumping = false
+		}
+		seq.pumpIdle.Broadcast()
+		seq.spillLock.Unlock()
+
+		if err != nil {
+			return
+		}
 	}
 }
 
+// waitForPump waits until the spill pump doesn't hold an event, so that everything it has handed off is in the queue
+// and everything else is in the spill buffer.
+func (seq *noopSeq[T]) waitForPump() {
+	seq.spillLock.Lock()
+	defer seq.spillLock.Unlock()
+	for seq.inFlight > 0 {
+		seq.pumpIdle.Wait()
+	}
+}
+
+// popSpill removes and returns the oldest spilled event, if any.
+func (seq *noopSeq[T]) popSpill() (T, bool) {
+	seq.spillLock.Lock()
+	defer seq.spillLock.Unlock()
+
+	if len(seq.spill) == 0 {
+		var val T
+		return val, false
+	}
+
+	val := seq.removeSpillHead()
+	seq.spillBytes.Add(-int64(seq.sizer(val)))
+	return val, true
+}
+
+// removeSpillHead must be called with spillLock held and a non-empty spill buffer
+func (seq *noopSeq[T]) removeSpillHead() T {
+	val := seq.spill[0]
+	var zero T
+	seq.spill[0] = zero
+	seq.spill = seq.spill[1:]
+	return val
+}
+
+func (seq *noopSeq[T]) notifyDrop() {
+	if seq.onDrop != nil {
+		seq.onDrop()
+	}
+}
+
+func (seq *noopSeq[T]) taken(val T) T {
+	seq.bytes.Add(-int64(seq.sizer(val)))
+	select {
+	case seq.spaceNotify <- struct{}{}:
+	default:
+	}
+	return val
+}
+
 func (seq *noopSeq[T]) SetReadDeadline(deadline time.Time) {
 	seq.deadline.Store(deadline)
 	if seq.readInProgress.Load() {
@@ -81,13 +303,18 @@ func (seq *noopSeq[T]) GetNext() (T, error) {
 
 		select {
 		case val = <-seq.ch:
-			return val, nil
+			return seq.taken(val), nil
 		case <-seq.closeNotify:
-			// If we're closed, return any buffered values, otherwise return nil
+			// If we're closed, return any buffered values, otherwise return nil. Spilled values follow the queued ones,
+			// once the pump has either queued the value it holds or put it back.
+			seq.waitForPump()
 			select {
 			case val = <-seq.ch:
-				return val, nil
+				return seq.taken(val), nil
 			default:
+				if spilled, ok := seq.popSpill(); ok {
+					return spilled, nil
+				}
 				return val, ErrClosed
 			}
 		case <-seq.deadlineNotify:
@@ -96,7 +323,7 @@ func (seq *noopSeq[T]) GetNext() (T, error) {
 			// If we're timing out, return any buffered values, otherwise return nil
 			select {
 			case val = <-seq.ch:
-				return val, nil
+				return seq.taken(val), nil
 			default:
 				return val, &ReadTimout{}
 			}
@@ -104,6 +331,11 @@ func (seq *noopSeq[T]) GetNext() (T, error) {
 	}
 }
 
+// CloseNotify returns a channel which is closed when the sequencer is closed
+func (seq *noopSeq[T]) CloseNotify() <-chan struct{} {
+	return seq.closeNotify
+}
+
 func (seq *noopSeq[T]) Close() {
 	if seq.closed.CompareAndSwap(false, true) {
 		close(seq.closeNotify)
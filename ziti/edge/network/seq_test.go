@@ -77,3 +77,145 @@ func Test_SeqReadWithInterrupt(t *testing.T) {
 	req.ErrorIs(err, &ReadTimout{})
 	req.True(time.Since(first) < time.Millisecond)
 }
+
+func Test_SeqDropAndCloseOverflow(t *testing.T) {
+	readQ := NewReadQueue[*channel.Message](&edge.ReadQueueOptions{
+		MaxMessages:    2,
+		OverflowPolicy: edge.ReadQueueOverflowDropAndClose,
+	}, messageSize)
+
+	drops := 0
+	readQ.SetDropHandler(func() {
+		drops++
+	})
+
+	req := require.New(t)
+	req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, 1, nil)))
+	req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, 2, nil)))
+	req.ErrorIs(readQ.PutSequenced(edge.NewDataMsg(1, 3, nil)), ErrQueueOverflow)
+	req.Equal(1, drops)
+	req.Equal(2, readQ.Len())
+}
+
+func Test_SeqSpillPreservesOrder(t *testing.T) {
+	readQ := NewReadQueue[*channel.Message](&edge.ReadQueueOptions{
+		MaxMessages:    2,
+		OverflowPolicy: edge.ReadQueueOverflowSpill,
+	}, messageSize)
+
+	req := require.New(t)
+
+	// none of these may block, even though nothing is reading yet
+	for i := uint32(0); i < 100; i++ {
+		req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, i, make([]byte, 10))))
+	}
+	req.Equal(100, readQ.Len())
+	req.Equal(int64(1000), readQ.Bytes())
+
+	for i := uint32(0); i < 100; i++ {
+		msg, err := readQ.GetNext()
+		req.NoError(err)
+		seq, _ := msg.GetUint32Header(edge.SeqHeader)
+		req.Equal(i, seq)
+	}
+
+	// the spill pump may still be finishing its last hand-off
+	req.Eventually(func() bool {
+		return readQ.Len() == 0 && readQ.Bytes() == 0
+	}, time.Second, time.Millisecond)
+}
+
+func Test_SeqBlockOnByteLimit(t *testing.T) {
+	readQ := NewReadQueue[*channel.Message](&edge.ReadQueueOptions{
+		MaxMessages: 10,
+		MaxBytes:    100,
+	}, messageSize)
+
+	req := require.New(t)
+	req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, 1, make([]byte, 80))))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- readQ.PutSequenced(edge.NewDataMsg(1, 2, make([]byte, 80)))
+	}()
+
+	select {
+	case <-done:
+		req.Fail("put should block while the byte limit is exceeded")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, err := readQ.GetNext()
+	req.NoError(err)
+
+	select {
+	case err = <-done:
+		req.NoError(err)
+	case <-time.After(time.Second):
+		req.Fail("put should unblock once space is available")
+	}
+	req.Equal(int64(80), readQ.Bytes())
+}
+
+func Test_SeqSpillDrainsAfterClose(t *testing.T) {
+	readQ := NewReadQueue[*channel.Message](&edge.ReadQueueOptions{
+		MaxMessages:    2,
+		OverflowPolicy: edge.ReadQueueOverflowSpill,
+	}, messageSize)
+
+	drops := 0
+	readQ.SetDropHandler(func() {
+		drops++
+	})
+
+	req := require.New(t)
+	for i := uint32(0); i < 10; i++ {
+		req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, i, make([]byte, 10))))
+	}
+
+	// the pump is holding the head of the spill buffer, waiting for space
+	req.Eventually(func() bool {
+		readQ.spillLock.Lock()
+		defer readQ.spillLock.Unlock()
+		return readQ.inFlight == 1
+	}, time.Second, time.Millisecond)
+
+	readQ.Close()
+
+	for i := uint32(0); i < 10; i++ {
+		msg, err := readQ.GetNext()
+		req.NoError(err)
+		seq, _ := msg.GetUint32Header(edge.SeqHeader)
+		req.Equal(i, seq)
+	}
+
+	_, err := readQ.GetNext()
+	req.ErrorIs(err, ErrClosed)
+	req.Equal(0, readQ.Len())
+	req.Equal(int64(0), readQ.Bytes())
+	req.Equal(0, drops)
+}
+
+func Test_SeqBlockDoesNotReportDrops(t *testing.T) {
+	readQ := NewReadQueue[*channel.Message](&edge.ReadQueueOptions{
+		MaxMessages: 1,
+	}, messageSize)
+
+	drops := 0
+	readQ.SetDropHandler(func() {
+		drops++
+	})
+
+	req := require.New(t)
+	req.NoError(readQ.PutSequenced(edge.NewDataMsg(1, 1, nil)))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- readQ.PutSequenced(edge.NewDataMsg(1, 2, nil))
+	}()
+
+	_, err := readQ.GetNext()
+	req.NoError(err)
+	req.NoError(<-done)
+	req.Equal(0, drops)
+}
@@ -30,11 +30,12 @@ import (
 )
 
 type CacheData struct {
-	Processes    cmap.ConcurrentMap[string, ProcessInfo] // map[processPath]ProcessInfo
-	MacAddresses []string
-	Os           OsInfo
-	Domain       string
-	Evaluated    atomic.Bool //marks whether posture responses for this data have been sent out
+	Processes     cmap.ConcurrentMap[string, ProcessInfo] // map[processPath]ProcessInfo
+	MacAddresses  []string
+	Os            OsInfo
+	Domain        string
+	EndpointState EndpointState
+	Evaluated     atomic.Bool //marks whether posture responses for this data have been sent out
 }
 
 func NewCacheData() *CacheData {
@@ -65,11 +66,61 @@ type Cache struct {
 	doSingleSubmissions bool
 	closeNotify         <-chan struct{}
 
-	DomainFunc func() string
-	lock       sync.Mutex
+	DomainFunc   func() string
+	providers    *Providers
+	pollInterval time.Duration
+	simulate     bool
+	onSubmitted  func(responses []rest_model.PostureResponseCreate)
+	submitted    cmap.ConcurrentMap[string, *SubmittedResponse] // map[queryId|processPath]
+	watcher      changeWatcher
+	changed      chan struct{}
+	lock         sync.Mutex
+}
+
+const (
+	// DefaultPollInterval is how often posture data is refreshed when no change has been noticed in between
+	DefaultPollInterval = 10 * time.Second
+
+	// changeSettleDelay gives related changes, such as an interface and its addresses coming up, time to complete so
+	// they are reported together
+	changeSettleDelay = 250 * time.Millisecond
+)
+
+// CacheOptions configure how a cache collects posture data
+type CacheOptions struct {
+	// Providers supply posture data. Nil providers, or nil fields of providers, use the built-in collectors.
+	Providers *Providers
+
+	// PollInterval is how often posture data is refreshed. Where the platform supports it, changes to network
+	// interfaces, watched processes and their executables are reported as they happen, and polling is only a
+	// fallback. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// Simulate evaluates posture without submitting responses to the controller. The responses which would have been
+	// sent are recorded as submitted, so they can be inspected with QueryStatus, which also reports whether process
+	// queries pass locally. Whether other queries pass is only known to the controller.
+	Simulate bool
+
+	// OnSubmitted, if set, is called with the responses accepted by the controller
+	OnSubmitted func(responses []rest_model.PostureResponseCreate)
 }
 
 func NewCache(submitter Submitter, closeNotify <-chan struct{}) *Cache {
+	return NewCacheWithProviders(submitter, closeNotify, nil)
+}
+
+// NewCacheWithProviders creates a cache which collects posture data from the given providers. Nil providers, or nil
+// fields of providers, use the built-in collectors.
+func NewCacheWithProviders(submitter Submitter, closeNotify <-chan struct{}, providers *Providers) *Cache {
+	return NewCacheWithOptions(submitter, closeNotify, &CacheOptions{Providers: providers})
+}
+
+// NewCacheWithOptions creates a cache configured by the given options, which may be nil
+func NewCacheWithOptions(submitter Submitter, closeNotify <-chan struct{}, options *CacheOptions) *Cache {
+	if options == nil {
+		options = &CacheOptions{}
+	}
+
 	cache := &Cache{
 		currentData:      NewCacheData(),
 		previousData:     NewCacheData(),
@@ -80,7 +131,17 @@ func NewCache(submitter Submitter, closeNotify <-chan struct{}) *Cache {
 		startOnce:        sync.Once{},
 		closeNotify:      closeNotify,
 		DomainFunc:       Domain,
+		pollInterval:     options.PollInterval,
+		simulate:         options.Simulate,
+		onSubmitted:      options.OnSubmitted,
+		submitted:        cmap.New[*SubmittedResponse](),
+		changed:          make(chan struct{}, 1),
 	}
+	if cache.pollInterval <= 0 {
+		cache.pollInterval = DefaultPollInterval
+	}
+	cache.providers = options.Providers.withDefaults(cache)
+	cache.watcher = newChangeWatcher(cache.notifyChanged)
 	cache.serviceQueryMap.Store(map[string]map[string]rest_model.PostureQuery{})
 	cache.start()
 
@@ -106,6 +167,18 @@ func (cache *Cache) setWatchedProcesses(processPaths []string) {
 	for _, processPath := range processesToRemove {
 		cache.watchedProcesses.Remove(processPath)
 	}
+
+	cache.watcher.setPaths(processPaths)
+}
+
+// notifyChanged requests an evaluation ahead of the next poll. Notifications arriving while one is pending are
+// coalesced.
+func (cache *Cache) notifyChanged(reason string) {
+	pfxlog.Logger().WithField("reason", reason).Debug("posture data changed")
+	select {
+	case cache.changed <- struct{}{}:
+	default:
+	}
 }
 
 // Evaluate refreshes all posture data and determines if new posture responses should be sent out
@@ -140,46 +213,37 @@ func (cache *Cache) GetChangedResponses() []rest_model.PostureResponseCreate {
 		}
 	})
 
-	if len(activeQueryTypes) == 0 {
-		return nil
+	var responses []rest_model.PostureResponseCreate
+
+	// endpoint state isn't requested by queries, it is reported whenever the endpoint wakes or is unlocked
+	if state := cache.currentData.EndpointState; state != cache.previousData.EndpointState && (state.Woken || state.Unlocked) {
+		endpointStateResponse := &rest_model.PostureResponseEndpointStateCreate{
+			Woken:    state.Woken,
+			Unlocked: state.Unlocked,
+		}
+		queryId := EndpointStateQueryId
+		endpointStateResponse.SetID(&queryId)
+		responses = append(responses, endpointStateResponse)
 	}
 
-	var responses []rest_model.PostureResponseCreate
+	if len(activeQueryTypes) == 0 {
+		return responses
+	}
 	if cache.currentData.Domain != cache.previousData.Domain {
 		if queryId, ok := activeQueryTypes[string(rest_model.PostureCheckTypeDOMAIN)]; ok {
-			domainResponse := &rest_model.PostureResponseDomainCreate{
-				Domain: &cache.currentData.Domain,
-			}
-			domainResponse.SetID(&queryId)
-			domainResponse.SetTypeID(rest_model.PostureCheckTypeDOMAIN)
-
-			responses = append(responses, domainResponse)
+			responses = append(responses, newDomainResponse(queryId, cache.currentData))
 		}
 	}
 
 	if !stringz.EqualSlices(cache.currentData.MacAddresses, cache.previousData.MacAddresses) {
 		if queryId, ok := activeQueryTypes[string(rest_model.PostureCheckTypeMAC)]; ok {
-			macResponse := &rest_model.PostureResponseMacAddressCreate{
-				MacAddresses: cache.currentData.MacAddresses,
-			}
-			macResponse.SetID(&queryId)
-			macResponse.SetTypeID(rest_model.PostureCheckTypeMAC)
-
-			responses = append(responses, macResponse)
+			responses = append(responses, newMacResponse(queryId, cache.currentData))
 		}
 	}
 
 	if cache.previousData.Os.Version != cache.currentData.Os.Version || cache.previousData.Os.Type != cache.currentData.Os.Type {
 		if queryId, ok := activeQueryTypes[string(rest_model.PostureCheckTypeOS)]; ok {
-			osResponse := &rest_model.PostureResponseOperatingSystemCreate{
-				Type:    &cache.currentData.Os.Type,
-				Version: &cache.currentData.Os.Version,
-				Build:   "",
-			}
-			osResponse.SetID(&queryId)
-			osResponse.SetTypeID(rest_model.PostureCheckTypeOS)
-
-			responses = append(responses, osResponse)
+			responses = append(responses, newOsResponse(queryId, cache.currentData))
 		}
 	}
 
@@ -201,35 +265,65 @@ func (cache *Cache) GetChangedResponses() []rest_model.PostureResponseCreate {
 		}
 
 		if sendResponse {
-			processResponse := &rest_model.PostureResponseProcessCreate{
-				Path:               processPath,
-				Hash:               curState.Hash,
-				SignerFingerprints: curState.SignerFingerprints,
-				IsRunning:          curState.IsRunning,
-			}
-
-			processResponse.SetID(&queryId)
-			processResponse.SetTypeID(rest_model.PostureCheckTypePROCESS)
-			responses = append(responses, processResponse)
+			responses = append(responses, newProcessResponse(queryId, processPath, curState))
 		}
 	})
 
 	return responses
 }
 
-// Refresh refreshes posture data
+// Refresh refreshes posture data from the providers. Data which a provider fails to supply keeps its previous value.
 func (cache *Cache) Refresh() {
-	cache.previousData = cache.currentData
+	previous := cache.currentData
+	cache.previousData = previous
 
 	cache.currentData = NewCacheData()
-	cache.currentData.Os = Os()
+	log := pfxlog.Logger()
+
+	if osInfo, err := cache.providers.Os.Os(); err != nil {
+		log.WithError(err).Warn("unable to refresh os posture data")
+		cache.currentData.Os = previous.Os
+	} else {
+		cache.currentData.Os = osInfo
+	}
 
-	cache.currentData.Domain = cache.DomainFunc()
-	cache.currentData.MacAddresses = MacAddresses()
+	if domain, err := cache.providers.Domain.Domain(); err != nil {
+		log.WithError(err).Warn("unable to refresh domain posture data")
+		cache.currentData.Domain = previous.Domain
+	} else {
+		cache.currentData.Domain = domain
+	}
+
+	if macAddresses, err := cache.providers.Mac.MacAddresses(); err != nil {
+		log.WithError(err).Warn("unable to refresh mac address posture data")
+		cache.currentData.MacAddresses = previous.MacAddresses
+	} else {
+		cache.currentData.MacAddresses = macAddresses
+	}
+
+	if cache.providers.EndpointState != nil {
+		if state, err := cache.providers.EndpointState.EndpointState(); err != nil {
+			log.WithError(err).Warn("unable to refresh endpoint state posture data")
+			cache.currentData.EndpointState = previous.EndpointState
+		} else {
+			cache.currentData.EndpointState = state
+		}
+	}
 
 	keys := cache.watchedProcesses.Keys()
+
+	// executables which didn't exist, or were replaced, when they were last watched are watched again
+	cache.watcher.setPaths(keys)
+
 	for _, processPath := range keys {
-		cache.currentData.Processes.Set(processPath, Process(processPath))
+		if processInfo, err := cache.providers.Process.Process(processPath); err != nil {
+			log.WithError(err).WithField("path", processPath).Warn("unable to refresh process posture data")
+			if prevInfo, found := previous.Processes.Get(processPath); found {
+				cache.currentData.Processes.Set(processPath, prevInfo)
+			}
+		} else {
+			cache.currentData.Processes.Set(processPath, processInfo)
+		}
 	}
 }
 
@@ -241,19 +335,48 @@ func (cache *Cache) SetServiceQueryMap(serviceQueryMap map[string]map[string]res
 	var processPaths []string
 	for _, queryMap := range serviceQueryMap {
 		for _, query := range queryMap {
-			if *query.QueryType == rest_model.PostureCheckTypePROCESS && query.Process != nil {
-				processPaths = append(processPaths, query.Process.Path)
+			query := query
+			for _, process := range queryProcesses(&query) {
+				processPaths = append(processPaths, process.Path)
 			}
 		}
 	}
 	cache.setWatchedProcesses(processPaths)
 }
 
+// queryProcesses returns the processes of a process or multi process query, and nil for other queries
+func queryProcesses(query *rest_model.PostureQuery) []*rest_model.PostureQueryProcess {
+	if query.QueryType == nil {
+		return nil
+	}
+
+	switch *query.QueryType {
+	case rest_model.PostureCheckTypePROCESS, rest_model.PostureCheckTypePROCESSMULTI:
+		var result []*rest_model.PostureQueryProcess
+		if query.Process != nil {
+			result = append(result, query.Process)
+		}
+		for _, process := range query.Processes {
+			if process != nil {
+				result = append(result, process)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
 func (cache *Cache) AddActiveService(serviceId string) {
 	cache.activeServices.Set(serviceId, struct{}{})
 	cache.Evaluate()
 }
 
+// IsActiveService returns true if the service has been added with AddActiveService and not removed since
+func (cache *Cache) IsActiveService(serviceId string) bool {
+	return cache.activeServices.Has(serviceId)
+}
+
 func (cache *Cache) RemoveActiveService(serviceId string) {
 	cache.activeServices.Remove(serviceId)
 	cache.Evaluate()
@@ -261,8 +384,10 @@ func (cache *Cache) RemoveActiveService(serviceId string) {
 
 func (cache *Cache) start() {
 	cache.startOnce.Do(func() {
-		ticker := time.NewTicker(10 * time.Second)
+		ticker := time.NewTicker(cache.pollInterval)
 		go func() {
+			defer ticker.Stop()
+			defer cache.watcher.close()
 			defer func() {
 				if r := recover(); r != nil {
 					pfxlog.Logger().Errorf("error during posture response streaming: %v", r)
@@ -273,6 +398,21 @@ func (cache *Cache) start() {
 				select {
 				case <-ticker.C:
 					cache.Evaluate()
+				case <-cache.changed:
+					select {
+					case <-time.After(changeSettleDelay):
+					case <-cache.closeNotify:
+						return
+					}
+
+					// changes noticed while settling are covered by this evaluation
+					select {
+					case <-cache.changed:
+					default:
+					}
+
+					cache.Evaluate()
+					ticker.Reset(cache.pollInterval)
 				case <-cache.closeNotify:
 					return
 				}
@@ -281,17 +421,31 @@ func (cache *Cache) start() {
 	})
 }
 
+// SendResponses submits responses to the controller, returning the errors of failed submissions. Responses are only
+// recorded when the cache is simulating.
 func (cache *Cache) SendResponses(responses []rest_model.PostureResponseCreate) []error {
+	if cache.simulate {
+		for _, response := range responses {
+			cache.recordSubmitted(response, nil)
+		}
+		return nil
+	}
+
 	if cache.doSingleSubmissions {
 		var allErrors []error
+		var submitted []rest_model.PostureResponseCreate
 		for _, response := range responses {
 			err := cache.ctrlClient.SendPostureResponse(response)
+			cache.recordSubmitted(response, err)
 
 			if err != nil {
 				allErrors = append(allErrors, err)
+			} else {
+				submitted = append(submitted, response)
 			}
 		}
 
+		cache.notifySubmitted(submitted)
 		return allErrors
 
 	} else {
@@ -301,10 +455,29 @@ func (cache *Cache) SendResponses(responses []rest_model.PostureResponseCreate)
 			cache.doSingleSubmissions = true
 			return cache.SendResponses(responses)
 		}
-		return []error{err}
+
+		for _, response := range responses {
+			cache.recordSubmitted(response, err)
+		}
+
+		if err != nil {
+			return []error{err}
+		}
+
+		cache.notifySubmitted(responses)
+		return nil
 	}
 }
 
+func (cache *Cache) notifySubmitted(responses []rest_model.PostureResponseCreate) {
+	if cache.onSubmitted != nil && len(responses) > 0 {
+		cache.onSubmitted(responses)
+	}
+}
+
+// EndpointStateQueryId is the query id endpoint state responses are sent with, as they don't answer a query
+const EndpointStateQueryId = "0"
+
 type Submitter interface {
 	SendPostureResponse(response rest_model.PostureResponseCreate) error
 	SendPostureResponseBulk(responses []rest_model.PostureResponseCreate) error
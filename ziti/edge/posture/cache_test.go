@@ -0,0 +1,330 @@
+package posture
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+type testSubmitter struct {
+	lock      sync.Mutex
+	responses []rest_model.PostureResponseCreate
+	err       error
+}
+
+func (self *testSubmitter) SendPostureResponse(response rest_model.PostureResponseCreate) error {
+	return self.SendPostureResponseBulk([]rest_model.PostureResponseCreate{response})
+}
+
+func (self *testSubmitter) SendPostureResponseBulk(responses []rest_model.PostureResponseCreate) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.responses = append(self.responses, responses...)
+	return self.err
+}
+
+func (self *testSubmitter) take() map[rest_model.PostureCheckType]rest_model.PostureResponseCreate {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	result := map[rest_model.PostureCheckType]rest_model.PostureResponseCreate{}
+	for _, response := range self.responses {
+		result[response.TypeID()] = response
+	}
+	self.responses = nil
+	return result
+}
+
+func Test_Cache_Providers(t *testing.T) {
+	osInfo := OsInfo{Type: "linux", Version: "6.1.0"}
+	var osErr error
+	domain := "corp.example.com"
+	endpointState := EndpointState{}
+	processes := map[string]ProcessInfo{"/usr/bin/agent": {IsRunning: true, Hash: "abc"}}
+
+	providers := &Providers{
+		Os: OsProviderFunc(func() (OsInfo, error) {
+			return osInfo, osErr
+		}),
+		Mac: MacProviderFunc(func() ([]string, error) {
+			return []string{"00:11:22:33:44:55"}, nil
+		}),
+		Domain: DomainProviderFunc(func() (string, error) {
+			return domain, nil
+		}),
+		Process: ProcessProviderFunc(func(path string) (ProcessInfo, error) {
+			return processes[path], nil
+		}),
+		EndpointState: EndpointStateProviderFunc(func() (EndpointState, error) {
+			return endpointState, nil
+		}),
+	}
+
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	submitter := &testSubmitter{}
+	cache := NewCacheWithProviders(submitter, closeNotify, providers)
+
+	query := func(id string, queryType rest_model.PostureCheckType) rest_model.PostureQuery {
+		result := rest_model.PostureQuery{QueryType: &queryType}
+		result.ID = &id
+		if queryType == rest_model.PostureCheckTypePROCESS {
+			result.Process = &rest_model.PostureQueryProcess{Path: "/usr/bin/agent"}
+		}
+		return result
+	}
+
+	cache.SetServiceQueryMap(map[string]map[string]rest_model.PostureQuery{
+		"svc": {
+			"os":      query("os", rest_model.PostureCheckTypeOS),
+			"mac":     query("mac", rest_model.PostureCheckTypeMAC),
+			"domain":  query("domain", rest_model.PostureCheckTypeDOMAIN),
+			"process": query("process", rest_model.PostureCheckTypePROCESS),
+		},
+	})
+	cache.AddActiveService("svc")
+
+	t.Run("responses use provider data", func(t *testing.T) {
+		req := require.New(t)
+		responses := submitter.take()
+		req.Len(responses, 4)
+
+		osResponse := responses[rest_model.PostureCheckTypeOS].(*rest_model.PostureResponseOperatingSystemCreate)
+		req.Equal("linux", *osResponse.Type)
+		req.Equal("6.1.0", *osResponse.Version)
+
+		macResponse := responses[rest_model.PostureCheckTypeMAC].(*rest_model.PostureResponseMacAddressCreate)
+		req.Equal([]string{"00:11:22:33:44:55"}, macResponse.MacAddresses)
+
+		domainResponse := responses[rest_model.PostureCheckTypeDOMAIN].(*rest_model.PostureResponseDomainCreate)
+		req.Equal("corp.example.com", *domainResponse.Domain)
+
+		processResponse := responses[rest_model.PostureCheckTypePROCESS].(*rest_model.PostureResponseProcessCreate)
+		req.True(processResponse.IsRunning)
+		req.Equal("abc", processResponse.Hash)
+	})
+
+	t.Run("only changed data is sent", func(t *testing.T) {
+		req := require.New(t)
+		domain = "other.example.com"
+		cache.Evaluate()
+
+		responses := submitter.take()
+		req.Len(responses, 1)
+		req.Equal("other.example.com", *responses[rest_model.PostureCheckTypeDOMAIN].(*rest_model.PostureResponseDomainCreate).Domain)
+	})
+
+	t.Run("provider errors keep the previous data", func(t *testing.T) {
+		req := require.New(t)
+		osErr = errors.New("mdm agent unavailable")
+		osInfo = OsInfo{}
+		cache.Evaluate()
+		req.Empty(submitter.take())
+
+		osErr = nil
+		osInfo = OsInfo{Type: "linux", Version: "6.2.0"}
+		cache.Evaluate()
+		responses := submitter.take()
+		req.Len(responses, 1)
+		req.Equal("6.2.0", *responses[rest_model.PostureCheckTypeOS].(*rest_model.PostureResponseOperatingSystemCreate).Version)
+	})
+
+	t.Run("endpoint state is sent when the endpoint wakes", func(t *testing.T) {
+		req := require.New(t)
+		endpointState = EndpointState{Woken: true}
+		cache.Evaluate()
+
+		responses := submitter.take()
+		req.Len(responses, 1)
+		response := responses["ENDPOINT_STATE"].(*rest_model.PostureResponseEndpointStateCreate)
+		req.True(response.Woken)
+		req.False(response.Unlocked)
+
+		cache.Evaluate()
+		req.Empty(submitter.take())
+	})
+}
+
+func Test_Cache_EvaluatesOnChange(t *testing.T) {
+	req := require.New(t)
+
+	var lock sync.Mutex
+	domain := "corp.example.com"
+
+	providers := &Providers{
+		Domain: DomainProviderFunc(func() (string, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return domain, nil
+		}),
+	}
+
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	submitter := &testSubmitter{}
+	cache := NewCacheWithOptions(submitter, closeNotify, &CacheOptions{
+		Providers:    providers,
+		PollInterval: time.Hour,
+	})
+
+	queryType := rest_model.PostureCheckTypeDOMAIN
+	queryId := "domain"
+	query := rest_model.PostureQuery{QueryType: &queryType}
+	query.ID = &queryId
+
+	cache.SetServiceQueryMap(map[string]map[string]rest_model.PostureQuery{
+		"svc": {queryId: query},
+	})
+	cache.AddActiveService("svc")
+	req.Contains(submitter.take(), rest_model.PostureCheckTypeDOMAIN)
+
+	lock.Lock()
+	domain = "other.example.com"
+	lock.Unlock()
+
+	cache.notifyChanged("test")
+
+	var response rest_model.PostureResponseCreate
+	req.Eventually(func() bool {
+		response = submitter.take()[rest_model.PostureCheckTypeDOMAIN]
+		return response != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	req.Equal("other.example.com", *response.(*rest_model.PostureResponseDomainCreate).Domain)
+}
+
+func Test_Cache_QueryStatus(t *testing.T) {
+	domain := "corp.example.com"
+	providers := &Providers{
+		Domain: DomainProviderFunc(func() (string, error) {
+			return domain, nil
+		}),
+		Process: ProcessProviderFunc(func(path string) (ProcessInfo, error) {
+			return ProcessInfo{IsRunning: path != "/usr/bin/stopped", Hash: "abc"}, nil
+		}),
+	}
+
+	newQuery := func(id string, queryType rest_model.PostureCheckType, isPassing bool) *rest_model.PostureQuery {
+		result := &rest_model.PostureQuery{QueryType: &queryType, IsPassing: &isPassing}
+		result.ID = &id
+		if queryType == rest_model.PostureCheckTypePROCESS {
+			result.Process = &rest_model.PostureQueryProcess{Path: "/usr/bin/agent"}
+		}
+		return result
+	}
+
+	domainQuery := newQuery("domain", rest_model.PostureCheckTypeDOMAIN, false)
+	processQuery := newQuery("process", rest_model.PostureCheckTypePROCESS, true)
+	mfaQuery := newQuery("mfa", rest_model.PostureCheckTypeMFA, true)
+	multiQuery := newQuery("multi", rest_model.PostureCheckTypePROCESSMULTI, false)
+	multiQuery.Processes = []*rest_model.PostureQueryProcess{
+		{Path: "/usr/bin/agent"},
+		{Path: "/usr/bin/stopped"},
+	}
+
+	newCache := func(submitter Submitter, options *CacheOptions) *Cache {
+		closeNotify := make(chan struct{})
+		t.Cleanup(func() { close(closeNotify) })
+
+		options.Providers = providers
+		options.PollInterval = time.Hour
+		cache := NewCacheWithOptions(submitter, closeNotify, options)
+		cache.SetServiceQueryMap(map[string]map[string]rest_model.PostureQuery{
+			"svc": {"domain": *domainQuery, "process": *processQuery, "mfa": *mfaQuery, "multi": *multiQuery},
+		})
+		cache.AddActiveService("svc")
+		return cache
+	}
+
+	t.Run("submitted and current responses are reported", func(t *testing.T) {
+		req := require.New(t)
+		submitter := &testSubmitter{}
+		cache := newCache(submitter, &CacheOptions{})
+		req.Len(submitter.take(), 2)
+
+		status := cache.QueryStatus(domainQuery)
+		req.False(status.IsPassing)
+		req.Len(status.Submitted, 1)
+		req.NoError(status.Submitted[0].Err)
+		req.False(status.Submitted[0].Simulated)
+		req.Equal("corp.example.com", *status.Submitted[0].Response.(*rest_model.PostureResponseDomainCreate).Domain)
+		req.Len(status.Current, 1)
+		req.Equal("corp.example.com", *status.Current[0].(*rest_model.PostureResponseDomainCreate).Domain)
+
+		status = cache.QueryStatus(processQuery)
+		req.True(status.IsPassing)
+		req.Len(status.Submitted, 1)
+		req.Len(status.Current, 1)
+		req.Equal("/usr/bin/agent", status.Current[0].(*rest_model.PostureResponseProcessCreate).Path)
+
+		status = cache.QueryStatus(mfaQuery)
+		req.True(status.IsPassing)
+		req.Empty(status.Submitted)
+		req.Empty(status.Current)
+	})
+
+	t.Run("submit errors are reported", func(t *testing.T) {
+		req := require.New(t)
+		submitter := &testSubmitter{err: errors.New("controller unavailable")}
+		cache := newCache(submitter, &CacheOptions{})
+
+		status := cache.QueryStatus(domainQuery)
+		req.Len(status.Submitted, 1)
+		req.EqualError(status.Submitted[0].Err, "controller unavailable")
+	})
+
+	t.Run("simulating caches don't submit", func(t *testing.T) {
+		req := require.New(t)
+		submitter := &testSubmitter{}
+		cache := newCache(submitter, &CacheOptions{Simulate: true})
+		req.Empty(submitter.take())
+
+		status := cache.QueryStatus(domainQuery)
+		req.Len(status.Submitted, 1)
+		req.True(status.Submitted[0].Simulated)
+		req.Equal("corp.example.com", *status.Submitted[0].Response.(*rest_model.PostureResponseDomainCreate).Domain)
+		req.Nil(status.LocalPassing)
+	})
+
+	t.Run("process queries are evaluated locally", func(t *testing.T) {
+		req := require.New(t)
+		cache := newCache(&testSubmitter{}, &CacheOptions{Simulate: true})
+
+		status := cache.QueryStatus(processQuery)
+		req.NotNil(status.LocalPassing)
+		req.True(*status.LocalPassing)
+
+		// the processes of multi process queries are watched as well
+		status = cache.QueryStatus(multiQuery)
+		req.Len(status.Current, 2)
+		req.NotNil(status.LocalPassing)
+		req.False(*status.LocalPassing)
+
+		// processes for other operating systems don't apply
+		otherOsQuery := newQuery("multi", rest_model.PostureCheckTypePROCESSMULTI, false)
+		otherOsQuery.Processes = []*rest_model.PostureQueryProcess{
+			{Path: "/usr/bin/agent"},
+			{Path: "/usr/bin/stopped", OsType: rest_model.OsTypeIOS},
+		}
+		status = cache.QueryStatus(otherOsQuery)
+		req.True(*status.LocalPassing)
+
+		// without posture data for a process, the query can't be evaluated locally
+		unwatchedQuery := newQuery("unwatched", rest_model.PostureCheckTypePROCESSMULTI, false)
+		unwatchedQuery.Processes = []*rest_model.PostureQueryProcess{
+			{Path: "/usr/bin/agent"},
+			{Path: "/usr/bin/other"},
+		}
+		status = cache.QueryStatus(unwatchedQuery)
+		req.Nil(status.LocalPassing)
+
+		otherOsQuery.Processes[0].OsType = rest_model.OsTypeIOS
+		status = cache.QueryStatus(otherOsQuery)
+		req.Nil(status.LocalPassing)
+	})
+}
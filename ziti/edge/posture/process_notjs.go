@@ -27,8 +27,127 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+type hashedExecutable struct {
+	watches    int
+	generation uint64
+	hashed     bool
+	size       int64
+	modTime    time.Time
+	hash       string
+}
+
+// hashCache holds the hashes of process executables while a platform watcher reports their changes. An executable
+// can be replaced by one with the same size and modification time, so without a watcher executables are hashed on
+// every evaluation.
+type hashCache struct {
+	lock    sync.Mutex
+	entries map[string]*hashedExecutable
+}
+
+var executableHashes = &hashCache{entries: map[string]*hashedExecutable{}}
+
+// watch enables caching for path, until a matching unwatch
+func (self *hashCache) watch(path string) {
+	path = filepath.Clean(path)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entry, found := self.entries[path]
+	if !found {
+		entry = &hashedExecutable{}
+		self.entries[path] = entry
+	}
+	entry.watches++
+}
+
+func (self *hashCache) unwatch(path string) {
+	path = filepath.Clean(path)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry, found := self.entries[path]; found {
+		entry.watches--
+		entry.generation++
+		entry.hashed = false
+		if entry.watches <= 0 {
+			delete(self.entries, path)
+		}
+	}
+}
+
+// invalidate drops the cached hash of path, called when a watcher reports a change to it
+func (self *hashCache) invalidate(path string) {
+	path = filepath.Clean(path)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry, found := self.entries[path]; found {
+		entry.generation++
+		entry.hashed = false
+	}
+}
+
+// get returns the cached hash of path if there is one, otherwise the generation to pass to put
+func (self *hashCache) get(path string, stat os.FileInfo) (string, uint64, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entry, found := self.entries[path]
+	if !found {
+		return "", 0, false
+	}
+
+	if entry.hashed && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+		return entry.hash, entry.generation, true
+	}
+	return "", entry.generation, false
+}
+
+// put caches the hash of path, unless path is not watched or changed since the generation was returned from get
+func (self *hashCache) put(path string, generation uint64, stat os.FileInfo, hash string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry, found := self.entries[path]; found && entry.generation == generation {
+		entry.hashed = true
+		entry.size = stat.Size()
+		entry.modTime = stat.ModTime()
+		entry.hash = hash
+	}
+}
+
+// executableHash returns the sha512 hash of the executable at path. Hashes are only reused while a watcher reports
+// changes to the executable.
+func executableHash(path string) (string, error) {
+	path = filepath.Clean(path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	hash, generation, found := executableHashes.get(path, stat)
+	if found {
+		return hash, nil
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha512.Sum512(file)
+	hash = fmt.Sprintf("%x", sum[:])
+
+	executableHashes.put(path, generation, stat, hash)
+
+	return hash, nil
+}
+
 func Process(providedPath string) ProcessInfo {
 	expectedPath := filepath.Clean(providedPath)
 
@@ -61,7 +180,7 @@ func Process(providedPath string) ProcessInfo {
 
 		if strings.EqualFold(executablePath, expectedPath) {
 			isRunning, _ := procDetails.IsRunning()
-			file, err := os.ReadFile(executablePath)
+			hash, err := executableHash(executablePath)
 
 			if err != nil {
 				pfxlog.Logger().Warnf("could not read process executable file: %v", err)
@@ -72,9 +191,6 @@ func Process(providedPath string) ProcessInfo {
 				}
 			}
 
-			sum := sha512.Sum512(file)
-			hash := fmt.Sprintf("%x", sum[:])
-
 			signerFingerprints, err := getSignerFingerprints(executablePath)
 
 			if err != nil {
@@ -0,0 +1,31 @@
+//go:build !js
+
+package posture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_executableHash_RehashesUnwatchedExecutables(t *testing.T) {
+	req := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "agent")
+	req.NoError(os.WriteFile(path, []byte("v1"), 0755))
+	stat, err := os.Stat(path)
+	req.NoError(err)
+
+	hash, err := executableHash(path)
+	req.NoError(err)
+
+	// a replacement with the same size and modification time must not report the old hash
+	req.NoError(os.WriteFile(path, []byte("v2"), 0755))
+	req.NoError(os.Chtimes(path, stat.ModTime(), stat.ModTime()))
+
+	changedHash, err := executableHash(path)
+	req.NoError(err)
+	req.NotEqual(hash, changedHash)
+}
@@ -0,0 +1,136 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package posture
+
+// OsProvider supplies the operating system reported for OS posture checks
+type OsProvider interface {
+	Os() (OsInfo, error)
+}
+
+// MacProvider supplies the MAC addresses reported for MAC posture checks
+type MacProvider interface {
+	MacAddresses() ([]string, error)
+}
+
+// DomainProvider supplies the Windows domain reported for DOMAIN posture checks
+type DomainProvider interface {
+	Domain() (string, error)
+}
+
+// ProcessProvider supplies the state of the processes watched by PROCESS and PROCESS_MULTI posture checks
+type ProcessProvider interface {
+	Process(path string) (ProcessInfo, error)
+}
+
+// EndpointStateProvider supplies whether the endpoint has been woken or unlocked, which allows posture checks with
+// MFA prompts on wake or unlock to re-prompt. It has no built-in implementation.
+type EndpointStateProvider interface {
+	EndpointState() (EndpointState, error)
+}
+
+// EndpointState is reported to the controller whenever it changes and the endpoint has been woken or unlocked
+type EndpointState struct {
+	Woken    bool
+	Unlocked bool
+}
+
+type OsProviderFunc func() (OsInfo, error)
+
+func (f OsProviderFunc) Os() (OsInfo, error) {
+	return f()
+}
+
+type MacProviderFunc func() ([]string, error)
+
+func (f MacProviderFunc) MacAddresses() ([]string, error) {
+	return f()
+}
+
+type DomainProviderFunc func() (string, error)
+
+func (f DomainProviderFunc) Domain() (string, error) {
+	return f()
+}
+
+type ProcessProviderFunc func(path string) (ProcessInfo, error)
+
+func (f ProcessProviderFunc) Process(path string) (ProcessInfo, error) {
+	return f(path)
+}
+
+type EndpointStateProviderFunc func() (EndpointState, error)
+
+func (f EndpointStateProviderFunc) EndpointState() (EndpointState, error) {
+	return f()
+}
+
+// Providers supply the data of posture responses. Providers which are nil use the built-in collectors, which inspect
+// the local host. Providers can replace them where the host isn't the right source of posture, such as in containers,
+// or where posture is managed by an MDM agent, an attestation file or a sidecar.
+//
+// If a provider returns an error, the last value it provided is kept, so no change is reported.
+type Providers struct {
+	Os            OsProvider
+	Mac           MacProvider
+	Domain        DomainProvider
+	Process       ProcessProvider
+	EndpointState EndpointStateProvider
+}
+
+// DefaultOsProvider collects the operating system of the local host
+var DefaultOsProvider OsProvider = OsProviderFunc(func() (OsInfo, error) {
+	return Os(), nil
+})
+
+// DefaultMacProvider collects the MAC addresses of the local network interfaces
+var DefaultMacProvider MacProvider = MacProviderFunc(func() ([]string, error) {
+	return MacAddresses(), nil
+})
+
+// DefaultProcessProvider inspects the processes of the local host
+var DefaultProcessProvider ProcessProvider = ProcessProviderFunc(func(path string) (ProcessInfo, error) {
+	return Process(path), nil
+})
+
+// withDefaults returns a copy of the providers with the built-in collectors in place of missing providers. The domain
+// defaults to the cache's DomainFunc.
+func (self *Providers) withDefaults(cache *Cache) *Providers {
+	result := &Providers{}
+	if self != nil {
+		*result = *self
+	}
+
+	if result.Os == nil {
+		result.Os = DefaultOsProvider
+	}
+
+	if result.Mac == nil {
+		result.Mac = DefaultMacProvider
+	}
+
+	if result.Domain == nil {
+		result.Domain = DomainProviderFunc(func() (string, error) {
+			return cache.DomainFunc(), nil
+		})
+	}
+
+	if result.Process == nil {
+		result.Process = DefaultProcessProvider
+	}
+
+	return result
+}
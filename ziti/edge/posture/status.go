@@ -0,0 +1,201 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package posture
+
+import (
+	"github.com/openziti/edge-api/rest_model"
+	"strings"
+	"time"
+)
+
+// SubmittedResponse is the last posture response submitted for a query, or for a process of a query
+type SubmittedResponse struct {
+	Response rest_model.PostureResponseCreate
+	Time     time.Time
+
+	// Err is the error returned by the controller when submitting the response, nil if it was accepted
+	Err error
+
+	// Simulated is true if the response was recorded by a simulating cache rather than submitted
+	Simulated bool
+}
+
+// QueryStatus describes a posture query, whether the controller considers it passing and the posture data sent, and
+// that would be sent now, to answer it
+type QueryStatus struct {
+	Query *rest_model.PostureQuery
+
+	// IsPassing is whether the query passed when the controller last evaluated it, as of the last service refresh
+	IsPassing bool
+
+	// LocalPassing is whether the query passes against the current posture data, evaluated without the controller.
+	// It is only set for process queries, as their criteria, the paths and operating systems of the processes, are
+	// part of the query, and a process query passes locally when every process for this operating system is running.
+	// Hashes and signers are only checked by the controller. Nil if the query can't be evaluated locally, including
+	// while no posture data has been collected for one of its processes.
+	LocalPassing *bool
+
+	// Submitted holds the last responses submitted for the query, one per process for process queries
+	Submitted []*SubmittedResponse
+
+	// Current holds the responses the current posture data provides for the query, which are what a controller
+	// evaluates. There are none for queries which aren't answered with posture data, such as MFA queries, or for
+	// processes which aren't watched by an active service.
+	Current []rest_model.PostureResponseCreate
+}
+
+// QueryStatus returns the status of the given query. The current responses reflect the posture data collected by the
+// last evaluation, call Evaluate first to refresh it.
+func (cache *Cache) QueryStatus(query *rest_model.PostureQuery) *QueryStatus {
+	cache.lock.Lock()
+	data := cache.currentData
+	cache.lock.Unlock()
+
+	result := &QueryStatus{
+		Query: query,
+	}
+
+	if query.IsPassing != nil {
+		result.IsPassing = *query.IsPassing
+	}
+
+	if query.ID == nil || query.QueryType == nil {
+		return result
+	}
+
+	queryId := *query.ID
+	addSubmitted := func(key string) {
+		if submitted, found := cache.submitted.Get(key); found {
+			result.Submitted = append(result.Submitted, submitted)
+		}
+	}
+
+	switch *query.QueryType {
+	case rest_model.PostureCheckTypeOS:
+		result.Current = append(result.Current, newOsResponse(queryId, data))
+		addSubmitted(queryId)
+	case rest_model.PostureCheckTypeMAC:
+		result.Current = append(result.Current, newMacResponse(queryId, data))
+		addSubmitted(queryId)
+	case rest_model.PostureCheckTypeDOMAIN:
+		result.Current = append(result.Current, newDomainResponse(queryId, data))
+		addSubmitted(queryId)
+	case rest_model.PostureCheckTypePROCESS, rest_model.PostureCheckTypePROCESSMULTI:
+		var applicable, running int
+		collected := true
+		for _, process := range queryProcesses(query) {
+			info, found := data.Processes.Get(process.Path)
+			if found {
+				result.Current = append(result.Current, newProcessResponse(queryId, process.Path, info))
+			}
+			addSubmitted(queryId + "|" + process.Path)
+
+			if isOsType(data.Os, process.OsType) {
+				applicable++
+				if !found {
+					collected = false
+				} else if info.IsRunning {
+					running++
+				}
+			}
+		}
+
+		if applicable > 0 && collected {
+			localPassing := running == applicable
+			result.LocalPassing = &localPassing
+		}
+	default:
+		addSubmitted(queryId)
+	}
+
+	return result
+}
+
+// isOsType returns true if the given OS is of the given type, or no type is given
+func isOsType(os OsInfo, osType rest_model.OsType) bool {
+	switch {
+	case osType == "":
+		return true
+	case osType == rest_model.OsTypeMacOS:
+		return os.Type == "darwin" || strings.EqualFold(os.Type, string(osType))
+	default:
+		return strings.EqualFold(os.Type, string(osType))
+	}
+}
+
+// recordSubmitted keeps the outcome of submitting a response for QueryStatus
+func (cache *Cache) recordSubmitted(response rest_model.PostureResponseCreate, err error) {
+	if response.ID() == nil {
+		return
+	}
+
+	key := *response.ID()
+	if processResponse, ok := response.(*rest_model.PostureResponseProcessCreate); ok {
+		key += "|" + processResponse.Path
+	}
+
+	cache.submitted.Set(key, &SubmittedResponse{
+		Response:  response,
+		Time:      time.Now(),
+		Err:       err,
+		Simulated: cache.simulate,
+	})
+}
+
+func newOsResponse(queryId string, data *CacheData) *rest_model.PostureResponseOperatingSystemCreate {
+	osType := data.Os.Type
+	osVersion := data.Os.Version
+	osResponse := &rest_model.PostureResponseOperatingSystemCreate{
+		Type:    &osType,
+		Version: &osVersion,
+		Build:   "",
+	}
+	osResponse.SetID(&queryId)
+	osResponse.SetTypeID(rest_model.PostureCheckTypeOS)
+	return osResponse
+}
+
+func newMacResponse(queryId string, data *CacheData) *rest_model.PostureResponseMacAddressCreate {
+	macResponse := &rest_model.PostureResponseMacAddressCreate{
+		MacAddresses: data.MacAddresses,
+	}
+	macResponse.SetID(&queryId)
+	macResponse.SetTypeID(rest_model.PostureCheckTypeMAC)
+	return macResponse
+}
+
+func newDomainResponse(queryId string, data *CacheData) *rest_model.PostureResponseDomainCreate {
+	domain := data.Domain
+	domainResponse := &rest_model.PostureResponseDomainCreate{
+		Domain: &domain,
+	}
+	domainResponse.SetID(&queryId)
+	domainResponse.SetTypeID(rest_model.PostureCheckTypeDOMAIN)
+	return domainResponse
+}
+
+func newProcessResponse(queryId, processPath string, info ProcessInfo) *rest_model.PostureResponseProcessCreate {
+	processResponse := &rest_model.PostureResponseProcessCreate{
+		Path:               processPath,
+		Hash:               info.Hash,
+		SignerFingerprints: info.SignerFingerprints,
+		IsRunning:          info.IsRunning,
+	}
+	processResponse.SetID(&queryId)
+	processResponse.SetTypeID(rest_model.PostureCheckTypePROCESS)
+	return processResponse
+}
@@ -0,0 +1,32 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package posture
+
+// changeWatcher uses platform notifications to detect changes to posture data as they happen, so that responses can
+// be sent without waiting for the next poll. Watchers are best effort: notifications which aren't available on a
+// platform, or to an unprivileged process, are left to polling.
+type changeWatcher interface {
+	// setPaths sets the process executables to watch
+	setPaths(paths []string)
+	close()
+}
+
+type noopWatcher struct{}
+
+func (noopWatcher) setPaths([]string) {}
+
+func (noopWatcher) close() {}
@@ -0,0 +1,361 @@
+//go:build linux
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package posture
+
+import (
+	"encoding/binary"
+	"github.com/michaelquigley/pfxlog"
+	"golang.org/x/sys/cpu"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const (
+	inotifyMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_MOVE_SELF | unix.IN_DELETE_SELF
+
+	// connector and proc event constants from linux/connector.h and linux/cn_proc.h
+	cnIdxProc         = 0x1
+	cnValProc         = 0x1
+	procCnMcastListen = 1
+	procEventExec     = 0x00000002
+	procEventExit     = 0x80000000
+
+	sizeofCnMsg = 20
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	if cpu.IsBigEndian {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// linuxWatcher reports interface and address changes from a netlink route socket, modifications of watched
+// executables from inotify, and starts and exits of watched processes from the proc connector. The proc connector
+// requires CAP_NET_ADMIN; without it process starts and exits are left to polling.
+type linuxWatcher struct {
+	onChange func(reason string)
+
+	lock     sync.Mutex
+	files    []*os.File
+	inotify  *os.File
+	watches  map[int]string // inotify watch descriptor -> path
+	paths    map[string]int // path -> inotify watch descriptor
+	pids     map[int]string // pid of a running watched process -> path
+	closed   bool
+	procConn bool
+}
+
+func newChangeWatcher(onChange func(reason string)) changeWatcher {
+	watcher := &linuxWatcher{
+		onChange: onChange,
+		watches:  map[int]string{},
+		paths:    map[string]int{},
+		pids:     map[int]string{},
+	}
+
+	log := pfxlog.Logger()
+
+	if file, err := watcher.openRouteSocket(); err != nil {
+		log.WithError(err).Debug("unable to watch network interfaces for posture changes")
+	} else {
+		watcher.files = append(watcher.files, file)
+		go watcher.readLoop(file, watcher.handleRouteMessages)
+	}
+
+	if fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK); err != nil {
+		log.WithError(err).Debug("unable to watch process executables for posture changes")
+	} else {
+		watcher.inotify = os.NewFile(uintptr(fd), "inotify")
+		watcher.files = append(watcher.files, watcher.inotify)
+		go watcher.readLoop(watcher.inotify, watcher.handleInotifyEvents)
+	}
+
+	if file, err := watcher.openProcConnector(); err != nil {
+		log.WithError(err).Debug("unable to watch process starts and exits for posture changes, relying on polling")
+	} else {
+		watcher.procConn = true
+		watcher.files = append(watcher.files, file)
+		go watcher.readLoop(file, watcher.handleProcEvents)
+	}
+
+	return watcher
+}
+
+func (self *linuxWatcher) openRouteSocket() (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	}
+	if err = unix.Bind(fd, addr); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), "netlink-route"), nil
+}
+
+func (self *linuxWatcher) openProcConnector() (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	// nlmsghdr, followed by a cn_msg carrying the proc_cn_mcast_op
+	msg := make([]byte, unix.SizeofNlMsghdr+sizeofCnMsg+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], unix.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[12:], uint32(os.Getpid()))
+	cnMsg := msg[unix.SizeofNlMsghdr:]
+	nativeEndian.PutUint32(cnMsg[0:], cnIdxProc)
+	nativeEndian.PutUint32(cnMsg[4:], cnValProc)
+	nativeEndian.PutUint16(cnMsg[16:], 4)
+	nativeEndian.PutUint32(cnMsg[sizeofCnMsg:], procCnMcastListen)
+
+	if err = unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), "netlink-proc"), nil
+}
+
+func (self *linuxWatcher) readLoop(file *os.File, handle func([]byte)) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			self.lock.Lock()
+			closed := self.closed
+			self.lock.Unlock()
+
+			if !closed {
+				pfxlog.Logger().WithError(err).WithField("source", file.Name()).Debug("stopped watching for posture changes")
+			}
+			return
+		}
+		handle(buf[:n])
+	}
+}
+
+func (self *linuxWatcher) handleRouteMessages(buf []byte) {
+	messages, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return
+	}
+
+	for _, msg := range messages {
+		switch msg.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK, unix.RTM_NEWADDR, unix.RTM_DELADDR:
+			self.onChange("network interfaces changed")
+			return
+		}
+	}
+}
+
+func (self *linuxWatcher) handleInotifyEvents(buf []byte) {
+	changed := ""
+
+	self.lock.Lock()
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		wd := int(int32(nativeEndian.Uint32(buf[offset:])))
+		mask := nativeEndian.Uint32(buf[offset+4:])
+		nameLen := int(nativeEndian.Uint32(buf[offset+12:]))
+		offset += unix.SizeofInotifyEvent + nameLen
+
+		path, found := self.watches[wd]
+		if !found {
+			continue
+		}
+		changed = path
+		executableHashes.invalidate(path)
+
+		// the watch is gone once the file is deleted or replaced, such as by a package upgrade. It is added again
+		// for the new file the next time the paths are set.
+		if mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+			delete(self.watches, wd)
+			delete(self.paths, path)
+			executableHashes.unwatch(path)
+			if mask&unix.IN_IGNORED == 0 {
+				_, _ = unix.InotifyRmWatch(int(self.inotify.Fd()), uint32(wd))
+			}
+		}
+	}
+	self.lock.Unlock()
+
+	if changed != "" {
+		self.onChange("executable changed: " + changed)
+	}
+}
+
+func (self *linuxWatcher) handleProcEvents(buf []byte) {
+	messages, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return
+	}
+
+	for _, msg := range messages {
+		// cn_msg, followed by proc_event: what, cpu, timestamp and the event data, which starts with the pid and tgid
+		event := msg.Data
+		if len(event) < sizeofCnMsg+24 {
+			continue
+		}
+		event = event[sizeofCnMsg:]
+
+		what := nativeEndian.Uint32(event[0:])
+		pid := int(nativeEndian.Uint32(event[16:]))
+		tgid := int(nativeEndian.Uint32(event[20:]))
+
+		switch what {
+		case procEventExec:
+			self.lock.Lock()
+			path, watched := self.matchWatchedProcess(tgid)
+			self.lock.Unlock()
+
+			if watched {
+				self.onChange("process started: " + path)
+			}
+		case procEventExit:
+			if pid != tgid {
+				continue // a thread exited
+			}
+
+			self.lock.Lock()
+			path, watched := self.pids[pid]
+			delete(self.pids, pid)
+			self.lock.Unlock()
+
+			if watched {
+				self.onChange("process exited: " + path)
+			}
+		}
+	}
+}
+
+// matchWatchedProcess checks if the process with the given pid runs a watched executable, and tracks it if it does.
+// Must be called with the lock held.
+func (self *linuxWatcher) matchWatchedProcess(pid int) (string, bool) {
+	exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return "", false
+	}
+
+	if _, found := self.paths[exe]; !found {
+		if _, found = self.paths[filepath.Clean(exe)]; !found {
+			return "", false
+		}
+	}
+
+	self.pids[pid] = exe
+	return exe, true
+}
+
+func (self *linuxWatcher) setPaths(paths []string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed || self.inotify == nil {
+		return
+	}
+
+	fd := int(self.inotify.Fd())
+	wanted := map[string]struct{}{}
+	added := false
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		wanted[path] = struct{}{}
+
+		if _, found := self.paths[path]; found {
+			continue
+		}
+
+		wd, err := unix.InotifyAddWatch(fd, path, inotifyMask)
+		if err != nil {
+			continue // the executable may not exist yet, it will be retried with the next paths
+		}
+
+		self.watches[wd] = path
+		self.paths[path] = wd
+		executableHashes.watch(path)
+		added = true
+	}
+
+	for path, wd := range self.paths {
+		if _, found := wanted[path]; !found {
+			_, _ = unix.InotifyRmWatch(fd, uint32(wd))
+			delete(self.watches, wd)
+			delete(self.paths, path)
+			executableHashes.unwatch(path)
+		}
+	}
+
+	for pid, path := range self.pids {
+		if _, found := wanted[path]; !found {
+			delete(self.pids, pid)
+		}
+	}
+
+	// find the watched processes which are already running, so their exits are recognized
+	if added && self.procConn {
+		entries, err := os.ReadDir("/proc")
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			if pid, err := strconv.Atoi(entry.Name()); err == nil {
+				self.matchWatchedProcess(pid)
+			}
+		}
+	}
+}
+
+func (self *linuxWatcher) close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return
+	}
+	self.closed = true
+
+	for path := range self.paths {
+		executableHashes.unwatch(path)
+	}
+
+	for _, file := range self.files {
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+package posture
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWatcher(t *testing.T) (*linuxWatcher, <-chan string) {
+	reasons := make(chan string, 100)
+	watcher := newChangeWatcher(func(reason string) {
+		reasons <- reason
+	}).(*linuxWatcher)
+	t.Cleanup(watcher.close)
+	return watcher, reasons
+}
+
+func awaitReason(t *testing.T, reasons <-chan string, prefix string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case reason := <-reasons:
+			if strings.HasPrefix(reason, prefix) {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "no change reported", "expected a change starting with '%s'", prefix)
+		}
+	}
+}
+
+func Test_LinuxWatcher(t *testing.T) {
+	t.Run("executable changes are reported", func(t *testing.T) {
+		req := require.New(t)
+		watcher, reasons := newTestWatcher(t)
+		if watcher.inotify == nil {
+			t.Skip("inotify is not available")
+		}
+
+		path := filepath.Join(t.TempDir(), "agent")
+		req.NoError(os.WriteFile(path, []byte("v1"), 0755))
+
+		watcher.setPaths([]string{path})
+		req.NoError(os.WriteFile(path, []byte("v2"), 0755))
+		awaitReason(t, reasons, "executable changed: "+path)
+	})
+
+	t.Run("replaced executables are watched again", func(t *testing.T) {
+		req := require.New(t)
+		watcher, reasons := newTestWatcher(t)
+		if watcher.inotify == nil {
+			t.Skip("inotify is not available")
+		}
+
+		path := filepath.Join(t.TempDir(), "agent")
+		req.NoError(os.WriteFile(path, []byte("v1"), 0755))
+		watcher.setPaths([]string{path})
+
+		req.NoError(os.Remove(path))
+		awaitReason(t, reasons, "executable changed: "+path)
+
+		req.NoError(os.WriteFile(path, []byte("v2"), 0755))
+		watcher.setPaths([]string{path})
+		req.NoError(os.WriteFile(path, []byte("v3"), 0755))
+		awaitReason(t, reasons, "executable changed: "+path)
+	})
+
+	t.Run("unwatched executables aren't reported", func(t *testing.T) {
+		req := require.New(t)
+		watcher, reasons := newTestWatcher(t)
+		if watcher.inotify == nil {
+			t.Skip("inotify is not available")
+		}
+
+		path := filepath.Join(t.TempDir(), "agent")
+		req.NoError(os.WriteFile(path, []byte("v1"), 0755))
+		watcher.setPaths([]string{path})
+		watcher.setPaths(nil)
+
+		req.NoError(os.WriteFile(path, []byte("v2"), 0755))
+		select {
+		case reason := <-reasons:
+			req.False(strings.HasPrefix(reason, "executable changed"), reason)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("executable hashes are cached until the executable changes", func(t *testing.T) {
+		req := require.New(t)
+		watcher, reasons := newTestWatcher(t)
+		if watcher.inotify == nil {
+			t.Skip("inotify is not available")
+		}
+
+		path := filepath.Join(t.TempDir(), "agent")
+		req.NoError(os.WriteFile(path, []byte("v1"), 0755))
+		stat, err := os.Stat(path)
+		req.NoError(err)
+
+		watcher.setPaths([]string{path})
+
+		hash, err := executableHash(path)
+		req.NoError(err)
+		_, _, cached := executableHashes.get(path, stat)
+		req.True(cached)
+
+		// same size and modification time, which only the watcher can tell apart
+		req.NoError(os.WriteFile(path, []byte("v2"), 0755))
+		req.NoError(os.Chtimes(path, stat.ModTime(), stat.ModTime()))
+		awaitReason(t, reasons, "executable changed: "+path)
+
+		changedHash, err := executableHash(path)
+		req.NoError(err)
+		req.NotEqual(hash, changedHash)
+
+		watcher.setPaths(nil)
+		_, _, cached = executableHashes.get(path, stat)
+		req.False(cached)
+	})
+
+	t.Run("process starts and exits are reported", func(t *testing.T) {
+		req := require.New(t)
+		watcher, reasons := newTestWatcher(t)
+		if !watcher.procConn {
+			t.Skip("the proc connector is not available to this process")
+		}
+
+		sleep, err := exec.LookPath("sleep")
+		req.NoError(err)
+		sleep, err = filepath.EvalSymlinks(sleep)
+		req.NoError(err)
+
+		watcher.setPaths([]string{sleep})
+
+		cmd := exec.Command(sleep, "30")
+		req.NoError(cmd.Start())
+		awaitReason(t, reasons, "process started: ")
+
+		req.NoError(cmd.Process.Kill())
+		_ = cmd.Wait()
+		awaitReason(t, reasons, "process exited: ")
+	})
+}
@@ -0,0 +1,202 @@
+//go:build !js
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/openziti/transport/v2/wss"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// NewProxiedAddress wraps an edge router address so that dialing it tunnels through the given proxy. TLS and WebSocket
+// (wss) router addresses are supported.
+func NewProxiedAddress(address transport.Address, proxy *ProxyConfiguration) (transport.Address, error) {
+	hostPortAddr, ok := address.(transport.HostPortAddress)
+	if !ok || (address.Type() != transporttls.Type && address.Type() != wss.Type) {
+		return nil, errors.Errorf("unable to use a proxy for address %s", address.String())
+	}
+
+	return &proxiedAddress{
+		HostPortAddress: hostPortAddr,
+		proxy:           proxy,
+	}, nil
+}
+
+type proxiedAddress struct {
+	transport.HostPortAddress
+	proxy *ProxyConfiguration
+}
+
+func (self *proxiedAddress) hostPort() string {
+	return net.JoinHostPort(self.Hostname(), fmt.Sprintf("%d", self.Port()))
+}
+
+func (self *proxiedAddress) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if self.Type() == wss.Type {
+		return self.dialWebSocket(ctx, name, i, timeout)
+	}
+	return self.dialTls(ctx, name, i, tcfg)
+}
+
+// DialWithLocalBinding ignores the local binding, as the connection is made by the proxy
+func (self *proxiedAddress) DialWithLocalBinding(name string, _ string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	return self.Dial(name, i, timeout, tcfg)
+}
+
+func (self *proxiedAddress) Listen(string, *identity.TokenId, func(transport.Conn), transport.Configuration) (io.Closer, error) {
+	return nil, errors.New("listening on a proxied address is not supported")
+}
+
+func (self *proxiedAddress) MustListen(name string, i *identity.TokenId, acceptF func(transport.Conn), tcfg transport.Configuration) io.Closer {
+	closer, err := self.Listen(name, i, acceptF, tcfg)
+	if err != nil {
+		panic(err)
+	}
+	return closer
+}
+
+func (self *proxiedAddress) dialTls(ctx context.Context, name string, i *identity.TokenId, tcfg transport.Configuration) (transport.Conn, error) {
+	conn, err := self.proxy.DialContext(ctx, self.hostPort())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := i.ClientTLSConfig().Clone()
+	tlsCfg.NextProtos = tcfg.Protocols()
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = self.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	detail := &transport.ConnectionDetail{
+		Address: transporttls.Type + ":" + self.hostPort(),
+		InBound: false,
+		Name:    name,
+	}
+	return transporttls.NewConnection(detail, tlsConn), nil
+}
+
+func (self *proxiedAddress) dialWebSocket(ctx context.Context, name string, i *identity.TokenId, timeout time.Duration) (transport.Conn, error) {
+	u := url.URL{Scheme: "wss", Host: self.hostPort(), Path: "/ws"}
+	tlsCfg := wss.ClientTLSConfig(u, i)
+
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return self.proxy.DialContext(ctx, addr)
+		},
+		TLSClientConfig:  tlsCfg,
+		HandshakeTimeout: timeout,
+	}
+
+	wsConn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// wss routers run TLS inside the websocket as well, which is what authenticates the identity
+	tlsConn := tls.Client(&webSocketConn{ws: wsConn}, tlsCfg)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = wsConn.Close()
+		return nil, err
+	}
+
+	detail := &transport.ConnectionDetail{
+		Address: wss.Type + ":" + self.hostPort(),
+		InBound: false,
+		Name:    name,
+	}
+	return transporttls.NewConnection(detail, tlsConn), nil
+}
+
+// webSocketConn adapts a websocket carrying binary messages to a net.Conn
+type webSocketConn struct {
+	ws        *websocket.Conn
+	leftover  []byte
+	writeLock sync.Mutex
+}
+
+func (self *webSocketConn) Read(b []byte) (int, error) {
+	for len(self.leftover) == 0 {
+		_, buf, err := self.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		self.leftover = buf
+	}
+
+	n := copy(b, self.leftover)
+	self.leftover = self.leftover[n:]
+	return n, nil
+}
+
+func (self *webSocketConn) Write(b []byte) (int, error) {
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+
+	if err := self.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (self *webSocketConn) Close() error {
+	return self.ws.Close()
+}
+
+func (self *webSocketConn) LocalAddr() net.Addr {
+	return self.ws.LocalAddr()
+}
+
+func (self *webSocketConn) RemoteAddr() net.Addr {
+	return self.ws.RemoteAddr()
+}
+
+func (self *webSocketConn) SetDeadline(t time.Time) error {
+	if err := self.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return self.ws.SetWriteDeadline(t)
+}
+
+func (self *webSocketConn) SetReadDeadline(t time.Time) error {
+	return self.ws.SetReadDeadline(t)
+}
+
+func (self *webSocketConn) SetWriteDeadline(t time.Time) error {
+	return self.ws.SetWriteDeadline(t)
+}
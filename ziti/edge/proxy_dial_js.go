@@ -0,0 +1,29 @@
+//go:build js
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+)
+
+// NewProxiedAddress is not supported in the browser, where proxies are configured by the browser itself
+func NewProxiedAddress(address transport.Address, _ *ProxyConfiguration) (transport.Address, error) {
+	return nil, errors.Errorf("unable to use a proxy for address %s", address.String())
+}
@@ -0,0 +1,66 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// AppDataTraceContextKey is the key under which trace context (e.g. W3C traceparent and tracestate) is carried in a
+// JSON object dial AppData. App data is forwarded to the hosting side by edge routers unchanged, so this works
+// without router support.
+const AppDataTraceContextKey = "trace_context"
+
+// InjectTraceContext adds the carrier to the given app data. Empty app data becomes a JSON object holding only the
+// trace context. App data which is not a JSON object can't carry trace context, and is returned unchanged with an
+// error.
+func InjectTraceContext(appData []byte, carrier map[string]string) ([]byte, error) {
+	if len(carrier) == 0 {
+		return appData, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if len(appData) > 0 {
+		if err := json.Unmarshal(appData, &fields); err != nil {
+			return appData, errors.Wrap(err, "app data is not a JSON object, unable to add trace context")
+		}
+	}
+
+	encoded, err := json.Marshal(carrier)
+	if err != nil {
+		return appData, err
+	}
+	fields[AppDataTraceContextKey] = encoded
+
+	return json.Marshal(fields)
+}
+
+// ExtractTraceContext returns the trace context carried in the given app data, or nil if there is none
+func ExtractTraceContext(appData []byte) map[string]string {
+	if len(appData) == 0 || appData[0] != '{' {
+		return nil
+	}
+
+	var fields struct {
+		TraceContext map[string]string `json:"trace_context"`
+	}
+	if err := json.Unmarshal(appData, &fields); err != nil {
+		return nil
+	}
+	return fields.TraceContext
+}
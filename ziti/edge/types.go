@@ -30,31 +30,7 @@ func (intercept *InterceptV1Config) Match(network, hostname string, port uint16)
 		return -1
 	}
 
-	var target any
-	ip := net.ParseIP(hostname)
-	if len(ip) != 0 {
-		target = ip
-	} else {
-		target = hostname
-	}
-
-	addrScore := -1
-	for _, address := range intercept.Addresses {
-		score := address.Matches(target)
-		if score == -1 {
-			continue
-		}
-
-		if score == 0 {
-			addrScore = 0
-			break
-		}
-
-		if addrScore == -1 || score < addrScore {
-			addrScore = score
-		}
-	}
-
+	addrScore := intercept.MatchAddress(hostname)
 	if addrScore == -1 {
 		return -1
 	}
@@ -82,6 +58,36 @@ func (intercept *InterceptV1Config) Match(network, hostname string, port uint16)
 	return int(uint(addrScore)<<16 | (uint(portScore) & 0xFFFF))
 }
 
+// MatchAddress returns the matching score of the given hostname or IP against the addresses of this intercept,
+// regardless of protocol and port. A negative one (-1) is returned if no address matches.
+func (intercept *InterceptV1Config) MatchAddress(hostname string) int {
+	var target any
+	ip := net.ParseIP(hostname)
+	if len(ip) != 0 {
+		target = ip
+	} else {
+		target = hostname
+	}
+
+	addrScore := -1
+	for _, address := range intercept.Addresses {
+		score := address.Matches(target)
+		if score == -1 {
+			continue
+		}
+
+		if score == 0 {
+			return 0
+		}
+
+		if addrScore == -1 || score < addrScore {
+			addrScore = score
+		}
+	}
+
+	return addrScore
+}
+
 type ZitiAddress struct {
 	cidr   *net.IPNet
 	ip     net.IP
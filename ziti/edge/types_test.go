@@ -0,0 +1,57 @@
+package edge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InterceptV1Config_Match(t *testing.T) {
+	tcp := []string{"tcp"}
+
+	t.Run("ipv6 addresses match in any notation", func(t *testing.T) {
+		req := require.New(t)
+		intercept := newTestIntercept("svc", tcp, []*PortRange{{Low: 443, High: 443}}, "fd00:7a69::10")
+
+		req.Equal(0, intercept.Match("tcp", "fd00:7a69::10", 443))
+		req.Equal(0, intercept.Match("tcp", "FD00:7A69:0:0:0:0:0:10", 443))
+		req.Equal(-1, intercept.Match("tcp", "fd00:7a69::11", 443))
+		req.Equal(-1, intercept.Match("tcp", "fd00:7a69::10", 80))
+		req.Equal(-1, intercept.Match("udp", "fd00:7a69::10", 443))
+	})
+
+	t.Run("ipv6 cidrs are scored by host bits", func(t *testing.T) {
+		req := require.New(t)
+		intercept := newTestIntercept("svc", tcp, []*PortRange{{Low: 0, High: 65535}}, "fd00:7a69::/64", "fd00:7a69::/112")
+
+		req.Equal(16<<16|65535, intercept.Match("tcp", "fd00:7a69::2", 80))
+		req.Equal(64<<16|65535, intercept.Match("tcp", "fd00:7a69::1:2", 80))
+		req.Equal(-1, intercept.Match("tcp", "fd00:7a6a::1", 80))
+		req.Equal(-1, intercept.Match("tcp", "10.0.0.1", 80))
+	})
+
+	t.Run("ipv4 addresses don't match ipv6 cidrs", func(t *testing.T) {
+		req := require.New(t)
+		intercept := newTestIntercept("svc", tcp, []*PortRange{{Low: 80, High: 80}}, "::/0")
+
+		req.Equal(128<<16, intercept.Match("tcp", "2001:db8::1", 80))
+		req.Equal(-1, intercept.Match("tcp", "192.168.1.1", 80))
+		req.Equal(-1, intercept.Match("tcp", "::ffff:192.168.1.1", 80))
+	})
+
+	t.Run("ipv4-mapped ipv6 addresses match ipv4 addresses and cidrs", func(t *testing.T) {
+		req := require.New(t)
+		intercept := newTestIntercept("svc", tcp, []*PortRange{{Low: 80, High: 80}}, "192.168.1.1", "10.0.0.0/8")
+
+		req.Equal(0, intercept.Match("tcp", "::ffff:192.168.1.1", 80))
+		req.Equal(24<<16, intercept.Match("tcp", "::ffff:10.1.2.3", 80))
+	})
+
+	t.Run("the narrowest port range is scored", func(t *testing.T) {
+		req := require.New(t)
+		intercept := newTestIntercept("svc", tcp, []*PortRange{{Low: 1, High: 1024}, {Low: 440, High: 450}}, "fd00::1")
+		req.Equal(10, intercept.Match("tcp", "fd00::1", 443))
+		req.Equal(1023, intercept.Match("tcp", "fd00::1", 80))
+		req.Equal(-1, intercept.Match("tcp", "fd00::1", 2000))
+	})
+}
@@ -18,8 +18,10 @@ package enroll
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -65,6 +67,10 @@ type EnrollmentFlags struct {
 	Username      string
 	Password      string
 	Verbose       bool
+
+	// Verification, when set, verifies the token offline against caller supplied trust instead of the certificate
+	// presented by the issuer, and only trusts the controller CAs fetched from a controller matching that trust
+	Verification *TokenVerification
 }
 
 func (enFlags *EnrollmentFlags) GetCertPool() (*x509.CertPool, []*x509.Certificate) {
@@ -83,6 +89,35 @@ func (enFlags *EnrollmentFlags) GetCertPool() (*x509.CertPool, []*x509.Certifica
 	return pool, certs
 }
 
+// verifyToken verifies the token with the configured verification, if any, and returns the TLS configuration used to
+// fetch the controller CAs
+func (enFlags *EnrollmentFlags) verifyToken() (*tls.Config, error) {
+	if enFlags.Verification == nil {
+		rootCaPool := x509.NewCertPool()
+		rootCaPool.AddCert(enFlags.Token.SignatureCert)
+		return &tls.Config{RootCAs: rootCaPool}, nil
+	}
+
+	tokenStr := enFlags.JwtString
+	if tokenStr == "" && enFlags.JwtToken != nil {
+		tokenStr = enFlags.JwtToken.Raw
+	}
+
+	if strings.TrimSpace(tokenStr) == "" {
+		return nil, errors.New("token verification requires the enrollment token string")
+	}
+
+	claims, jwtToken, err := ParseTokenWithVerification(tokenStr, enFlags.Verification)
+	if err != nil {
+		return nil, err
+	}
+
+	enFlags.Token = claims
+	enFlags.JwtToken = jwtToken
+
+	return enFlags.Verification.caFetchTlsConfig(claims), nil
+}
+
 func ParseToken(tokenStr string) (*ziti.EnrollmentClaims, *jwt.Token, error) {
 	parser := &jwt.Parser{
 		SkipClaimsValidation: false,
@@ -99,6 +134,12 @@ func ParseToken(tokenStr string) (*ziti.EnrollmentClaims, *jwt.Token, error) {
 }
 
 func ValidateToken(token *jwt.Token) (interface{}, error) {
+	return validateToken(token, FetchServerCert)
+}
+
+// validateToken checks the token claims and returns the public key of the certificate the token issuer presents,
+// as retrieved by fetchCert
+func validateToken(token *jwt.Token, fetchCert func(urlRoot string) (*x509.Certificate, error)) (interface{}, error) {
 	if token == nil {
 		return nil, errors.New("could not validate token, token is nil")
 	}
@@ -123,7 +164,7 @@ func ValidateToken(token *jwt.Token) (interface{}, error) {
 		return nil, errors.Errorf("could not validate token, issuer [%s] is not a valid url ", claims.Issuer)
 	}
 
-	cert, err := FetchServerCert(claims.Issuer)
+	cert, err := fetchCert(claims.Issuer)
 
 	claims.SignatureCert = cert
 
@@ -131,19 +172,28 @@ func ValidateToken(token *jwt.Token) (interface{}, error) {
 		return nil, errors.Errorf("could not retrieve token URL certificate: %s", err)
 	}
 
+	claims.SignatureKey = cert.PublicKey
 	return cert.PublicKey, nil
 }
 
 func EnrollUpdb(enFlags EnrollmentFlags) error {
+	fetchTlsConfig, err := enFlags.verifyToken()
+	if err != nil {
+		return err
+	}
+
 	caPool, allowedCerts := enFlags.GetCertPool()
 	ztApiRoot := enFlags.Token.Issuer
 
 	if err := enrollUpdb(enFlags.Username, enFlags.Password, enFlags.Token, caPool); err != nil {
 		pfxlog.Logger().Debug("fetching certificates from server")
-		rootCaPool := x509.NewCertPool()
-		rootCaPool.AddCert(enFlags.Token.SignatureCert)
 
-		for _, xcert := range FetchCertificates(ztApiRoot, rootCaPool) {
+		certs, fetchErr := fetchCertificates(context.Background(), ztApiRoot, newTlsClient(fetchTlsConfig))
+		if fetchErr != nil {
+			pfxlog.Logger().WithError(fetchErr).Debug("could not fetch certificates from server")
+		}
+
+		for _, xcert := range certs {
 			allowedCerts = append(allowedCerts, xcert)
 			caPool.AddCert(xcert)
 		}
@@ -160,7 +210,11 @@ func EnrollUpdb(enFlags EnrollmentFlags) error {
 
 func Enroll(enFlags EnrollmentFlags) (*ziti.Config, error) {
 	var key crypto.PrivateKey
-	var err error
+
+	fetchTlsConfig, err := enFlags.verifyToken()
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &ziti.Config{
 		ZtAPI: edge_apis.ClientUrl(enFlags.Token.Issuer),
@@ -195,6 +249,10 @@ func Enroll(enFlags EnrollmentFlags) (*ziti.Config, error) {
 			key, err = generateRSAKey()
 			asnBytes = x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey))
 			keyPem = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: asnBytes})
+		} else if enFlags.KeyAlg.Ed25519() {
+			key, err = generateEd25519Key()
+			asnBytes, _ = x509.MarshalPKCS8PrivateKey(key)
+			keyPem = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: asnBytes})
 		} else {
 			panic(fmt.Sprintf("invalid KeyAlg specified: %s", enFlags.KeyAlg.Get()))
 		}
@@ -213,12 +271,12 @@ func Enroll(enFlags EnrollmentFlags) (*ziti.Config, error) {
 
 	//fetch so CA bundles
 	pfxlog.Logger().Debug("fetching certificates from server")
-	serverOnlyCaPool := x509.NewCertPool()
-	serverOnlyCaPool.AddCert(enFlags.Token.SignatureCert)
-
-	controllerCas := FetchCertificates(cfg.ZtAPI, serverOnlyCaPool)
+	controllerCas, err := fetchCertificates(context.Background(), cfg.ZtAPI, newTlsClient(fetchTlsConfig))
 
 	if len(controllerCas) == 0 {
+		if err != nil {
+			return nil, errors.Wrap(err, "expected 1 or more CAs from controller, got 0")
+		}
 		return nil, errors.New("expected 1 or more CAs from controller, got 0")
 	}
 
@@ -266,6 +324,12 @@ func generateECKey() (crypto.PrivateKey, error) {
 	return ecdsa.GenerateKey(p384, rand.Reader)
 }
 
+func generateEd25519Key() (crypto.PrivateKey, error) {
+	pfxlog.Logger().Info("generating Ed25519 key")
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
 func generateRSAKey() (crypto.PrivateKey, error) {
 	bitSize := 4096
 	pfxlog.Logger().Infof("generating %d bit RSA key", bitSize)
@@ -533,11 +597,30 @@ func FetchServerCert(urlRoot string) (*x509.Certificate, error) {
 // FetchCertificates will access the server insecurely to pull down the latest CA to be used to communicate with the
 // server adding certificates to the provided pool
 func FetchCertificates(urlRoot string, rootCaPool *x509.CertPool) []*x509.Certificate {
+	certs, err := fetchCertificates(context.Background(), urlRoot, newTlsClient(&tls.Config{RootCAs: rootCaPool}))
+
+	if err != nil {
+		//@todo figure out what the impact is here of returning an error on other callers
+		pfxlog.Logger().WithError(err).Warnf("could not fetch certificates from %s", urlRoot)
+		return nil
+	}
+
+	return certs
+}
+
+func newTlsClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+func fetchCertificates(ctx context.Context, urlRoot string, httpClient *http.Client) ([]*x509.Certificate, error) {
 	ctrlUrl, err := url.Parse(urlRoot)
 
 	if err != nil {
-		pfxlog.Logger().Errorf("could not parse url root: %s", err)
-		return nil //@todo figure out what the impact is here of returning an error on other callers
+		return nil, errors.Wrap(err, "could not parse url root")
 	}
 
 	path := rest_client_api_client.DefaultBasePath
@@ -546,12 +629,6 @@ func FetchCertificates(urlRoot string, rootCaPool *x509.CertPool) []*x509.Certif
 		path = ctrlUrl.Path
 	}
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{RootCAs: rootCaPool},
-		},
-	}
-
 	clientRuntime := httptransport.NewWithClient(ctrlUrl.Host, path, rest_client_api_client.DefaultSchemes, httpClient)
 	clientRuntime.Consumers["application/pkcs7-mime"] = runtime.ConsumerFunc(func(reader io.Reader, i interface{}) error {
 		out := i.(*string)
@@ -568,26 +645,25 @@ func FetchCertificates(urlRoot string, rootCaPool *x509.CertPool) []*x509.Certif
 	})
 	client := rest_client_api_client.New(clientRuntime, nil)
 
-	resp, err := client.WellKnown.ListWellKnownCas(well_known.NewListWellKnownCasParams())
+	resp, err := client.WellKnown.ListWellKnownCas(well_known.NewListWellKnownCasParamsWithContext(ctx))
 
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	if resp.Payload == "" {
 		pfxlog.Logger().Debug("no certificates returned from well know ca store")
-		return nil
+		return nil, nil
 	}
 
 	pkcs7Certs, _ := base64.StdEncoding.DecodeString(string(resp.Payload))
 	if pkcs7Certs != nil {
 		certs, parseErr := pkcs7.Parse(pkcs7Certs)
 		if parseErr != nil {
-			pfxlog.Logger().Warnf("could not parse certificates. no certificates added from %s", urlRoot)
-			return nil
+			return nil, errors.Wrap(parseErr, "could not parse certificates")
 		}
-		return certs.Certificates
+		return certs.Certificates, nil
 	}
 
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,39 @@
+package enroll
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"testing"
+
+	"github.com/openziti/identity"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Enroll_Ed25519(t *testing.T) {
+	req := require.New(t)
+	controller := newTestController(t)
+
+	tokenStr := controller.token(MethodOtt)
+	claims, jwtToken, err := ParseToken(tokenStr)
+	req.NoError(err)
+
+	cfg, err := Enroll(EnrollmentFlags{
+		Token:     claims,
+		JwtToken:  jwtToken,
+		JwtString: tokenStr,
+		KeyAlg:    "ED25519",
+	})
+	req.NoError(err)
+
+	key, err := identity.LoadKey(cfg.ID.Key)
+	req.NoError(err)
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	req.True(ok)
+
+	certs, err := identity.LoadCert(cfg.ID.Cert)
+	req.NoError(err)
+	req.NotEmpty(certs)
+	req.Equal(x509.Ed25519, certs[0].PublicKeyAlgorithm)
+	req.True(edKey.Public().(ed25519.PublicKey).Equal(certs[0].PublicKey))
+}
@@ -0,0 +1,534 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package enroll
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/golang-jwt/jwt"
+	nfpem "github.com/openziti/foundation/v2/pem"
+	nfx509 "github.com/openziti/foundation/v2/x509"
+	"github.com/openziti/identity/certtools"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+const (
+	MethodOtt   = "ott"
+	MethodOttCa = "ottca"
+	MethodCa    = "ca"
+	MethodUpdb  = "updb"
+)
+
+// EnrolledIdentity is the result of an enrollment
+type EnrolledIdentity struct {
+	// Method is the enrollment method of the token
+	Method string
+
+	// Claims are the verified claims of the enrollment token
+	Claims *ziti.EnrollmentClaims
+
+	// Key is the private key of the identity, nil for UPDB enrollments
+	Key crypto.Signer
+
+	// Certs is the client certificate chain of the identity, leaf first. Nil for UPDB enrollments.
+	Certs []*x509.Certificate
+
+	// Cas are the CAs trusted for the controller, the configured CAs and the CAs fetched from the controller
+	Cas []*x509.Certificate
+
+	// Username is the username of UPDB enrollments
+	Username string
+}
+
+// EnrollerOption configures an Enroller
+type EnrollerOption func(enroller *Enroller)
+
+// WithSigner sets the private key of the identity. The key may be held outside the process, such as in a hardware
+// module, as only its signing operation is used. For OTT enrollment a key is generated if none is set. OTT-CA
+// and auto-CA enrollment require the signer of the certificates given with WithCertificates.
+func WithSigner(signer crypto.Signer) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.signer = signer
+	}
+}
+
+// WithKeyAlg sets the algorithm of the key generated for OTT enrollment when no signer is set. Defaults to EC.
+func WithKeyAlg(keyAlg ziti.KeyAlgVar) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.keyAlg = keyAlg
+	}
+}
+
+// WithCertificates sets the client certificate chain, leaf first, used for OTT-CA and auto-CA enrollment
+func WithCertificates(certs ...*x509.Certificate) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.certs = append(enroller.certs, certs...)
+	}
+}
+
+// WithCas adds CAs trusted for the controller, in addition to the CAs fetched from the controller
+func WithCas(cas ...*x509.Certificate) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.cas = append(enroller.cas, cas...)
+	}
+}
+
+// WithCaBundle adds the PEM encoded certificates of a CA bundle as trusted for the controller
+func WithCaBundle(pemBytes []byte) EnrollerOption {
+	return WithCas(nfpem.PemBytesToCertificates(pemBytes)...)
+}
+
+// WithHttpClient sets the client used for all requests. Its transport must be nil or an *http.Transport, which is
+// cloned with the TLS settings of each request, such as the trusted controller CAs and client certificates. Other
+// transports can't carry those settings, so enrolling with them fails.
+func WithHttpClient(client *http.Client) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.httpClient = client
+	}
+}
+
+// WithVerification verifies the token offline, see TokenVerification. Without it, the certificate the token issuer
+// presents on first contact is trusted.
+func WithVerification(verification *TokenVerification) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.verification = verification
+	}
+}
+
+// WithName sets the name of identities created by auto-CA enrollment
+func WithName(name string) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.name = name
+	}
+}
+
+// WithUpdb sets the username and password for UPDB enrollment. Both are required, as the returned config authenticates
+// with them.
+func WithUpdb(username, password string) EnrollerOption {
+	return func(enroller *Enroller) {
+		enroller.username = username
+		enroller.password = password
+	}
+}
+
+// Enroller enrolls identities without touching the file system. Keys, certificates and CAs are provided and returned
+// in memory.
+type Enroller struct {
+	jwt          string
+	signer       crypto.Signer
+	keyAlg       ziti.KeyAlgVar
+	certs        []*x509.Certificate
+	cas          []*x509.Certificate
+	httpClient   *http.Client
+	verification *TokenVerification
+	name         string
+	username     string
+	password     string
+}
+
+// NewEnroller creates an Enroller for the given enrollment token
+func NewEnroller(jwtStr string, options ...EnrollerOption) *Enroller {
+	enroller := &Enroller{
+		jwt: strings.TrimSpace(jwtStr),
+	}
+
+	for _, option := range options {
+		option(enroller)
+	}
+
+	return enroller
+}
+
+// Enroll verifies the token, fetches the controller CAs and enrolls with the method of the token. The returned config
+// holds Credentials for the enrolled identity. Its ID is populated as well if the key can be PEM encoded.
+func (self *Enroller) Enroll(ctx context.Context) (*ziti.Config, *EnrolledIdentity, error) {
+	claims, fetchTlsConfig, err := self.parseToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &EnrolledIdentity{
+		Method: claims.EnrollmentMethod,
+		Claims: claims,
+	}
+
+	result.Cas = append(result.Cas, self.cas...)
+
+	ztApi := edge_apis.ClientUrl(claims.Issuer)
+	fetchClient, err := self.client(fetchTlsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	controllerCas, err := fetchCertificates(ctx, ztApi, fetchClient)
+	if len(controllerCas) == 0 {
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "expected 1 or more CAs from controller, got 0")
+		}
+		return nil, nil, errors.New("expected 1 or more CAs from controller, got 0")
+	}
+	result.Cas = append(result.Cas, controllerCas...)
+
+	caPool := x509.NewCertPool()
+	for _, ca := range result.Cas {
+		caPool.AddCert(ca)
+	}
+
+	switch claims.EnrollmentMethod {
+	case MethodOtt:
+		err = self.enrollOtt(ctx, claims, caPool, result)
+	case MethodOttCa:
+		err = self.enrollCa(ctx, claims, caPool, nil, result)
+	case MethodCa:
+		var body []byte
+		if name := strings.TrimSpace(self.name); name != "" {
+			body, _ = json.Marshal(autoEnrollInput{Name: name})
+		}
+		err = self.enrollCa(ctx, claims, caPool, body, result)
+	case MethodUpdb:
+		err = self.enrollUpdb(ctx, claims, caPool, result)
+	default:
+		err = errors.Errorf("enrollment method '%s' is not supported", claims.EnrollmentMethod)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := self.config(result, ztApi, caPool)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, result, nil
+}
+
+// parseToken verifies the token and returns its claims and the TLS configuration trusted to fetch the controller CAs
+func (self *Enroller) parseToken() (*ziti.EnrollmentClaims, *tls.Config, error) {
+	if self.verification != nil {
+		claims, _, err := ParseTokenWithVerification(self.jwt, self.verification)
+		if err != nil {
+			return nil, nil, err
+		}
+		return claims, self.verification.caFetchTlsConfig(claims), nil
+	}
+
+	claims := &ziti.EnrollmentClaims{}
+	_, err := new(jwt.Parser).ParseWithClaims(self.jwt, claims, func(token *jwt.Token) (interface{}, error) {
+		return validateToken(token, self.fetchServerCert)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootCaPool := x509.NewCertPool()
+	rootCaPool.AddCert(claims.SignatureCert)
+	return claims, &tls.Config{RootCAs: rootCaPool}, nil
+}
+
+func (self *Enroller) fetchServerCert(urlRoot string) (*x509.Certificate, error) {
+	client, err := self.client(&tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(urlRoot)
+	if err != nil {
+		return nil, errors.Errorf("could not contact remote server [%s]: %s", urlRoot, err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("peer certificate information is missing")
+	}
+
+	return resp.TLS.PeerCertificates[0], nil
+}
+
+// client returns the configured http client, or a default one, with the given TLS settings applied to its transport.
+// The settings carry the trust established for the controller, so transports they can't be applied to are refused
+// rather than left to their own trust.
+func (self *Enroller) client(tlsConfig *tls.Config) (*http.Client, error) {
+	client := &http.Client{}
+	if self.httpClient != nil {
+		*client = *self.httpClient
+	}
+
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, errors.Errorf("unsupported http client transport %T, the transport must be nil or an *http.Transport", t)
+	}
+
+	merged := transport.TLSClientConfig.Clone()
+	if merged == nil {
+		merged = &tls.Config{}
+	}
+	merged.RootCAs = tlsConfig.RootCAs
+	merged.Certificates = tlsConfig.Certificates
+	merged.InsecureSkipVerify = tlsConfig.InsecureSkipVerify
+	merged.VerifyPeerCertificate = tlsConfig.VerifyPeerCertificate
+	merged.GetClientCertificate = nil
+
+	transport.TLSClientConfig = merged
+	client.Transport = transport
+	return client, nil
+}
+
+func (self *Enroller) post(ctx context.Context, claims *ziti.EnrollmentClaims, tlsConfig *tls.Config, contentType string, body []byte) ([]byte, *http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, claims.EnrolmentUrl(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	client, err := self.client(tlsConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, errors.Errorf("enroll error: %s: could not read body: %s", resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, enrollmentError(resp, respBody)
+	}
+
+	return respBody, resp, nil
+}
+
+func (self *Enroller) enrollOtt(ctx context.Context, claims *ziti.EnrollmentClaims, caPool *x509.CertPool, result *EnrolledIdentity) error {
+	signer := self.signer
+	if signer == nil {
+		key, err := generateKey(self.keyAlg)
+		if err != nil {
+			return err
+		}
+		signer = key.(crypto.Signer)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	request, err := certtools.NewCertRequest(map[string]string{
+		"C": "US", "O": "NetFoundry", "CN": hostname,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, request, signer)
+	if err != nil {
+		return err
+	}
+
+	csrPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+
+	body, resp, err := self.post(ctx, claims, &tls.Config{RootCAs: caPool}, "application/x-pem-file", csrPem)
+	if err != nil {
+		return err
+	}
+
+	certPem := body
+	if strings.HasPrefix(resp.Header.Get("content-type"), "application/json") {
+		container, err := gabs.ParseJSON(body)
+		if err != nil {
+			return errors.Errorf("could not parse json enrollment response: %v", err)
+		}
+
+		cert, ok := container.Path("data.cert").Data().(string)
+		if !ok {
+			return errors.New("could not find data.cert in enrollment response")
+		}
+		certPem = []byte(cert)
+	}
+
+	certs := nfpem.PemBytesToCertificates(certPem)
+	if len(certs) == 0 {
+		return errors.New("enrollment response did not contain a certificate")
+	}
+
+	result.Key = signer
+	result.Certs = certs
+	return nil
+}
+
+func (self *Enroller) enrollCa(ctx context.Context, claims *ziti.EnrollmentClaims, caPool *x509.CertPool, body []byte, result *EnrolledIdentity) error {
+	if self.signer == nil || len(self.certs) == 0 {
+		return errors.Errorf("enrollment method '%s' requires a signer and certificates", claims.EnrollmentMethod)
+	}
+
+	clientCert := tls.Certificate{
+		PrivateKey: self.signer,
+		Leaf:       self.certs[0],
+	}
+	for _, cert := range self.certs {
+		clientCert.Certificate = append(clientCert.Certificate, cert.Raw)
+	}
+
+	contentType := "text/plain"
+	if claims.EnrollmentMethod == MethodCa {
+		contentType = "application/json"
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:      caPool,
+		Certificates: []tls.Certificate{clientCert},
+	}
+
+	if _, _, err := self.post(ctx, claims, tlsConfig, contentType, body); err != nil {
+		return err
+	}
+
+	result.Key = self.signer
+	result.Certs = self.certs
+	return nil
+}
+
+func (self *Enroller) enrollUpdb(ctx context.Context, claims *ziti.EnrollmentClaims, caPool *x509.CertPool, result *EnrolledIdentity) error {
+	if self.username == "" || self.password == "" {
+		return errors.New("enrollment method 'updb' requires a username and password")
+	}
+
+	body := gabs.New()
+	_, _ = body.Set(self.password, "password")
+	_, _ = body.Set(self.username, "username")
+
+	if _, _, err := self.post(ctx, claims, &tls.Config{RootCAs: caPool}, "application/json", body.EncodeJSON()); err != nil {
+		return err
+	}
+
+	result.Username = self.username
+	return nil
+}
+
+// config builds the configuration of the enrolled identity
+func (self *Enroller) config(result *EnrolledIdentity, ztApi string, caPool *x509.CertPool) (*ziti.Config, error) {
+	cfg := &ziti.Config{
+		ZtAPI: ztApi,
+	}
+
+	if len(result.Cas) > 0 {
+		var buf bytes.Buffer
+		if err := nfx509.MarshalToPem(result.Cas, &buf); err != nil {
+			return nil, err
+		}
+		cfg.ID.CA = "pem:" + buf.String()
+	}
+
+	if result.Method == MethodUpdb {
+		credentials := edge_apis.NewUpdbCredentials(result.Username, self.password)
+		credentials.CaPool = caPool
+		cfg.Credentials = credentials
+		return cfg, nil
+	}
+
+	credentials := edge_apis.NewCertCredentials(result.Certs, result.Key)
+	credentials.CaPool = caPool
+	cfg.Credentials = credentials
+
+	if keyPem := marshalPrivateKey(result.Key); keyPem != nil {
+		var buf bytes.Buffer
+		if err := nfx509.MarshalToPem(result.Certs, &buf); err != nil {
+			return nil, err
+		}
+		cfg.ID.Key = "pem:" + string(keyPem)
+		cfg.ID.Cert = "pem:" + buf.String()
+	}
+
+	return cfg, nil
+}
+
+func generateKey(keyAlg ziti.KeyAlgVar) (crypto.PrivateKey, error) {
+	switch {
+	case keyAlg.RSA():
+		return generateRSAKey()
+	case keyAlg.Ed25519():
+		return generateEd25519Key()
+	default:
+		return generateECKey()
+	}
+}
+
+// marshalPrivateKey PEM encodes in process keys, it returns nil for keys held elsewhere
+func marshalPrivateKey(key crypto.Signer) []byte {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if der, err := x509.MarshalECPrivateKey(k); err == nil {
+			return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		}
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+	case ed25519.PrivateKey:
+		if der, err := x509.MarshalPKCS8PrivateKey(k); err == nil {
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		}
+	}
+	return nil
+}
+
+func enrollmentError(resp *http.Response, body []byte) error {
+	if resp.StatusCode == http.StatusConflict {
+		return errors.New("the provided identity has already been enrolled")
+	}
+
+	jsonBody, err := gabs.ParseJSON(body)
+	if err != nil || !jsonBody.Exists("error", "message") {
+		return errors.Errorf("enroll error: %s: %s", resp.Status, body)
+	}
+
+	message, _ := jsonBody.Search("error", "message").Data().(string)
+	code, _ := jsonBody.Search("error", "code").Data().(string)
+
+	cause, _ := jsonBody.Search("error", "cause", "message").Data().(string)
+	if cause == "" {
+		cause, _ = jsonBody.Search("error", "causeMessage").Data().(string)
+	}
+
+	return errors.Errorf("enroll error: %s - code: %s - message: %s - cause: %s", resp.Status, code, message, cause)
+}
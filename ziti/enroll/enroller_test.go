@@ -0,0 +1,357 @@
+package enroll
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/golang-jwt/jwt"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/stretchr/testify/require"
+)
+
+// testController stands in for the enrollment endpoints of a controller
+type testController struct {
+	t      *testing.T
+	server *httptest.Server
+
+	ca       *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	key      *ecdsa.PrivateKey
+	cert     *x509.Certificate
+	serial   int64
+	lock     sync.Mutex
+	requests []*enrollRequest
+}
+
+type enrollRequest struct {
+	method      string
+	token       string
+	contentType string
+	body        []byte
+	clientCerts []*x509.Certificate
+}
+
+func newTestController(t *testing.T) *testController {
+	controller := &testController{t: t, serial: 10}
+
+	var err error
+	controller.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	controller.ca = controller.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, &controller.caKey.PublicKey, nil)
+
+	controller.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	controller.cert = controller.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "controller"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, &controller.key.PublicKey, controller.ca)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/edge/client/v1/.well-known/est/cacerts", controller.handleCas)
+	mux.HandleFunc("/edge/client/v1/enroll", controller.handleEnroll)
+
+	controller.server = httptest.NewUnstartedServer(mux)
+	controller.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{controller.cert.Raw, controller.ca.Raw}, PrivateKey: controller.key}},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	controller.server.StartTLS()
+	t.Cleanup(controller.server.Close)
+
+	return controller
+}
+
+func (self *testController) issue(template *x509.Certificate, key crypto.PublicKey, parent *x509.Certificate) *x509.Certificate {
+	self.lock.Lock()
+	self.serial++
+	template.SerialNumber = big.NewInt(self.serial)
+	self.lock.Unlock()
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key, self.caKey)
+	require.NoError(self.t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(self.t, err)
+	return cert
+}
+
+func (self *testController) token(method string) string {
+	claims := newTestClaims(self.server.URL)
+	claims.EnrollmentMethod = method
+	return signTestToken(self.t, jwt.SigningMethodES256, self.key, "", claims)
+}
+
+func (self *testController) handleCas(w http.ResponseWriter, _ *http.Request) {
+	degenerate, err := pkcs7.DegenerateCertificate(self.ca.Raw)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkcs7-mime")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(degenerate)))
+}
+
+func (self *testController) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := &enrollRequest{
+		method:      r.URL.Query().Get("method"),
+		token:       r.URL.Query().Get("token"),
+		contentType: r.Header.Get("Content-Type"),
+		body:        body,
+		clientCerts: r.TLS.PeerCertificates,
+	}
+
+	self.lock.Lock()
+	self.requests = append(self.requests, request)
+	self.lock.Unlock()
+
+	switch request.method {
+	case MethodOtt:
+		block, _ := pem.Decode(body)
+		if block == nil {
+			self.writeError(w, http.StatusBadRequest, "INVALID_CSR")
+			return
+		}
+
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			self.writeError(w, http.StatusBadRequest, "INVALID_CSR")
+			return
+		}
+
+		cert := self.issue(&x509.Certificate{
+			Subject:     csr.Subject,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, csr.PublicKey, self.ca)
+
+		certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"cert": string(certPem)}})
+	case MethodOttCa, MethodCa:
+		if len(request.clientCerts) == 0 {
+			self.writeError(w, http.StatusBadRequest, "MISSING_CLIENT_CERT")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {}}`))
+	case MethodUpdb:
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {}}`))
+	default:
+		self.writeError(w, http.StatusBadRequest, "INVALID_METHOD")
+	}
+}
+
+func (self *testController) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"code": code, "message": "enrollment failed"}})
+}
+
+func (self *testController) lastRequest() *enrollRequest {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	require.NotEmpty(self.t, self.requests)
+	return self.requests[len(self.requests)-1]
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (self roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return self(request)
+}
+
+// testSigner hides the concrete key type, as an external signer would
+type testSigner struct {
+	*ecdsa.PrivateKey
+}
+
+func Test_Enroller(t *testing.T) {
+	controller := newTestController(t)
+
+	t.Run("ott generates a key and returns an in memory config", func(t *testing.T) {
+		req := require.New(t)
+
+		cfg, id, err := NewEnroller(controller.token(MethodOtt), WithHttpClient(controller.server.Client())).Enroll(context.Background())
+		req.NoError(err)
+
+		req.Equal(MethodOtt, id.Method)
+		req.NotNil(id.Key)
+		req.Len(id.Certs, 1)
+		req.True(publicKeysEqual(id.Key.Public(), id.Certs[0].PublicKey))
+		req.Len(id.Cas, 1)
+		req.Equal(controller.ca.Raw, id.Cas[0].Raw)
+
+		req.Equal(edge_apis.ClientUrl(controller.server.URL), cfg.ZtAPI)
+		req.Contains(cfg.ID.Key, "pem:")
+		req.Contains(cfg.ID.Cert, "pem:")
+		req.Contains(cfg.ID.CA, "pem:")
+		req.Equal("cert", cfg.Credentials.Method())
+		req.NotNil(cfg.Credentials.GetCaPool())
+
+		request := controller.lastRequest()
+		req.Equal("token-id", request.token)
+		req.Equal("application/x-pem-file", request.contentType)
+	})
+
+	t.Run("ott generates an Ed25519 key", func(t *testing.T) {
+		req := require.New(t)
+
+		cfg, id, err := NewEnroller(controller.token(MethodOtt), WithKeyAlg("ED25519")).Enroll(context.Background())
+		req.NoError(err)
+
+		_, ok := id.Key.(ed25519.PrivateKey)
+		req.True(ok)
+		req.Equal(x509.Ed25519, id.Certs[0].PublicKeyAlgorithm)
+		req.Contains(cfg.ID.Key, "PRIVATE KEY")
+	})
+
+	t.Run("ott uses an external signer", func(t *testing.T) {
+		req := require.New(t)
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		req.NoError(err)
+		signer := &testSigner{PrivateKey: key}
+
+		cfg, id, err := NewEnroller(controller.token(MethodOtt),
+			WithSigner(signer),
+			WithVerification(&TokenVerification{SigningCerts: []*x509.Certificate{controller.cert}}),
+		).Enroll(context.Background())
+		req.NoError(err)
+
+		req.Equal(signer, id.Key)
+		req.True(publicKeysEqual(key.Public(), id.Certs[0].PublicKey))
+		req.Empty(cfg.ID.Key)
+		req.Empty(cfg.ID.Cert)
+		req.Len(cfg.Credentials.TlsCerts(), 1)
+	})
+
+	t.Run("ottca and auto-ca present the client certificate", func(t *testing.T) {
+		req := require.New(t)
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		req.NoError(err)
+
+		cert := controller.issue(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "device"},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, &key.PublicKey, controller.ca)
+
+		_, id, err := NewEnroller(controller.token(MethodOttCa), WithSigner(key), WithCertificates(cert)).Enroll(context.Background())
+		req.NoError(err)
+		req.Equal([]*x509.Certificate{cert}, id.Certs)
+
+		request := controller.lastRequest()
+		req.Equal(MethodOttCa, request.method)
+		req.Len(request.clientCerts, 1)
+		req.Equal(cert.Raw, request.clientCerts[0].Raw)
+
+		_, _, err = NewEnroller(controller.token(MethodCa), WithSigner(key), WithCertificates(cert), WithName("device-1")).Enroll(context.Background())
+		req.NoError(err)
+
+		request = controller.lastRequest()
+		req.Equal(MethodCa, request.method)
+		req.JSONEq(`{"name": "device-1"}`, string(request.body))
+
+		_, _, err = NewEnroller(controller.token(MethodOttCa), WithSigner(key)).Enroll(context.Background())
+		req.ErrorContains(err, "requires a signer and certificates")
+	})
+
+	t.Run("updb", func(t *testing.T) {
+		req := require.New(t)
+
+		cfg, id, err := NewEnroller(controller.token(MethodUpdb), WithUpdb("user", "secret")).Enroll(context.Background())
+		req.NoError(err)
+		req.Nil(id.Key)
+		req.Equal("user", id.Username)
+		req.Equal("password", cfg.Credentials.Method())
+
+		request := controller.lastRequest()
+		req.JSONEq(`{"username": "user", "password": "secret"}`, string(request.body))
+
+		requests := len(controller.requests)
+
+		_, _, err = NewEnroller(controller.token(MethodUpdb)).Enroll(context.Background())
+		req.ErrorContains(err, "requires a username and password")
+
+		_, _, err = NewEnroller(controller.token(MethodUpdb), WithUpdb("", "secret")).Enroll(context.Background())
+		req.ErrorContains(err, "requires a username and password")
+
+		req.Len(controller.requests, requests)
+	})
+
+	t.Run("refuses transports which can't carry the controller trust", func(t *testing.T) {
+		req := require.New(t)
+
+		client := &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			return controller.server.Client().Transport.RoundTrip(request)
+		})}
+
+		_, _, err := NewEnroller(controller.token(MethodOtt),
+			WithHttpClient(client),
+			WithVerification(&TokenVerification{SigningCerts: []*x509.Certificate{controller.cert}}),
+		).Enroll(context.Background())
+		req.ErrorContains(err, "unsupported http client transport")
+	})
+
+	t.Run("fails when the controller does not match the verification", func(t *testing.T) {
+		req := require.New(t)
+
+		_, otherCert := newTestSigner(t)
+		_, _, err := NewEnroller(controller.token(MethodOtt),
+			WithVerification(&TokenVerification{SigningCerts: []*x509.Certificate{otherCert}}),
+		).Enroll(context.Background())
+		req.Error(err)
+	})
+
+	t.Run("rejects unsupported methods", func(t *testing.T) {
+		req := require.New(t)
+
+		_, _, err := NewEnroller(controller.token("unknown"), WithVerification(&TokenVerification{
+			SigningCerts:      []*x509.Certificate{controller.cert},
+			EnrollmentMethods: []string{"unknown"},
+		})).Enroll(context.Background())
+		req.ErrorContains(err, "enrollment method 'unknown' is not supported")
+	})
+}
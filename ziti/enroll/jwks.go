@@ -0,0 +1,204 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Jwk is a public key from a JSON web key set, as described in RFC 7517
+type Jwk struct {
+	KeyId        string
+	Algorithm    string
+	Use          string
+	Key          crypto.PublicKey
+	Certificates []*x509.Certificate
+}
+
+// Jwks is a JSON web key set. Only public signing keys are kept.
+type Jwks struct {
+	Keys []*Jwk
+}
+
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	Alg string   `json:"alg"`
+	Use string   `json:"use"`
+	Crv string   `json:"crv"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+// ParseJwks parses a JSON web key set. RSA, EC (P-256, P-384 and P-521) and OKP (Ed25519) keys are supported, keys of
+// other types or curves and keys not meant for signatures are skipped.
+func ParseJwks(data []byte) (*Jwks, error) {
+	set := &struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}{}
+
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, errors.Wrap(err, "could not parse JWKS")
+	}
+
+	result := &Jwks{}
+	for i, webKey := range set.Keys {
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+
+		key, err := webKey.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse JWKS key %d (kid: %s)", i, webKey.Kid)
+		}
+
+		if key == nil {
+			continue
+		}
+
+		jwk := &Jwk{
+			KeyId:     webKey.Kid,
+			Algorithm: webKey.Alg,
+			Use:       webKey.Use,
+			Key:       key,
+		}
+
+		for _, encoded := range webKey.X5c {
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not decode x5c of JWKS key %d (kid: %s)", i, webKey.Kid)
+			}
+
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not parse x5c of JWKS key %d (kid: %s)", i, webKey.Kid)
+			}
+			jwk.Certificates = append(jwk.Certificates, cert)
+		}
+
+		result.Keys = append(result.Keys, jwk)
+	}
+
+	if len(result.Keys) == 0 {
+		return nil, errors.New("JWKS does not contain any supported signing keys")
+	}
+
+	return result, nil
+}
+
+// keysFor returns the keys which may have signed a token with the given key id and algorithm
+func (self *Jwks) keysFor(kid, alg string) []*Jwk {
+	var result []*Jwk
+	for _, jwk := range self.Keys {
+		if kid != "" && jwk.KeyId != "" && jwk.KeyId != kid {
+			continue
+		}
+
+		if jwk.Algorithm != "" && jwk.Algorithm != alg {
+			continue
+		}
+
+		result = append(result, jwk)
+	}
+	return result
+}
+
+// publicKey decodes the key, returning nil if its type or curve isn't supported
+func (self *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch self.Kty {
+	case "RSA":
+		n, err := decodeBase64Url(self.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid modulus")
+		}
+
+		e, err := decodeBase64Url(self.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid exponent")
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch self.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+
+		x, err := decodeBase64Url(self.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid x coordinate")
+		}
+
+		y, err := decodeBase64Url(self.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid y coordinate")
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return key, nil
+	case "OKP":
+		if self.Crv != "Ed25519" {
+			return nil, nil
+		}
+
+		x, err := decodeBase64Url(self.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid public key")
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid Ed25519 public key length %d", len(x))
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func decodeBase64Url(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
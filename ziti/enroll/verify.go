@@ -0,0 +1,290 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// DefaultEnrollmentMethods are the enrollment methods accepted when TokenVerification.EnrollmentMethods is empty
+var DefaultEnrollmentMethods = []string{"ott", "ottca", "ca", "updb"}
+
+// TokenVerification verifies enrollment tokens against trust supplied by the caller instead of the certificate the
+// token issuer presents on first contact. At least one of SigningCerts, Jwks or CaPool must be set. Keys are tried in
+// that order.
+type TokenVerification struct {
+	// SigningCerts are certificates whose keys may have signed the token, usually the controller's server certificate
+	SigningCerts []*x509.Certificate
+
+	// Jwks holds keys which may have signed the token. Keys are matched by the kid header when present.
+	Jwks *Jwks
+
+	// CaPool verifies the certificate presented by the token issuer, whose key must have signed the token. This is the
+	// only option which contacts the issuer to verify the token.
+	CaPool *x509.CertPool
+
+	// EnrollmentMethods restricts the accepted enrollment methods, defaults to DefaultEnrollmentMethods
+	EnrollmentMethods []string
+
+	// Audience, when set, must be present in the aud claim
+	Audience string
+
+	// Issuer, when set, must match the iss claim
+	Issuer string
+
+	// AllowNoExpiry accepts tokens without an exp claim
+	AllowNoExpiry bool
+}
+
+type verificationKey struct {
+	key  crypto.PublicKey
+	cert *x509.Certificate
+}
+
+// ParseTokenWithVerification parses an enrollment token and verifies it with the given verification. The returned
+// claims have SignatureKey set to the key which verified the token, and SignatureCert set if that key came from a
+// certificate.
+func ParseTokenWithVerification(tokenStr string, verification *TokenVerification) (*ziti.EnrollmentClaims, *jwt.Token, error) {
+	if verification == nil {
+		return nil, nil, errors.New("could not verify token, no verification provided")
+	}
+
+	tokenStr = strings.TrimSpace(tokenStr)
+
+	unverifiedClaims := &ziti.EnrollmentClaims{}
+	unverified, _, err := new(jwt.Parser).ParseUnverified(tokenStr, unverifiedClaims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = verification.validateClaims(unverifiedClaims); err != nil {
+		return nil, nil, err
+	}
+
+	kid, _ := unverified.Header["kid"].(string)
+	alg := unverified.Method.Alg()
+
+	var candidates []*verificationKey
+	for _, cert := range verification.SigningCerts {
+		candidates = append(candidates, &verificationKey{key: cert.PublicKey, cert: cert})
+	}
+
+	if verification.Jwks != nil {
+		for _, jwk := range verification.Jwks.keysFor(kid, alg) {
+			candidate := &verificationKey{key: jwk.Key}
+			if len(jwk.Certificates) > 0 {
+				candidate.cert = jwk.Certificates[0]
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(verification.SigningCerts) == 0 && verification.Jwks == nil && verification.CaPool == nil {
+		return nil, nil, errors.New("could not verify token, no signing certificates, JWKS or CA pool provided")
+	}
+
+	var lastErr error
+	for _, candidate := range candidates {
+		claims, token, err := parseWithKey(tokenStr, candidate)
+		if err == nil {
+			return claims, token, nil
+		}
+		lastErr = err
+	}
+
+	if verification.CaPool != nil {
+		cert, err := fetchVerifiedServerCert(unverifiedClaims.Issuer, verification.CaPool)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not verify token issuer")
+		}
+
+		claims, token, err := parseWithKey(tokenStr, &verificationKey{key: cert.PublicKey, cert: cert})
+		if err == nil {
+			return claims, token, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return nil, nil, errors.Errorf("could not verify token, no trusted key matches key id [%s] and algorithm [%s]", kid, alg)
+	}
+
+	return nil, nil, errors.Wrap(lastErr, "could not verify token signature with any trusted key")
+}
+
+// parseWithKey verifies the token with the candidate key. Only the signing methods which fit the key are accepted, as
+// the algorithm in the token header is chosen by whoever made the token.
+func parseWithKey(tokenStr string, candidate *verificationKey) (*ziti.EnrollmentClaims, *jwt.Token, error) {
+	methods := signingMethodsFor(candidate.key)
+	if len(methods) == 0 {
+		return nil, nil, errors.Errorf("unsupported key type %T", candidate.key)
+	}
+
+	parser := &jwt.Parser{
+		ValidMethods: methods,
+	}
+
+	claims := &ziti.EnrollmentClaims{}
+	token, err := parser.ParseWithClaims(tokenStr, claims, func(*jwt.Token) (interface{}, error) {
+		return candidate.key, nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims.SignatureKey = candidate.key
+	claims.SignatureCert = candidate.cert
+	return claims, token, nil
+}
+
+// signingMethodsFor returns the signing methods which can be verified with the given key
+func signingMethodsFor(key crypto.PublicKey) []string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return []string{"ES256"}
+		case elliptic.P384():
+			return []string{"ES384"}
+		case elliptic.P521():
+			return []string{"ES512"}
+		}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
+
+func (self *TokenVerification) validateClaims(claims *ziti.EnrollmentClaims) error {
+	methods := self.EnrollmentMethods
+	if len(methods) == 0 {
+		methods = DefaultEnrollmentMethods
+	}
+
+	methodAllowed := false
+	for _, method := range methods {
+		if claims.EnrollmentMethod == method {
+			methodAllowed = true
+			break
+		}
+	}
+
+	if !methodAllowed {
+		return errors.Errorf("could not validate token, enrollment method [%s] is not allowed", claims.EnrollmentMethod)
+	}
+
+	if claims.ExpiresAt == 0 && !self.AllowNoExpiry {
+		return errors.New("could not validate token, token does not expire")
+	}
+
+	if err := claims.Valid(); err != nil {
+		return errors.Wrap(err, "could not validate token")
+	}
+
+	if self.Audience != "" && !claims.VerifyAudience(self.Audience, true) {
+		return errors.Errorf("could not validate token, audience [%s] does not match [%s]", claims.Audience, self.Audience)
+	}
+
+	if claims.Issuer == "" {
+		return errors.New("could not validate token, issuer is empty")
+	}
+
+	if _, err := url.Parse(claims.Issuer); err != nil {
+		return errors.Errorf("could not validate token, issuer [%s] is not a valid url", claims.Issuer)
+	}
+
+	if self.Issuer != "" && claims.Issuer != self.Issuer {
+		return errors.Errorf("could not validate token, issuer [%s] does not match [%s]", claims.Issuer, self.Issuer)
+	}
+
+	return nil
+}
+
+// caFetchTlsConfig returns the TLS configuration used to fetch the controller CAs for a verified token. The
+// controller is either verified with the CA pool, or must present a certificate for the key which signed the token.
+func (self *TokenVerification) caFetchTlsConfig(claims *ziti.EnrollmentClaims) *tls.Config {
+	if self.CaPool != nil {
+		return &tls.Config{RootCAs: self.CaPool}
+	}
+
+	signatureKey := claims.SignatureKey
+
+	return &tls.Config{
+		// the chain is not verified against a CA, instead the leaf key is pinned below. The handshake proves the
+		// server holds the private key of the leaf.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return errors.Wrap(err, "could not parse server certificate")
+			}
+
+			if !publicKeysEqual(leaf.PublicKey, signatureKey) {
+				return errors.New("server certificate key does not match the key which signed the enrollment token")
+			}
+
+			return nil
+		},
+	}
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	if key, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return key.Equal(b)
+	}
+	return false
+}
+
+func fetchVerifiedServerCert(urlRoot string, caPool *x509.CertPool) (*x509.Certificate, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: caPool},
+		},
+	}
+
+	resp, err := client.Get(urlRoot)
+	if err != nil {
+		return nil, errors.Errorf("could not contact remote server [%s]: %s", urlRoot, err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("peer certificate information is missing")
+	}
+
+	return resp.TLS.PeerCertificates[0], nil
+}
@@ -0,0 +1,301 @@
+package enroll
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "controller"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return key, cert
+}
+
+func newTestClaims(issuer string) *ziti.EnrollmentClaims {
+	return &ziti.EnrollmentClaims{
+		EnrollmentMethod: "ott",
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Id:        "token-id",
+			Issuer:    issuer,
+			Subject:   "identity-id",
+		},
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, key crypto.PrivateKey, kid string, claims *ziti.EnrollmentClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func Test_ParseTokenWithVerification(t *testing.T) {
+	key, cert := newTestSigner(t)
+	_, otherCert := newTestSigner(t)
+
+	t.Run("verifies with a pinned certificate", func(t *testing.T) {
+		req := require.New(t)
+		tokenStr := signTestToken(t, jwt.SigningMethodES256, key, "", newTestClaims("https://ctrl.example.com"))
+
+		claims, token, err := ParseTokenWithVerification(tokenStr, &TokenVerification{
+			SigningCerts: []*x509.Certificate{otherCert, cert},
+		})
+		req.NoError(err)
+		req.True(token.Valid)
+		req.Equal("ott", claims.EnrollmentMethod)
+		req.Equal("token-id", claims.Id)
+		req.Equal(cert, claims.SignatureCert)
+		req.True(publicKeysEqual(key.Public(), claims.SignatureKey))
+	})
+
+	t.Run("fails with a certificate which did not sign the token", func(t *testing.T) {
+		req := require.New(t)
+		tokenStr := signTestToken(t, jwt.SigningMethodES256, key, "", newTestClaims("https://ctrl.example.com"))
+
+		_, _, err := ParseTokenWithVerification(tokenStr, &TokenVerification{
+			SigningCerts: []*x509.Certificate{otherCert},
+		})
+		req.Error(err)
+	})
+
+	t.Run("fails without trust", func(t *testing.T) {
+		req := require.New(t)
+		tokenStr := signTestToken(t, jwt.SigningMethodES256, key, "", newTestClaims("https://ctrl.example.com"))
+
+		_, _, err := ParseTokenWithVerification(tokenStr, &TokenVerification{})
+		req.Error(err)
+	})
+
+	t.Run("verifies with an EC JWKS key matched by kid", func(t *testing.T) {
+		req := require.New(t)
+		tokenStr := signTestToken(t, jwt.SigningMethodES256, key, "key-2", newTestClaims("https://ctrl.example.com"))
+
+		otherKey := otherCert.PublicKey.(*ecdsa.PublicKey)
+		jwks, err := ParseJwks([]byte(fmt.Sprintf(`{"keys": [%s, %s]}`,
+			ecJwk("key-1", otherKey), ecJwk("key-2", &key.PublicKey))))
+		req.NoError(err)
+		req.Len(jwks.Keys, 2)
+
+		claims, _, err := ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.NoError(err)
+		req.Nil(claims.SignatureCert)
+		req.True(publicKeysEqual(key.Public(), claims.SignatureKey))
+
+		jwks, err = ParseJwks([]byte(fmt.Sprintf(`{"keys": [%s]}`, ecJwk("key-1", &key.PublicKey))))
+		req.NoError(err)
+
+		_, _, err = ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.Error(err)
+	})
+
+	t.Run("verifies with RSA and Ed25519 JWKS keys", func(t *testing.T) {
+		req := require.New(t)
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		req.NoError(err)
+
+		edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+		req.NoError(err)
+
+		jwks, err := ParseJwks([]byte(fmt.Sprintf(`{"keys": [
+			{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": "%s", "e": "%s"},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "%s"},
+			{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"},
+			{"kty": "EC", "kid": "k1", "crv": "secp256k1", "x": "AA", "y": "AA"},
+			{"kty": "OKP", "kid": "x", "crv": "X25519", "x": "AA"},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": "%s", "e": "%s"}
+		]}`, b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(edPublic),
+			b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()))))
+		req.NoError(err)
+		req.Len(jwks.Keys, 2)
+
+		tokenStr := signTestToken(t, jwt.SigningMethodRS256, rsaKey, "rsa", newTestClaims("https://ctrl.example.com"))
+		claims, _, err := ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.NoError(err)
+		req.True(publicKeysEqual(rsaKey.Public(), claims.SignatureKey))
+
+		tokenStr = signTestToken(t, jwt.SigningMethodEdDSA, edPrivate, "ed", newTestClaims("https://ctrl.example.com"))
+		claims, _, err = ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.NoError(err)
+		req.True(publicKeysEqual(edPublic, claims.SignatureKey))
+	})
+
+	t.Run("only accepts signing methods which fit the key", func(t *testing.T) {
+		req := require.New(t)
+		verification := &TokenVerification{SigningCerts: []*x509.Certificate{cert}}
+
+		tokenStr := signTestToken(t, jwt.SigningMethodHS256, []byte("secret"), "", newTestClaims("https://ctrl.example.com"))
+		_, _, err := ParseTokenWithVerification(tokenStr, verification)
+		req.ErrorContains(err, "signing method HS256 is invalid")
+
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		req.NoError(err)
+		tokenStr = signTestToken(t, jwt.SigningMethodES384, p384Key, "", newTestClaims("https://ctrl.example.com"))
+		_, _, err = ParseTokenWithVerification(tokenStr, verification)
+		req.ErrorContains(err, "signing method ES384 is invalid")
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		req.NoError(err)
+		jwks, err := ParseJwks([]byte(fmt.Sprintf(`{"keys": [{"kty": "RSA", "n": "%s", "e": "%s"}]}`,
+			b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()))))
+		req.NoError(err)
+
+		tokenStr = signTestToken(t, jwt.SigningMethodPS256, rsaKey, "", newTestClaims("https://ctrl.example.com"))
+		_, _, err = ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.NoError(err)
+
+		tokenStr = signTestToken(t, jwt.SigningMethodES256, key, "", newTestClaims("https://ctrl.example.com"))
+		_, _, err = ParseTokenWithVerification(tokenStr, &TokenVerification{Jwks: jwks})
+		req.ErrorContains(err, "signing method ES256 is invalid")
+	})
+
+	t.Run("validates claims", func(t *testing.T) {
+		verification := &TokenVerification{SigningCerts: []*x509.Certificate{cert}}
+
+		parse := func(claims *ziti.EnrollmentClaims, verification *TokenVerification) error {
+			_, _, err := ParseTokenWithVerification(signTestToken(t, jwt.SigningMethodES256, key, "", claims), verification)
+			return err
+		}
+
+		req := require.New(t)
+
+		claims := newTestClaims("https://ctrl.example.com")
+		claims.EnrollmentMethod = "unknown"
+		req.ErrorContains(parse(claims, verification), "enrollment method [unknown] is not allowed")
+
+		claims = newTestClaims("https://ctrl.example.com")
+		claims.EnrollmentMethod = "updb"
+		req.ErrorContains(parse(claims, &TokenVerification{
+			SigningCerts:      []*x509.Certificate{cert},
+			EnrollmentMethods: []string{"ott"},
+		}), "enrollment method [updb] is not allowed")
+
+		claims = newTestClaims("https://ctrl.example.com")
+		claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		req.ErrorContains(parse(claims, verification), "expired")
+
+		claims = newTestClaims("https://ctrl.example.com")
+		claims.ExpiresAt = 0
+		req.ErrorContains(parse(claims, verification), "does not expire")
+		req.NoError(parse(claims, &TokenVerification{SigningCerts: []*x509.Certificate{cert}, AllowNoExpiry: true}))
+
+		claims = newTestClaims("https://ctrl.example.com")
+		claims.Audience = "ziti"
+		req.ErrorContains(parse(claims, &TokenVerification{SigningCerts: []*x509.Certificate{cert}, Audience: "other"}), "audience")
+		req.NoError(parse(claims, &TokenVerification{SigningCerts: []*x509.Certificate{cert}, Audience: "ziti"}))
+
+		claims = newTestClaims("https://evil.example.com")
+		req.ErrorContains(parse(claims, &TokenVerification{
+			SigningCerts: []*x509.Certificate{cert},
+			Issuer:       "https://ctrl.example.com",
+		}), "issuer")
+	})
+}
+
+func Test_TokenVerification_Issuer(t *testing.T) {
+	key, cert := newTestSigner(t)
+	otherKey, otherCert := newTestSigner(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	t.Run("verifies with the certificate of an issuer trusted by the CA pool", func(t *testing.T) {
+		req := require.New(t)
+		tokenStr := signTestToken(t, jwt.SigningMethodES256, key, "", newTestClaims(server.URL))
+
+		caPool := x509.NewCertPool()
+		caPool.AddCert(cert)
+
+		claims, _, err := ParseTokenWithVerification(tokenStr, &TokenVerification{CaPool: caPool})
+		req.NoError(err)
+		req.Equal(cert.Raw, claims.SignatureCert.Raw)
+
+		otherPool := x509.NewCertPool()
+		otherPool.AddCert(otherCert)
+
+		_, _, err = ParseTokenWithVerification(tokenStr, &TokenVerification{CaPool: otherPool})
+		req.Error(err)
+	})
+
+	t.Run("only trusts a controller presenting the signing key", func(t *testing.T) {
+		req := require.New(t)
+		verification := &TokenVerification{SigningCerts: []*x509.Certificate{cert, otherCert}}
+
+		get := func(signer crypto.PrivateKey) error {
+			tokenStr := signTestToken(t, jwt.SigningMethodES256, signer, "", newTestClaims(server.URL))
+			claims, _, err := ParseTokenWithVerification(tokenStr, verification)
+			req.NoError(err)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: verification.caFetchTlsConfig(claims)}}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			return err
+		}
+
+		req.NoError(get(key))
+		req.Error(get(otherKey))
+	})
+}
+
+func ecJwk(kid string, key *ecdsa.PublicKey) string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return fmt.Sprintf(`{"kty": "EC", "kid": "%s", "use": "sig", "crv": "P-256", "x": "%s", "y": "%s"}`,
+		kid, b64(key.X.FillBytes(make([]byte, size))), b64(key.Y.FillBytes(make([]byte, size))))
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
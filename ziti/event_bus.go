@@ -0,0 +1,330 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	ctx "context"
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultEventBufferSize = 64
+
+// Event is implemented by the typed events delivered to Eventer.Subscribe channels. Use a type switch to handle
+// specific events.
+type Event interface {
+	// EventName returns the name of the equivalent untyped event, such as EventServiceAdded
+	EventName() events.EventName
+
+	listenerArgs() []interface{}
+}
+
+// EventFilter selects the events delivered to a subscription
+type EventFilter func(Event) bool
+
+// EventNames returns a filter which selects events with any of the given names
+func EventNames(names ...events.EventName) EventFilter {
+	return func(event Event) bool {
+		for _, name := range names {
+			if event.EventName() == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type ServiceAddedEvent struct {
+	Service *rest_model.ServiceDetail
+}
+
+func (self *ServiceAddedEvent) EventName() events.EventName {
+	return EventServiceAdded
+}
+
+func (self *ServiceAddedEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Service}
+}
+
+// ServiceChangedEvent is delivered when a service definition differs from the previous refresh. Diff describes what
+// changed.
+type ServiceChangedEvent struct {
+	Service *rest_model.ServiceDetail
+	Diff    *ServiceDiff
+}
+
+func (self *ServiceChangedEvent) EventName() events.EventName {
+	return EventServiceChanged
+}
+
+func (self *ServiceChangedEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Service}
+}
+
+type ServiceRemovedEvent struct {
+	Service *rest_model.ServiceDetail
+}
+
+func (self *ServiceRemovedEvent) EventName() events.EventName {
+	return EventServiceRemoved
+}
+
+func (self *ServiceRemovedEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Service}
+}
+
+// ServiceConfigInvalidEvent is delivered when a config of a type registered with RegisterConfigType fails schema
+// validation or parsing
+type ServiceConfigInvalidEvent struct {
+	Service    *rest_model.ServiceDetail
+	ConfigType string
+	Err        error
+}
+
+func (self *ServiceConfigInvalidEvent) EventName() events.EventName {
+	return EventServiceConfigInvalid
+}
+
+func (self *ServiceConfigInvalidEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Service, self.ConfigType, self.Err}
+}
+
+type RouterConnectedEvent struct {
+	Name    string
+	Address string
+}
+
+func (self *RouterConnectedEvent) EventName() events.EventName {
+	return EventRouterConnected
+}
+
+func (self *RouterConnectedEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Name, self.Address}
+}
+
+type RouterDisconnectedEvent struct {
+	Name    string
+	Address string
+}
+
+func (self *RouterDisconnectedEvent) EventName() events.EventName {
+	return EventRouterDisconnected
+}
+
+func (self *RouterDisconnectedEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Name, self.Address}
+}
+
+type AuthState string
+
+const (
+	AuthStateFull            AuthState = "full"
+	AuthStatePartial         AuthState = "partial"
+	AuthStateUnauthenticated AuthState = "unauthenticated"
+)
+
+// AuthStateEvent is delivered when the authentication state of the context changes. For AuthStateUnauthenticated,
+// ApiSession is the API session which is no longer valid.
+type AuthStateEvent struct {
+	State      AuthState
+	ApiSession *rest_model.CurrentAPISessionDetail
+}
+
+func (self *AuthStateEvent) EventName() events.EventName {
+	switch self.State {
+	case AuthStateFull:
+		return EventAuthenticationStateFull
+	case AuthStatePartial:
+		return EventAuthenticationStatePartial
+	default:
+		return EventAuthenticationStateUnauthenticated
+	}
+}
+
+func (self *AuthStateEvent) listenerArgs() []interface{} {
+	return []interface{}{self.ApiSession}
+}
+
+type AuthQueryEvent struct {
+	Query *rest_model.AuthQueryDetail
+}
+
+func (self *AuthQueryEvent) EventName() events.EventName {
+	return EventAuthQuery
+}
+
+func (self *AuthQueryEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Query}
+}
+
+// MfaTotpCodeEvent is delivered when authentication, or the MFA posture checks of a service, require an MFA TOTP
+// code. Call Response with the code to answer the query.
+type MfaTotpCodeEvent struct {
+	Query    *rest_model.AuthQueryDetail
+	Response MfaCodeResponse
+}
+
+func (self *MfaTotpCodeEvent) EventName() events.EventName {
+	return EventMfaTotpCode
+}
+
+func (self *MfaTotpCodeEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Query, self.Response}
+}
+
+type ConnectionExpiredEvent struct {
+	Conn   *edge.ConnInfo
+	Reason error
+}
+
+func (self *ConnectionExpiredEvent) EventName() events.EventName {
+	return EventConnectionExpired
+}
+
+func (self *ConnectionExpiredEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Conn, self.Reason}
+}
+
+type PinViolationEvent struct {
+	Violation *PinViolation
+}
+
+func (self *PinViolationEvent) EventName() events.EventName {
+	return EventPinViolation
+}
+
+func (self *PinViolationEvent) listenerArgs() []interface{} {
+	return []interface{}{self.Violation}
+}
+
+// eventBus delivers typed events to subscribers without ever blocking the publisher. Events which don't fit in a
+// subscriber's buffer are dropped and counted.
+type eventBus struct {
+	lock        sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+	closed      bool
+	closeNotify chan struct{}
+	dropped     atomic.Uint64
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	c      chan Event
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: map[*eventSubscriber]struct{}{},
+		closeNotify: make(chan struct{}),
+	}
+}
+
+func (self *eventBus) subscribe(done <-chan struct{}, filter EventFilter, bufferSize int) <-chan Event {
+	subscriber := &eventSubscriber{
+		filter: filter,
+		c:      make(chan Event, bufferSize),
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		close(subscriber.c)
+		return subscriber.c
+	}
+
+	self.subscribers[subscriber] = struct{}{}
+
+	go func() {
+		select {
+		case <-done:
+			self.unsubscribe(subscriber)
+		case <-self.closeNotify:
+		}
+	}()
+
+	return subscriber.c
+}
+
+func (self *eventBus) unsubscribe(subscriber *eventSubscriber) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if _, found := self.subscribers[subscriber]; found {
+		delete(self.subscribers, subscriber)
+		close(subscriber.c)
+	}
+}
+
+func (self *eventBus) publish(event Event) {
+	if self == nil {
+		return
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for subscriber := range self.subscribers {
+		if subscriber.filter != nil && !subscriber.filter(event) {
+			continue
+		}
+
+		select {
+		case subscriber.c <- event:
+		default:
+			self.dropped.Add(1)
+		}
+	}
+}
+
+func (self *eventBus) close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return
+	}
+
+	self.closed = true
+	close(self.closeNotify)
+	for subscriber := range self.subscribers {
+		close(subscriber.c)
+	}
+	self.subscribers = map[*eventSubscriber]struct{}{}
+}
+
+// Subscribe returns a channel which receives the events selected by filter, or all events if filter is nil. The
+// channel is closed when ctx is done or the context is closed. Delivery never blocks the context: events which
+// arrive while the channel's buffer of Options.EventBufferSize events is full are dropped and counted by
+// DroppedEvents.
+func (context *ContextImpl) Subscribe(ctx ctx.Context, filter EventFilter) <-chan Event {
+	return context.eventBus.subscribe(ctx.Done(), filter, context.options.getEventBufferSize())
+}
+
+// DroppedEvents returns the number of events which subscribers have missed because their buffers were full
+func (context *ContextImpl) DroppedEvents() uint64 {
+	return context.eventBus.dropped.Load()
+}
+
+// publish notifies both untyped listeners and subscribers of an event
+func (context *ContextImpl) publish(event Event) {
+	context.Emit(event.EventName(), event.listenerArgs()...)
+	context.eventBus.publish(event)
+}
@@ -0,0 +1,70 @@
+package ziti
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_eventBus_subscribe(t *testing.T) {
+	req := require.New(t)
+
+	ztx := &ContextImpl{
+		options:      &Options{EventBufferSize: 2},
+		eventBus:     newEventBus(),
+		EventEmmiter: events.New(),
+	}
+
+	var listenerCalls int
+	ztx.AddRouterConnectedListener(func(Context, string, string) {
+		listenerCalls++
+	})
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	routerEvents := ztx.Subscribe(subCtx, EventNames(EventRouterConnected, EventRouterDisconnected))
+	allEvents := ztx.Subscribe(context.Background(), nil)
+
+	ztx.publish(&ServiceAddedEvent{Service: &rest_model.ServiceDetail{Name: ToPtr("svc")}})
+	ztx.publish(&RouterConnectedEvent{Name: "er1", Address: "tls:er1:3022"})
+
+	event := <-routerEvents
+	req.Equal(&RouterConnectedEvent{Name: "er1", Address: "tls:er1:3022"}, event)
+	req.Equal(1, listenerCalls)
+
+	_, ok := (<-allEvents).(*ServiceAddedEvent)
+	req.True(ok)
+	_, ok = (<-allEvents).(*RouterConnectedEvent)
+	req.True(ok)
+
+	// publishing never blocks, events beyond the buffer size are dropped
+	for i := 0; i < 3; i++ {
+		ztx.publish(&RouterDisconnectedEvent{Name: "er1", Address: "tls:er1:3022"})
+	}
+	req.Equal(uint64(2), ztx.DroppedEvents())
+
+	cancel()
+	req.Eventually(func() bool {
+		for {
+			select {
+			case _, ok := <-routerEvents:
+				if !ok {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	ztx.eventBus.close()
+	_, ok = <-allEvents
+	req.True(ok) // buffered events are still delivered after close
+	_, ok = <-allEvents
+	req.True(ok)
+	_, ok = <-allEvents
+	req.False(ok)
+}
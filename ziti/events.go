@@ -17,8 +17,10 @@
 package ziti
 
 import (
+	"context"
 	"github.com/kataras/go-events"
 	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
 )
 
 const (
@@ -29,7 +31,8 @@ const (
 	// 2) serviceDetail`*rest_model.ServiceDetail` - The full detail record of the service
 	EventServiceAdded = events.EventName("service-new")
 
-	// EventServiceChanged is emitted when an existing service undergoes a change in its definition.
+	// EventServiceChanged is emitted when an existing service undergoes a change in its definition. Subscribe to
+	// ServiceChangedEvent to receive a description of what changed.
 	//
 	//Arguments:
 	// 1) Context - the context that triggered the listener
@@ -43,6 +46,16 @@ const (
 	// 2) serviceDetail`*rest_model.ServiceDetail` - The full detail record of the service
 	EventServiceRemoved = events.EventName("service-removed")
 
+	// EventServiceConfigInvalid is emitted when a service has a config of a type registered with RegisterConfigType
+	// which doesn't match the registered schema or can't be parsed. The config is not available from ServiceConfig.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) serviceDetail `*rest_model.ServiceDetail` - The full detail record of the service
+	// 3) configType `string` - the name of the invalid config's type
+	// 4) err `error` - the validation or parsing error
+	EventServiceConfigInvalid = events.EventName("service-config-invalid")
+
 	// EventRouterConnected is emitted when a connection to an Edge Router is established.
 	//
 	// Arguments:
@@ -59,7 +72,9 @@ const (
 	// 3) routerKey `string` - A string that uniquely identifies a router connection
 	EventRouterDisconnected = events.EventName("router-disconnected")
 
-	// EventMfaTotpCode is emitted when a Ziti context requires an MFA TOTP code to proceed with authentication.
+	// EventMfaTotpCode is emitted when a Ziti context requires an MFA TOTP code to proceed with authentication. It is
+	// also emitted ahead of MFA posture checks of dialed or bound services timing out, and when they fail, such as
+	// after the endpoint wakes or is unlocked, in which case the code satisfies the posture checks.
 	//
 	// Arguments:
 	// 1) Context - the context that triggered the listener
@@ -68,7 +83,8 @@ const (
 	EventMfaTotpCode = events.EventName("mfa-totp-code")
 
 	// EventAuthQuery is emitted when a Ziti context requires an answer to an authentication query. MFA TOTP is
-	// modeled as an authentication query as well and will also trigger the event EventMfaTotpCode.
+	// modeled as an authentication query as well and will also trigger the event EventMfaTotpCode. Queries are
+	// answered by the handlers registered with Context.RegisterAuthQueryHandler.
 	//
 	// Arguments:
 	// 1) Context - the context that triggered the listener
@@ -100,6 +116,24 @@ const (
 	// 1) Context - the context that triggered the listener
 	// 2) apiSession *rest_model.CurrentApiSessionDetail - details of the invalid API Session
 	EventAuthenticationStateUnauthenticated = events.EventName("auth-state-unauthenticated")
+
+	// EventConnectionExpired is emitted when a dialed or accepted connection is closed because it was idle for
+	// longer than its idle timeout or stopped answering keepalive probes.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) conn *edge.ConnInfo - details of the connection at the time it was closed
+	// 3) reason error - edge.ErrConnIdleTimeout or edge.ErrConnKeepAliveFailed
+	EventConnectionExpired = events.EventName("connection-expired")
+
+	// EventPinViolation is emitted when the controller or an edge router presents a certificate chain which matches
+	// none of the pins configured in Config.ControllerPins or Config.RouterPins. Unless the pin set is report only,
+	// the connection is refused.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) violation *PinViolation - details of the peer and the certificates it presented
+	EventPinViolation = events.EventName("pin-violation")
 )
 
 // Eventer provides types methods for adding event listeners to a context and exposes some weakly typed functions
@@ -120,6 +154,11 @@ type Eventer interface {
 	// provided is the service that was removed.
 	AddServiceRemovedListener(func(Context, *rest_model.ServiceDetail)) func()
 
+	// AddServiceConfigInvalidListener adds an event listener for the EventServiceConfigInvalid event and returns a
+	// function to remove the listener. It is emitted any time a service has a config of a registered type which fails
+	// schema validation or parsing. The service, the config type and the error are provided.
+	AddServiceConfigInvalidListener(func(ztx Context, service *rest_model.ServiceDetail, configType string, err error)) func()
+
 	// AddRouterConnectedListener adds an event listener for the EventRouterConnected event and returns a function to remove
 	// the listener. It is emitted any time a router connection is established. The strings provided are router name and connection address.
 	AddRouterConnectedListener(func(ztx Context, name string, addr string)) func()
@@ -128,6 +167,24 @@ type Eventer interface {
 	// the listener. It is emitted any time a router connection is closed. The strings provided are router name and connection address.
 	AddRouterDisconnectedListener(func(ztx Context, name string, addr string)) func()
 
+	// AddConnectionExpiredListener adds an event listener for the EventConnectionExpired event and returns a function
+	// to remove the listener. It is emitted any time a connection with liveness options is closed for being idle or
+	// failing keepalive probes. The connection details and the reason it was closed are provided.
+	AddConnectionExpiredListener(func(ztx Context, conn *edge.ConnInfo, reason error)) func()
+
+	// AddPinViolationListener adds an event listener for the EventPinViolation event and returns a function to remove
+	// the listener. It is emitted any time the controller or an edge router presents certificates which don't match
+	// the configured pins, including in report only mode.
+	AddPinViolationListener(func(ztx Context, violation *PinViolation)) func()
+
+	// Subscribe returns a channel of typed events, such as *ServiceAddedEvent or *AuthStateEvent, selected by filter.
+	// All events are delivered if filter is nil. The channel is closed when ctx is done or the context is closed.
+	// Events are buffered up to Options.EventBufferSize, after which they are dropped rather than delay the context.
+	Subscribe(ctx context.Context, filter EventFilter) <-chan Event
+
+	// DroppedEvents returns the number of events dropped because a Subscribe channel's buffer was full.
+	DroppedEvents() uint64
+
 	// AddMfaTotpCodeListener adds an event listener for the EventMfaTotpCode event and returns a function to remove
 	// the listener. It is emitted any time the currently authenticated API Session requires an MFA TOTP Code for
 	// authentication. The authentication query detail and an MfaCodeResponse function are provided. The MfaCodeResponse
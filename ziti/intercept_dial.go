@@ -0,0 +1,137 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"encoding/json"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultInterceptConnectTimeout = 5 * time.Second
+
+// interceptTemplateVariables are the variables which may appear in the identity of the dial options and the source
+// ip of an intercept.v1 config, as interpreted by tunnelers. The SDK has no source address for connections dialed with
+// DialAddr, so the $src_* variables are never available.
+var interceptTemplateVariables = []string{
+	"$dst_protocol", "$dst_hostname", "$dst_ip", "$dst_port",
+	"$src_protocol", "$src_ip", "$src_port",
+	"$tunneler_id.name",
+}
+
+func init() {
+	// replace longer variables first, so that no variable is replaced within another one
+	sort.SliceStable(interceptTemplateVariables, func(i, j int) bool {
+		return len(interceptTemplateVariables[i]) > len(interceptTemplateVariables[j])
+	})
+}
+
+// interceptDialOptions returns the options for dialing an intercepted address, applying the connect timeout, the
+// templated identity and the templated source ip of the intercept, like tunnelers do. The intercept may be nil. ip is
+// the IP address which was dialed, if any. When it is a virtual IP, host is the hostname it was handed out for, so
+// both $dst_ip and $dst_hostname are available, as they are for tunnelers intercepting DNS resolved connections.
+func interceptDialOptions(intercept *edge.InterceptV1Config, identityName, network, host, ip string, port uint16) (*DialOptions, error) {
+	vars := map[string]string{
+		"$dst_protocol": network,
+		"$dst_port":     strconv.Itoa(int(port)),
+	}
+
+	if identityName != "" {
+		vars["$tunneler_id.name"] = identityName
+	}
+
+	appdata := make(map[string]any)
+	appdata["dst_protocol"] = network
+	appdata["dst_port"] = vars["$dst_port"]
+	if ip != "" {
+		appdata["dst_ip"] = ip
+		vars["$dst_ip"] = ip
+	}
+	if net.ParseIP(host) == nil {
+		appdata["dst_hostname"] = host
+		vars["$dst_hostname"] = host
+	}
+
+	options := &DialOptions{
+		ConnectTimeout: DefaultInterceptConnectTimeout,
+	}
+
+	if intercept != nil {
+		if dialOptions := intercept.DialOptions; dialOptions != nil {
+			if dialOptions.ConnectTimeoutSeconds != nil && *dialOptions.ConnectTimeoutSeconds > 0 {
+				options.ConnectTimeout = time.Duration(*dialOptions.ConnectTimeoutSeconds) * time.Second
+			}
+
+			if dialOptions.Identity != nil && *dialOptions.Identity != "" {
+				identity, err := expandInterceptTemplate(*dialOptions.Identity, vars)
+				if err != nil {
+					return nil, errors.Wrap(err, "unable to determine the identity to dial")
+				}
+				options.Identity = identity
+			}
+		}
+
+		if intercept.SourceIp != nil && *intercept.SourceIp != "" {
+			if sourceAddr, err := expandInterceptTemplate(*intercept.SourceIp, vars); err != nil {
+				pfxlog.Logger().WithError(err).WithField("sourceIp", *intercept.SourceIp).
+					Debug("not sending source address for intercepted dial")
+			} else {
+				appdata["source_addr"] = sourceAddr
+			}
+		}
+	}
+
+	appdataJson, err := json.Marshal(appdata)
+	if err != nil {
+		return nil, err
+	}
+	options.AppData = appdataJson
+
+	return options, nil
+}
+
+// ipOf returns host if it is an IP address, or an empty string otherwise
+func ipOf(host string) string {
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// expandInterceptTemplate replaces the variables in template with their values. An error is returned if template
+// references a variable without a value.
+func expandInterceptTemplate(template string, vars map[string]string) (string, error) {
+	result := template
+	for _, name := range interceptTemplateVariables {
+		if !strings.Contains(result, name) {
+			continue
+		}
+
+		value, found := vars[name]
+		if !found {
+			return "", errors.Errorf("'%s' references %s, which is not available", template, name)
+		}
+		result = strings.ReplaceAll(result, name, value)
+	}
+	return result, nil
+}
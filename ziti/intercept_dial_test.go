@@ -0,0 +1,115 @@
+package ziti
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_interceptDialOptions(t *testing.T) {
+	parseIntercept := func(t *testing.T, config map[string]interface{}) *edge.InterceptV1Config {
+		req := require.New(t)
+		service := &rest_model.ServiceDetail{
+			BaseEntity: rest_model.BaseEntity{ID: ToPtr("svc-id")},
+			Name:       ToPtr("svc"),
+			Config:     map[string]map[string]interface{}{InterceptV1: config},
+		}
+
+		intercept := &edge.InterceptV1Config{}
+		found, err := edge.ParseServiceConfig(service, InterceptV1, intercept)
+		req.NoError(err)
+		req.True(found)
+		return intercept
+	}
+
+	appData := func(t *testing.T, options *DialOptions) map[string]interface{} {
+		result := map[string]interface{}{}
+		require.New(t).NoError(json.Unmarshal(options.AppData, &result))
+		return result
+	}
+
+	t.Run("defaults are used without dial options", func(t *testing.T) {
+		req := require.New(t)
+		options, err := interceptDialOptions(nil, "", "tcp", "db.ziti", "", 5432)
+		req.NoError(err)
+		req.Equal(DefaultInterceptConnectTimeout, options.ConnectTimeout)
+		req.Empty(options.Identity)
+		req.Equal(map[string]interface{}{
+			"dst_protocol": "tcp",
+			"dst_port":     "5432",
+			"dst_hostname": "db.ziti",
+		}, appData(t, options))
+	})
+
+	t.Run("dial options and source ip are templated", func(t *testing.T) {
+		req := require.New(t)
+		intercept := parseIntercept(t, map[string]interface{}{
+			"protocols":  []interface{}{"tcp"},
+			"addresses":  []interface{}{"fd00:7a69::/64"},
+			"portRanges": []interface{}{map[string]interface{}{"low": 80, "high": 443}},
+			"sourceIp":   "$tunneler_id.name",
+			"dialOptions": map[string]interface{}{
+				"identity":              "$dst_ip:$dst_port",
+				"connectTimeoutSeconds": 30,
+			},
+		})
+
+		options, err := interceptDialOptions(intercept, "client1", "tcp", "fd00:7a69::1", "fd00:7a69::1", 443)
+		req.NoError(err)
+		req.Equal(30*time.Second, options.ConnectTimeout)
+		req.Equal("fd00:7a69::1:443", options.Identity)
+		req.Equal(map[string]interface{}{
+			"dst_protocol": "tcp",
+			"dst_port":     "443",
+			"dst_ip":       "fd00:7a69::1",
+			"source_addr":  "client1",
+		}, appData(t, options))
+	})
+
+	t.Run("virtual ips provide both the hostname and the ip", func(t *testing.T) {
+		req := require.New(t)
+		intercept := parseIntercept(t, map[string]interface{}{
+			"dialOptions": map[string]interface{}{"identity": "$dst_hostname@$dst_ip"},
+		})
+
+		// DialAddr passes the hostname a virtual IP was handed out for along with the IP
+		options, err := interceptDialOptions(intercept, "client1", "tcp", "db.ziti", "100.64.0.1", 5432)
+		req.NoError(err)
+		req.Equal("db.ziti@100.64.0.1", options.Identity)
+		req.Equal(map[string]interface{}{
+			"dst_protocol": "tcp",
+			"dst_port":     "5432",
+			"dst_hostname": "db.ziti",
+			"dst_ip":       "100.64.0.1",
+		}, appData(t, options))
+
+		// hostnames which were dialed directly have no ip
+		_, err = interceptDialOptions(intercept, "client1", "tcp", "db.ziti", ipOf("db.ziti"), 5432)
+		req.ErrorContains(err, "$dst_ip")
+	})
+
+	t.Run("identities referencing unavailable variables fail", func(t *testing.T) {
+		req := require.New(t)
+		intercept := parseIntercept(t, map[string]interface{}{
+			"dialOptions": map[string]interface{}{"identity": "$dst_hostname"},
+		})
+
+		_, err := interceptDialOptions(intercept, "client1", "tcp", "10.0.0.1", ipOf("10.0.0.1"), 80)
+		req.ErrorContains(err, "$dst_hostname")
+	})
+
+	t.Run("source ips referencing the source address are not sent", func(t *testing.T) {
+		req := require.New(t)
+		intercept := parseIntercept(t, map[string]interface{}{
+			"sourceIp": "$src_ip:$src_port",
+		})
+
+		options, err := interceptDialOptions(intercept, "client1", "udp", "db.ziti", "", 53)
+		req.NoError(err)
+		req.NotContains(appData(t, options), "source_addr")
+	})
+}
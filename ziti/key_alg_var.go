@@ -30,8 +30,8 @@ func (f *KeyAlgVar) String() string {
 
 func (f *KeyAlgVar) Set(value string) error {
 	value = strings.ToUpper(value)
-	if value != "EC" && value != "RSA" {
-		return errors.New("invalid option -- must specify either 'EC' or 'RSA'")
+	if value != "EC" && value != "RSA" && value != "ED25519" {
+		return errors.New("invalid option -- must specify either 'EC', 'RSA' or 'ED25519'")
 	}
 	*f = KeyAlgVar(value)
 	return nil
@@ -45,10 +45,14 @@ func (f *KeyAlgVar) RSA() bool {
 	return f.Get() == "RSA"
 }
 
+func (f *KeyAlgVar) Ed25519() bool {
+	return f.Get() == "ED25519"
+}
+
 func (f *KeyAlgVar) Get() string {
 	return string(*f)
 }
 
 func (f *KeyAlgVar) Type() string {
-	return "RSA|EC"
+	return "RSA|EC|ED25519"
 }
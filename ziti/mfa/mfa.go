@@ -0,0 +1,285 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package mfa completes TOTP MFA enrollment for identities without a user, such as headless services, and answers
+// their MFA auth queries and MFA posture prompts with generated codes.
+package mfa
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_client_api_client/current_identity"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// ErrAlreadyEnrolled is returned when enrolling an identity which has completed MFA enrollment. Its secret can't be
+// retrieved again, MFA has to be removed first.
+var ErrAlreadyEnrolled = errors.New("identity has already completed mfa enrollment")
+
+// Authenticator enrolls the current identity of a context in TOTP MFA and answers its MFA queries, with the secret kept
+// in a SecretStore
+type Authenticator struct {
+	ztx   ziti.Context
+	store SecretStore
+
+	lock        sync.Mutex
+	identityId  string
+	lastCounter uint64
+}
+
+func NewAuthenticator(ztx ziti.Context, store SecretStore) *Authenticator {
+	return &Authenticator{
+		ztx:   ztx,
+		store: store,
+	}
+}
+
+// AutoAnswer registers an Authenticator answering the MFA queries of the context with codes from the stored secret.
+// The returned function unregisters it.
+func AutoAnswer(ztx ziti.Context, store SecretStore) func() {
+	return NewAuthenticator(ztx, store).Register()
+}
+
+// Register registers the authenticator as the context's handler for ziti MFA auth queries, which also answers MFA
+// posture prompts. The returned function unregisters it.
+func (self *Authenticator) Register() func() {
+	return self.ztx.RegisterAuthQueryHandler(rest_model.MfaProvidersZiti, "", self)
+}
+
+// Enroll enrolls the current identity in TOTP MFA, verifying the enrollment with a generated code. The secret and
+// recovery codes are stored before the enrollment is verified, so they can't be lost. They are kept if verification
+// fails, as the controller may have accepted a code whose response was lost. A retried Enroll which finds the
+// enrollment already started reuses the stored secret to verify it.
+func (self *Authenticator) Enroll() (*Secret, error) {
+	identityId, err := self.getIdentityId()
+	if err != nil {
+		return nil, err
+	}
+
+	detail, err := self.ztx.EnrollZitiMfa()
+	if err != nil {
+		var conflict *current_identity.EnrollMfaConflict
+		if errors.As(err, &conflict) {
+			return self.resumeEnroll(identityId, err)
+		}
+		return nil, errors.Wrap(err, "unable to start mfa enrollment")
+	}
+
+	if (detail.IsVerified != nil && *detail.IsVerified) || detail.ProvisioningURL == "" {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	secret := &Secret{
+		ProvisioningUrl: detail.ProvisioningURL,
+		RecoveryCodes:   detail.RecoveryCodes,
+	}
+
+	if _, err = secret.Key(); err != nil {
+		return nil, err
+	}
+
+	if err = self.store.Save(identityId, secret); err != nil {
+		return nil, errors.Wrap(err, "unable to store mfa secret")
+	}
+
+	return self.verifyEnroll(secret)
+}
+
+// resumeEnroll verifies an enrollment started by an earlier Enroll with the stored secret. Without a stored secret the
+// enrollment can't be completed and MFA has to be removed first.
+func (self *Authenticator) resumeEnroll(identityId string, conflict error) (*Secret, error) {
+	secret, err := self.store.Load(identityId)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, errors.Wrap(conflict, "unable to start mfa enrollment, no stored secret to resume it with")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load stored mfa secret")
+	}
+
+	return self.verifyEnroll(secret)
+}
+
+func (self *Authenticator) verifyEnroll(secret *Secret) (*Secret, error) {
+	key, err := secret.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := self.code(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = self.ztx.VerifyZitiMfa(code); err != nil {
+		return nil, errors.Wrap(err, "unable to verify mfa enrollment")
+	}
+
+	return secret, nil
+}
+
+// HandleAuthQuery answers ziti MFA queries with a code generated from the stored secret
+func (self *Authenticator) HandleAuthQuery(ctx context.Context, _ *rest_model.AuthQueryDetail, response ziti.AuthQueryResponse) error {
+	code, err := self.Code(ctx)
+	if err != nil {
+		return err
+	}
+	return response(code)
+}
+
+// Code returns a code for the current identity. Each code is only returned once, so if the code of the current period
+// has been used, Code waits for the next period.
+func (self *Authenticator) Code(ctx context.Context) (string, error) {
+	secret, err := self.loadSecret()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := secret.Key()
+	if err != nil {
+		return "", err
+	}
+
+	return self.code(ctx, key)
+}
+
+func (self *Authenticator) code(ctx context.Context, key *Key) (string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := time.Now()
+	counter := key.counter(now)
+	if counter <= self.lastCounter {
+		// controllers may reject a code which was already accepted
+		period := key.Period
+		next := time.Unix(int64(self.lastCounter+1)*int64(period/time.Second), 0)
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		now = time.Now()
+		counter = key.counter(now)
+	}
+
+	code, err := key.codeAt(counter)
+	if err != nil {
+		return "", err
+	}
+
+	self.lastCounter = counter
+	return code, nil
+}
+
+// RecoveryCodes returns the stored recovery codes of the current identity
+func (self *Authenticator) RecoveryCodes() ([]string, error) {
+	secret, err := self.loadSecret()
+	if err != nil {
+		return nil, err
+	}
+	return secret.RecoveryCodes, nil
+}
+
+// NewRecoveryCodes replaces the recovery codes of the current identity and stores the new ones. Reading the new codes
+// takes a second code, so NewRecoveryCodes may wait up to a period for it.
+func (self *Authenticator) NewRecoveryCodes(ctx context.Context) ([]string, error) {
+	identityId, err := self.getIdentityId()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := self.store.Load(identityId)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := self.Code(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = self.ztx.NewZitiMfaRecoveryCodes(code); err != nil {
+		return nil, errors.Wrap(err, "unable to create new recovery codes")
+	}
+
+	// the codes have been replaced, so they are read back with a fresh code, as the code above has been used
+	code, err = self.Code(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "recovery codes were replaced, but no code is available to read them")
+	}
+
+	recoveryCodes, err := self.ztx.GetZitiMfaRecoveryCodes(code)
+	if err != nil {
+		return nil, errors.Wrap(err, "recovery codes were replaced, but could not be read")
+	}
+
+	secret.RecoveryCodes = recoveryCodes
+	if err = self.store.Save(identityId, secret); err != nil {
+		return nil, errors.Wrap(err, "unable to store new recovery codes")
+	}
+
+	return recoveryCodes, nil
+}
+
+// Remove removes MFA from the current identity and deletes its stored secret
+func (self *Authenticator) Remove(ctx context.Context) error {
+	identityId, err := self.getIdentityId()
+	if err != nil {
+		return err
+	}
+
+	code, err := self.Code(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = self.ztx.RemoveZitiMfa(code); err != nil {
+		return errors.Wrap(err, "unable to remove mfa")
+	}
+
+	return self.store.Delete(identityId)
+}
+
+func (self *Authenticator) loadSecret() (*Secret, error) {
+	identityId, err := self.getIdentityId()
+	if err != nil {
+		return nil, err
+	}
+	return self.store.Load(identityId)
+}
+
+// getIdentityId returns the id of the context's identity, which is available to partially authenticated contexts
+func (self *Authenticator) getIdentityId() (string, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.identityId == "" {
+		identity, err := self.ztx.GetCurrentIdentity()
+		if err != nil {
+			return "", errors.Wrap(err, "unable to determine the current identity")
+		}
+		if identity.ID == nil {
+			return "", errors.New("the current identity has no id")
+		}
+		self.identityId = *identity.ID
+	}
+
+	return self.identityId, nil
+}
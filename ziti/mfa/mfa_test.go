@@ -0,0 +1,222 @@
+package mfa
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_client_api_client/current_identity"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+// testContext simulates the mfa endpoints of a controller
+type testContext struct {
+	ziti.Context
+	key           *Key
+	verified      bool
+	recoveryCodes []string
+	handler       ziti.AuthQueryHandler
+
+	// conflict rejects enrollment once it has been started, as controllers do
+	conflict bool
+	started  bool
+
+	// verifyErr fails verification, after applying it if lostResponse is set
+	verifyErr    error
+	lostResponse bool
+}
+
+func (self *testContext) GetCurrentIdentity() (*rest_model.IdentityDetail, error) {
+	id := "worker-1"
+	return &rest_model.IdentityDetail{BaseEntity: rest_model.BaseEntity{ID: &id}}, nil
+}
+
+func (self *testContext) EnrollZitiMfa() (*rest_model.DetailMfa, error) {
+	if self.conflict && self.started {
+		return nil, &current_identity.EnrollMfaConflict{}
+	}
+	self.started = true
+
+	if self.verified {
+		return &rest_model.DetailMfa{IsVerified: &self.verified}, nil
+	}
+	secret := base32.StdEncoding.EncodeToString(self.key.Secret)
+	return &rest_model.DetailMfa{
+		IsVerified: &self.verified,
+		ProvisioningURL: fmt.Sprintf("otpauth://totp/ziti.dev:worker-1?issuer=ziti.dev&secret=%s&period=%d", secret,
+			int(self.key.Period/time.Second)),
+		RecoveryCodes: self.recoveryCodes,
+	}, nil
+}
+
+func (self *testContext) checkCode(code string) error {
+	if !self.key.Validate(code, time.Now(), 1) {
+		return errors.New("invalid code")
+	}
+	return nil
+}
+
+func (self *testContext) VerifyZitiMfa(code string) error {
+	if self.verifyErr != nil && !self.lostResponse {
+		return self.verifyErr
+	}
+	if err := self.checkCode(code); err != nil {
+		return err
+	}
+	self.verified = true
+	return self.verifyErr
+}
+
+func (self *testContext) NewZitiMfaRecoveryCodes(code string) error {
+	if err := self.checkCode(code); err != nil {
+		return err
+	}
+	self.recoveryCodes = []string{"new-1", "new-2"}
+	return nil
+}
+
+func (self *testContext) GetZitiMfaRecoveryCodes(code string) ([]string, error) {
+	if err := self.checkCode(code); err != nil {
+		return nil, err
+	}
+	return self.recoveryCodes, nil
+}
+
+func (self *testContext) RegisterAuthQueryHandler(_ rest_model.MfaProviders, _ rest_model.MfaFormats, handler ziti.AuthQueryHandler) func() {
+	self.handler = handler
+	return func() { self.handler = nil }
+}
+
+func Test_Authenticator(t *testing.T) {
+	req := require.New(t)
+
+	ztx := &testContext{
+		key:           &Key{Secret: []byte("0123456789abcdefghij"), Algorithm: "SHA1", Digits: 6, Period: 30 * time.Second},
+		recoveryCodes: []string{"recovery-1", "recovery-2"},
+	}
+	store := NewMemoryStore()
+	authenticator := NewAuthenticator(ztx, store)
+
+	secret, err := authenticator.Enroll()
+	req.NoError(err)
+	req.True(ztx.verified)
+	req.Equal([]string{"recovery-1", "recovery-2"}, secret.RecoveryCodes)
+
+	stored, err := store.Load("worker-1")
+	req.NoError(err)
+	req.Equal(secret, stored)
+
+	_, err = authenticator.Enroll()
+	req.ErrorIs(err, ErrAlreadyEnrolled)
+
+	unregister := authenticator.Register()
+	req.NotNil(ztx.handler)
+
+	// the code used to verify enrollment isn't reused, so answering waits for the next period
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = ztx.handler.HandleAuthQuery(ctx, &rest_model.AuthQueryDetail{}, ztx.checkCode)
+	req.ErrorIs(err, context.DeadlineExceeded)
+
+	authenticator.lastCounter = 0
+	var answered string
+	req.NoError(ztx.handler.HandleAuthQuery(context.Background(), &rest_model.AuthQueryDetail{}, func(code string) error {
+		answered = code
+		return ztx.checkCode(code)
+	}))
+	req.Len(answered, 6)
+
+	unregister()
+	req.Nil(ztx.handler)
+}
+
+func Test_Authenticator_NewRecoveryCodes(t *testing.T) {
+	req := require.New(t)
+
+	// a short period keeps the wait for the fresh code used to read the new codes short
+	ztx := &testContext{
+		key:           &Key{Secret: []byte("0123456789abcdefghij"), Algorithm: "SHA1", Digits: 6, Period: time.Second},
+		recoveryCodes: []string{"recovery-1", "recovery-2"},
+	}
+	authenticator := NewAuthenticator(ztx, NewMemoryStore())
+
+	_, err := authenticator.Enroll()
+	req.NoError(err)
+
+	recoveryCodes, err := authenticator.NewRecoveryCodes(context.Background())
+	req.NoError(err)
+	req.Equal([]string{"new-1", "new-2"}, recoveryCodes)
+
+	recoveryCodes, err = authenticator.RecoveryCodes()
+	req.NoError(err)
+	req.Equal([]string{"new-1", "new-2"}, recoveryCodes)
+}
+
+func Test_Authenticator_EnrollFailures(t *testing.T) {
+	newContext := func() *testContext {
+		return &testContext{
+			key:           &Key{Secret: []byte("0123456789abcdefghij"), Algorithm: "SHA1", Digits: 6, Period: 30 * time.Second},
+			recoveryCodes: []string{"recovery-1"},
+			conflict:      true,
+		}
+	}
+
+	t.Run("secret is kept when the verify response is lost", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newContext()
+		ztx.verifyErr = errors.New("connection reset")
+		ztx.lostResponse = true
+
+		store := NewMemoryStore()
+		authenticator := NewAuthenticator(ztx, store)
+
+		_, err := authenticator.Enroll()
+		req.Error(err)
+		req.True(ztx.verified)
+
+		stored, err := store.Load("worker-1")
+		req.NoError(err)
+		key, err := stored.Key()
+		req.NoError(err)
+		req.Equal(ztx.key.Secret, key.Secret)
+	})
+
+	t.Run("retried enroll reuses the stored secret", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newContext()
+		ztx.verifyErr = errors.New("connection refused")
+
+		store := NewMemoryStore()
+		authenticator := NewAuthenticator(ztx, store)
+
+		_, err := authenticator.Enroll()
+		req.Error(err)
+		req.False(ztx.verified)
+
+		stored, err := store.Load("worker-1")
+		req.NoError(err)
+
+		ztx.verifyErr = nil
+		authenticator.lastCounter = 0
+
+		secret, err := authenticator.Enroll()
+		req.NoError(err)
+		req.True(ztx.verified)
+		req.Equal(stored, secret)
+	})
+
+	t.Run("started enrollment without a stored secret fails", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newContext()
+		ztx.started = true
+
+		_, err := NewAuthenticator(ztx, NewMemoryStore()).Enroll()
+		req.ErrorContains(err, "no stored secret")
+		req.False(ztx.verified)
+	})
+}
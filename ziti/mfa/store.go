@@ -0,0 +1,156 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package mfa
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrSecretNotFound is returned by secret stores which hold no secret for an identity
+var ErrSecretNotFound = errors.New("mfa secret not found")
+
+// Secret is the TOTP enrollment of an identity
+type Secret struct {
+	ProvisioningUrl string   `json:"provisioningUrl"`
+	RecoveryCodes   []string `json:"recoveryCodes,omitempty"`
+}
+
+// Key returns the TOTP key of the secret
+func (self *Secret) Key() (*Key, error) {
+	return ParseProvisioningUrl(self.ProvisioningUrl)
+}
+
+// SecretStore persists the TOTP secrets of identities, by identity id. Implementations can keep secrets in OS
+// keychains, secret managers or hardware backed stores.
+type SecretStore interface {
+	// Load returns the secret of the identity, or ErrSecretNotFound
+	Load(identityId string) (*Secret, error)
+	Save(identityId string, secret *Secret) error
+	Delete(identityId string) error
+}
+
+// MemoryStore keeps secrets in memory, for tests and for processes which enroll on every start
+type MemoryStore struct {
+	lock    sync.Mutex
+	secrets map[string]Secret
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		secrets: map[string]Secret{},
+	}
+}
+
+func (self *MemoryStore) Load(identityId string) (*Secret, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	secret, found := self.secrets[identityId]
+	if !found {
+		return nil, ErrSecretNotFound
+	}
+	secret.RecoveryCodes = append([]string(nil), secret.RecoveryCodes...)
+	return &secret, nil
+}
+
+func (self *MemoryStore) Save(identityId string, secret *Secret) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	stored := *secret
+	stored.RecoveryCodes = append([]string(nil), secret.RecoveryCodes...)
+	self.secrets[identityId] = stored
+	return nil
+}
+
+func (self *MemoryStore) Delete(identityId string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.secrets, identityId)
+	return nil
+}
+
+// FileStore keeps each secret in a JSON file in a directory, readable only by the owner
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (self *FileStore) path(identityId string) string {
+	return filepath.Join(self.Dir, url.PathEscape(identityId)+".json")
+}
+
+func (self *FileStore) Load(identityId string) (*Secret, error) {
+	data, err := os.ReadFile(self.path(identityId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &Secret{}
+	if err = json.Unmarshal(data, secret); err != nil {
+		return nil, errors.Wrapf(err, "invalid mfa secret file for identity '%s'", identityId)
+	}
+	return secret, nil
+}
+
+func (self *FileStore) Save(identityId string, secret *Secret) error {
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(self.Dir, 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so an interrupted write doesn't lose the secret
+	tmp, err := os.CreateTemp(self.Dir, ".mfa-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), self.path(identityId))
+}
+
+func (self *FileStore) Delete(identityId string) error {
+	if err := os.Remove(self.path(identityId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package mfa
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SecretStores(t *testing.T) {
+	stores := map[string]SecretStore{
+		"memory": NewMemoryStore(),
+		"file":   NewFileStore(filepath.Join(t.TempDir(), "mfa")),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			req := require.New(t)
+
+			_, err := store.Load("worker-1")
+			req.ErrorIs(err, ErrSecretNotFound)
+
+			secret := &Secret{
+				ProvisioningUrl: "otpauth://totp/worker-1?secret=GEZDGNBV",
+				RecoveryCodes:   []string{"a", "b"},
+			}
+			req.NoError(store.Save("worker-1", secret))
+
+			loaded, err := store.Load("worker-1")
+			req.NoError(err)
+			req.Equal(secret, loaded)
+
+			req.NoError(store.Delete("worker-1"))
+			_, err = store.Load("worker-1")
+			req.ErrorIs(err, ErrSecretNotFound)
+			req.NoError(store.Delete("worker-1"))
+		})
+	}
+
+	t.Run("secret files are only readable by the owner", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes don't apply on windows")
+		}
+		req := require.New(t)
+		store := NewFileStore(t.TempDir())
+		req.NoError(store.Save("../worker", &Secret{ProvisioningUrl: "otpauth://totp/worker?secret=GEZDGNBV"}))
+
+		entries, err := os.ReadDir(store.Dir)
+		req.NoError(err)
+		req.Len(entries, 1)
+
+		info, err := entries[0].Info()
+		req.NoError(err)
+		req.Equal(os.FileMode(0600), info.Mode().Perm())
+	})
+}
@@ -0,0 +1,193 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+)
+
+// Key is a TOTP key, as described by an otpauth provisioning url
+type Key struct {
+	Issuer  string
+	Account string
+	Secret  []byte
+
+	// Algorithm is SHA1, SHA256 or SHA512
+	Algorithm string
+	Digits    int
+	Period    time.Duration
+}
+
+// ParseProvisioningUrl parses an otpauth://totp/ provisioning url, such as the one returned by
+// ziti.Context.EnrollZitiMfa
+func ParseProvisioningUrl(provisioningUrl string) (*Key, error) {
+	parsed, err := url.Parse(provisioningUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid provisioning url")
+	}
+
+	if parsed.Scheme != "otpauth" {
+		return nil, errors.Errorf("invalid provisioning url scheme '%s', expected otpauth", parsed.Scheme)
+	}
+
+	if parsed.Host != "totp" {
+		return nil, errors.Errorf("unsupported otp type '%s', expected totp", parsed.Host)
+	}
+
+	query := parsed.Query()
+
+	key := &Key{
+		Issuer:    query.Get("issuer"),
+		Algorithm: "SHA1",
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod,
+	}
+
+	label := strings.TrimPrefix(parsed.Path, "/")
+	if issuer, account, found := strings.Cut(label, ":"); found {
+		if key.Issuer == "" {
+			key.Issuer = issuer
+		}
+		key.Account = strings.TrimSpace(account)
+	} else {
+		key.Account = label
+	}
+
+	if key.Secret, err = DecodeSecret(query.Get("secret")); err != nil {
+		return nil, err
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		key.Algorithm = strings.ToUpper(algorithm)
+		if key.hash() == nil {
+			return nil, errors.Errorf("unsupported totp algorithm '%s'", algorithm)
+		}
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		if key.Digits, err = strconv.Atoi(digits); err != nil || key.Digits < 6 || key.Digits > 10 {
+			return nil, errors.Errorf("invalid totp digits '%s'", digits)
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		seconds, err := strconv.Atoi(period)
+		if err != nil || seconds <= 0 {
+			return nil, errors.Errorf("invalid totp period '%s'", period)
+		}
+		key.Period = time.Duration(seconds) * time.Second
+	}
+
+	return key, nil
+}
+
+// DecodeSecret decodes a base32 TOTP secret, with or without padding
+func DecodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	if secret == "" {
+		return nil, errors.New("totp secret is missing")
+	}
+
+	result, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid totp secret")
+	}
+	return result, nil
+}
+
+// Code returns the code for the given time, as defined by RFC 6238
+func (self *Key) Code(t time.Time) (string, error) {
+	return self.codeAt(self.counter(t))
+}
+
+// Validate returns true if code is the code for the given time, or for one of the skew periods before or after it
+func (self *Key) Validate(code string, t time.Time, skew int) bool {
+	counter := self.counter(t)
+	for i := -skew; i <= skew; i++ {
+		if expected, err := self.codeAt(counter + uint64(i)); err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Key) counter(t time.Time) uint64 {
+	period := self.Period
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	return uint64(t.Unix() / int64(period/time.Second))
+}
+
+func (self *Key) hash() func() hash.Hash {
+	switch self.Algorithm {
+	case "", "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// codeAt returns the HOTP code for the given counter, as defined by RFC 4226
+func (self *Key) codeAt(counter uint64) (string, error) {
+	hashFunc := self.hash()
+	if hashFunc == nil {
+		return "", errors.Errorf("unsupported totp algorithm '%s'", self.Algorithm)
+	}
+
+	digits := self.Digits
+	if digits == 0 {
+		digits = DefaultDigits
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(hashFunc, self.Secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint64(1)
+	for i := 0; i < digits; i++ {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, uint64(value)%modulus), nil
+}
@@ -0,0 +1,91 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Key_Code(t *testing.T) {
+	// test vectors from RFC 6238, appendix B
+	keys := map[string]*Key{
+		"SHA1":   {Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 8, Period: 30 * time.Second},
+		"SHA256": {Secret: []byte("12345678901234567890123456789012"), Algorithm: "SHA256", Digits: 8, Period: 30 * time.Second},
+		"SHA512": {Secret: []byte("1234567890123456789012345678901234567890123456789012345678901234"), Algorithm: "SHA512", Digits: 8, Period: 30 * time.Second},
+	}
+
+	vectors := []struct {
+		time      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1234567890, "SHA1", "89005924"},
+		{2000000000, "SHA1", "69279037"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, vector := range vectors {
+		code, err := keys[vector.algorithm].Code(time.Unix(vector.time, 0))
+		require.NoError(t, err)
+		require.Equal(t, vector.code, code, "%s at %d", vector.algorithm, vector.time)
+	}
+}
+
+func Test_ParseProvisioningUrl(t *testing.T) {
+	t.Run("ziti provisioning urls are parsed", func(t *testing.T) {
+		req := require.New(t)
+		secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+		key, err := ParseProvisioningUrl("otpauth://totp/ziti.dev:worker-1?issuer=ziti.dev&secret=" + secret)
+		req.NoError(err)
+		req.Equal("ziti.dev", key.Issuer)
+		req.Equal("worker-1", key.Account)
+		req.Equal([]byte("12345678901234567890"), key.Secret)
+		req.Equal("SHA1", key.Algorithm)
+		req.Equal(6, key.Digits)
+		req.Equal(30*time.Second, key.Period)
+
+		code, err := key.Code(time.Unix(59, 0))
+		req.NoError(err)
+		req.Equal("287082", code)
+		req.True(key.Validate(code, time.Unix(80, 0), 1))
+		req.False(key.Validate(code, time.Unix(200, 0), 1))
+	})
+
+	t.Run("parameters are parsed", func(t *testing.T) {
+		req := require.New(t)
+		key, err := ParseProvisioningUrl("otpauth://totp/worker?secret=gezdgnbvgy3tqojq&algorithm=sha256&digits=8&period=60")
+		req.NoError(err)
+		req.Equal("worker", key.Account)
+		req.Equal("SHA256", key.Algorithm)
+		req.Equal(8, key.Digits)
+		req.Equal(time.Minute, key.Period)
+	})
+
+	t.Run("invalid urls are rejected", func(t *testing.T) {
+		req := require.New(t)
+		for _, invalid := range []string{
+			"https://example.com/totp?secret=GEZDGNBV",
+			"otpauth://hotp/worker?secret=GEZDGNBV",
+			"otpauth://totp/worker",
+			"otpauth://totp/worker?secret=1!",
+			"otpauth://totp/worker?secret=GEZDGNBV&algorithm=MD5",
+			"otpauth://totp/worker?secret=GEZDGNBV&digits=4",
+			"otpauth://totp/worker?secret=GEZDGNBV&period=0",
+		} {
+			_, err := ParseProvisioningUrl(invalid)
+			req.Error(err, invalid)
+		}
+	})
+}
@@ -0,0 +1,276 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"github.com/go-openapi/runtime"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/edge-api/rest_util"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// invalidPostureErrorCode is the controller error code returned when posture checks of a service aren't passing
+const invalidPostureErrorCode = "INVALID_POSTURE"
+
+// DefaultMfaPosturePromptLead is how long before an MFA posture check times out that a new code is requested
+const DefaultMfaPosturePromptLead = time.Minute
+
+// MfaRequiredError is returned when dialing or binding a service fails because its posture policies require an MFA
+// code which hasn't been provided, has timed out, or has been invalidated by the endpoint waking or unlocking. Answering
+// EventMfaTotpCode satisfies the checks.
+type MfaRequiredError struct {
+	ServiceName string
+
+	// Queries are the MFA posture queries of the service which aren't passing
+	Queries []*rest_model.PostureQuery
+
+	// Cause is the error returned by the controller
+	Cause error
+}
+
+func (self *MfaRequiredError) Error() string {
+	return fmt.Sprintf("service '%s' requires an MFA code: %v", self.ServiceName, self.Cause)
+}
+
+func (self *MfaRequiredError) Unwrap() error {
+	return self.Cause
+}
+
+// mfaPostureState tracks when the MFA posture checks of services time out, so codes can be requested beforehand
+type mfaPostureState struct {
+	lock       sync.Mutex
+	deadlines  map[string]time.Time // query id -> time the query stops passing
+	timer      *time.Timer
+	promptAt   time.Time
+	lastPrompt time.Time
+}
+
+func (self *mfaPostureState) stop() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+}
+
+// mfaPostureQueries returns the MFA posture queries of the given service for policies of the given type
+func mfaPostureQueries(service *rest_model.ServiceDetail, policyType rest_model.DialBind) []*rest_model.PostureQuery {
+	var result []*rest_model.PostureQuery
+	for _, querySet := range service.PostureQueries {
+		if querySet == nil || (policyType != "" && querySet.PolicyType != "" && querySet.PolicyType != policyType) {
+			continue
+		}
+		for _, query := range querySet.PostureQueries {
+			if query != nil && query.QueryType != nil && *query.QueryType == rest_model.PostureCheckTypeMFA {
+				result = append(result, query)
+			}
+		}
+	}
+	return result
+}
+
+// updateMfaPosture records the MFA posture timeouts of the current services and schedules the next code request. A
+// code is requested right away if an MFA posture check of a dialed or bound service isn't passing.
+func (context *ContextImpl) updateMfaPosture() {
+	state := &context.mfaPosture
+	now := time.Now()
+	lead := context.options.getMfaPosturePromptLead()
+
+	deadlines := map[string]time.Time{}
+	var promptAt time.Time
+
+	context.services.IterCb(func(_ string, svc *rest_model.ServiceDetail) {
+		active := svc.ID != nil && context.CtrlClt.PostureCache.IsActiveService(*svc.ID)
+
+		for _, query := range mfaPostureQueries(svc, "") {
+			var queryPromptAt time.Time
+
+			if query.IsPassing != nil && !*query.IsPassing {
+				queryPromptAt = now
+			} else if query.TimeoutRemaining != nil && *query.TimeoutRemaining >= 0 {
+				deadline := now.Add(time.Duration(*query.TimeoutRemaining) * time.Second)
+				if query.ID != nil {
+					deadlines[*query.ID] = deadline
+				}
+				queryPromptAt = deadline.Add(-lead)
+			} else {
+				continue
+			}
+
+			if active && (promptAt.IsZero() || queryPromptAt.Before(promptAt)) {
+				promptAt = queryPromptAt
+			}
+		}
+	})
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	state.deadlines = deadlines
+
+	if promptAt.IsZero() {
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+		return
+	}
+
+	// an unanswered prompt isn't repeated more often than the lead
+	if !state.lastPrompt.IsZero() && promptAt.Before(state.lastPrompt.Add(lead)) {
+		promptAt = state.lastPrompt.Add(lead)
+	}
+
+	if state.timer != nil {
+		if state.promptAt.Equal(promptAt) {
+			return
+		}
+		state.timer.Stop()
+	}
+
+	state.promptAt = promptAt
+	state.timer = time.AfterFunc(time.Until(promptAt), context.promptMfaPosture)
+}
+
+// promptMfaPosture emits EventMfaTotpCode to request a code for MFA posture checks
+func (context *ContextImpl) promptMfaPosture() {
+	state := &context.mfaPosture
+	state.lock.Lock()
+	state.timer = nil
+	state.lastPrompt = time.Now()
+	state.lock.Unlock()
+
+	if context.closed.Load() {
+		return
+	}
+
+	pfxlog.Logger().Info("requesting an MFA code for services with MFA posture checks")
+
+	provider := rest_model.MfaProvidersZiti
+	query := &rest_model.AuthQueryDetail{
+		Provider:   &provider,
+		TypeID:     "MFA",
+		Format:     rest_model.MfaFormatsNumeric,
+		MinLength:  6,
+		MaxLength:  6,
+		HTTPMethod: "POST",
+		HTTPURL:    "./authenticate/mfa",
+	}
+
+	context.publish(&MfaTotpCodeEvent{Query: query, Response: context.answerMfaPosture})
+
+	if handler := context.authQueryHandlers.get(query); handler != nil {
+		if err := context.answerAuthQuery(handler, query, context.answerMfaPosture); err != nil {
+			pfxlog.Logger().WithError(err).Error("mfa handler failed to answer mfa posture prompt")
+		}
+	}
+}
+
+// answerMfaPosture submits the code for MFA posture checks, and refreshes services to pick up their new state
+func (context *ContextImpl) answerMfaPosture(code string) error {
+	if err := context.CtrlClt.AuthenticateMFA(code); err != nil {
+		return err
+	}
+
+	state := &context.mfaPosture
+	state.lock.Lock()
+	state.lastPrompt = time.Time{}
+	state.lock.Unlock()
+
+	return context.refreshServices(true)
+}
+
+// onPostureSubmitted refreshes services after an endpoint state response is submitted, as waking or unlocking may
+// invalidate MFA posture checks which prompt on wake or unlock
+func (context *ContextImpl) onPostureSubmitted(responses []rest_model.PostureResponseCreate) {
+	for _, response := range responses {
+		if _, ok := response.(*rest_model.PostureResponseEndpointStateCreate); ok {
+			go func() {
+				if err := context.refreshServices(true); err != nil {
+					pfxlog.Logger().WithError(err).Error("unable to refresh services after endpoint state change")
+				}
+			}()
+			return
+		}
+	}
+}
+
+// isPostureFailure returns true if the controller rejected a request because posture checks aren't passing
+func isPostureFailure(err error) bool {
+	var apiErr *rest_util.APIFormattedError
+	if errors.As(err, &apiErr) && apiErr.APIError != nil {
+		return apiErr.Code == invalidPostureErrorCode
+	}
+
+	// session creation doesn't declare the conflict response, so it arrives without a payload
+	var runtimeErr *runtime.APIError
+	if errors.As(err, &runtimeErr) {
+		return runtimeErr.Code == http.StatusConflict
+	}
+
+	return false
+}
+
+// mfaRequiredError returns an MfaRequiredError if the given session creation failure is a posture failure due to MFA
+// posture checks of the service not passing, otherwise nil
+func (context *ContextImpl) mfaRequiredError(service *rest_model.ServiceDetail, sessionType SessionType, cause error) *MfaRequiredError {
+	if !isPostureFailure(cause) {
+		return nil
+	}
+
+	state := &context.mfaPosture
+	state.lock.Lock()
+	deadlines := state.deadlines
+	state.lock.Unlock()
+
+	now := time.Now()
+	var failing []*rest_model.PostureQuery
+	for _, query := range mfaPostureQueries(service, rest_model.DialBind(sessionType)) {
+		timedOut := false
+		if query.ID != nil {
+			if deadline, found := deadlines[*query.ID]; found && !now.Before(deadline) {
+				timedOut = true
+			}
+		}
+
+		if timedOut || (query.IsPassing != nil && !*query.IsPassing) {
+			failing = append(failing, query)
+		}
+	}
+
+	if len(failing) == 0 {
+		return nil
+	}
+
+	serviceName := ""
+	if service.Name != nil {
+		serviceName = *service.Name
+	}
+
+	return &MfaRequiredError{
+		ServiceName: serviceName,
+		Queries:     failing,
+		Cause:       cause,
+	}
+}
@@ -0,0 +1,191 @@
+package ziti
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/edge-api/rest_util"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func newMfaPostureTestContext(t *testing.T, lead time.Duration) *ContextImpl {
+	closeNotify := make(chan struct{})
+	t.Cleanup(func() { close(closeNotify) })
+
+	ztx := &ContextImpl{
+		options:           &Options{MfaPosturePromptLead: lead, EventBufferSize: 10},
+		services:          cmap.New[*rest_model.ServiceDetail](),
+		authQueryHandlers: newAuthQueryRegistry(),
+		CtrlClt: &CtrlClient{
+			PostureCache: posture.NewCacheWithOptions(nil, closeNotify, &posture.CacheOptions{Simulate: true}),
+		},
+		eventBus:     newEventBus(),
+		EventEmmiter: events.New(),
+	}
+	t.Cleanup(ztx.mfaPosture.stop)
+	return ztx
+}
+
+func newInvalidPostureError() error {
+	return &rest_util.APIFormattedError{
+		APIError: &rest_model.APIError{Code: invalidPostureErrorCode, Message: "invalid posture"},
+	}
+}
+
+func newMfaService(name string, policyType rest_model.DialBind, isPassing bool, timeoutRemaining int64) *rest_model.ServiceDetail {
+	mfaType := rest_model.PostureCheckTypeMFA
+	return &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr(name + "-id")},
+		Name:       ToPtr(name),
+		PostureQueries: []*rest_model.PostureQueries{{
+			PolicyID:   ToPtr(name + "-policy"),
+			PolicyType: policyType,
+			IsPassing:  ToPtr(isPassing),
+			PostureQueries: []*rest_model.PostureQuery{{
+				BaseEntity:       rest_model.BaseEntity{ID: ToPtr(name + "-mfa")},
+				IsPassing:        ToPtr(isPassing),
+				QueryType:        &mfaType,
+				Timeout:          ToPtr(int64(3600)),
+				TimeoutRemaining: ToPtr(timeoutRemaining),
+			}},
+		}},
+	}
+}
+
+func Test_MfaPosture(t *testing.T) {
+	t.Run("codes are requested before mfa posture times out", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, 1900*time.Millisecond)
+		prompts := ztx.Subscribe(context.Background(), EventNames(EventMfaTotpCode))
+
+		svc := newMfaService("web", rest_model.DialBindDial, true, 2)
+		ztx.services.Set("web", svc)
+		ztx.CtrlClt.PostureCache.AddActiveService(*svc.ID)
+		ztx.updateMfaPosture()
+
+		select {
+		case event := <-prompts:
+			prompt := event.(*MfaTotpCodeEvent)
+			req.Equal(rest_model.MfaProvidersZiti, *prompt.Query.Provider)
+			req.NotNil(prompt.Response)
+		case <-time.After(time.Second):
+			req.Fail("no mfa code requested")
+		}
+	})
+
+	t.Run("failing mfa posture requests a code right away", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+		prompts := ztx.Subscribe(context.Background(), EventNames(EventMfaTotpCode))
+
+		svc := newMfaService("web", rest_model.DialBindDial, false, 0)
+		ztx.services.Set("web", svc)
+		ztx.CtrlClt.PostureCache.AddActiveService(*svc.ID)
+		ztx.updateMfaPosture()
+
+		select {
+		case <-prompts:
+		case <-time.After(time.Second):
+			req.Fail("no mfa code requested")
+		}
+
+		// an unanswered prompt isn't repeated right away
+		ztx.updateMfaPosture()
+		select {
+		case <-prompts:
+			req.Fail("mfa code requested again")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("services which aren't dialed or bound don't request codes", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+		prompts := ztx.Subscribe(context.Background(), EventNames(EventMfaTotpCode))
+
+		ztx.services.Set("web", newMfaService("web", rest_model.DialBindDial, false, 0))
+		ztx.updateMfaPosture()
+
+		select {
+		case <-prompts:
+			req.Fail("mfa code requested for inactive service")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("failing mfa posture is reported as a typed error", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+		cause := newInvalidPostureError()
+
+		failing := newMfaService("web", rest_model.DialBindDial, false, 0)
+		ztx.services.Set("web", failing)
+		ztx.updateMfaPosture()
+
+		mfaErr := ztx.mfaRequiredError(failing, SessionType(SessionDial), cause)
+		req.NotNil(mfaErr)
+		req.Equal("web", mfaErr.ServiceName)
+		req.Len(mfaErr.Queries, 1)
+		req.ErrorIs(mfaErr, cause)
+
+		var wrapped *MfaRequiredError
+		req.True(errors.As(fmt.Errorf("dial failed: %w", mfaErr), &wrapped))
+
+		conflict := runtime.NewAPIError("conflict", nil, http.StatusConflict)
+		req.NotNil(ztx.mfaRequiredError(failing, SessionType(SessionDial), conflict))
+
+		req.Nil(ztx.mfaRequiredError(failing, SessionType(SessionBind), cause))
+
+		passing := newMfaService("api", rest_model.DialBindDial, true, 600)
+		ztx.services.Set("api", passing)
+		ztx.updateMfaPosture()
+		req.Nil(ztx.mfaRequiredError(passing, SessionType(SessionDial), cause))
+	})
+
+	t.Run("timed out mfa posture is reported as a typed error", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+
+		svc := newMfaService("web", rest_model.DialBindDial, true, 0)
+		ztx.services.Set("web", svc)
+		ztx.updateMfaPosture()
+
+		req.NotNil(ztx.mfaRequiredError(svc, SessionType(SessionDial), newInvalidPostureError()))
+	})
+
+	t.Run("other session failures are not reported as mfa required", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+
+		svc := newMfaService("web", rest_model.DialBindDial, false, 0)
+		ztx.services.Set("web", svc)
+		ztx.updateMfaPosture()
+
+		req.Nil(ztx.mfaRequiredError(svc, SessionType(SessionDial), errors.New("connection refused")))
+		req.Nil(ztx.mfaRequiredError(svc, SessionType(SessionDial), runtime.NewAPIError("unavailable", nil, http.StatusServiceUnavailable)))
+		req.Nil(ztx.mfaRequiredError(svc, SessionType(SessionDial), &rest_util.APIFormattedError{
+			APIError: &rest_model.APIError{Code: "NOT_FOUND", Message: "not found"},
+		}))
+	})
+
+	t.Run("services without a name are reported", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newMfaPostureTestContext(t, time.Minute)
+
+		svc := newMfaService("web", rest_model.DialBindDial, false, 0)
+		svc.Name = nil
+
+		mfaErr := ztx.mfaRequiredError(svc, SessionType(SessionDial), newInvalidPostureError())
+		req.NotNil(mfaErr)
+		req.Equal("", mfaErr.ServiceName)
+	})
+}
@@ -0,0 +1,121 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"context"
+	"github.com/openziti/sdk-golang/ziti/dns"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+)
+
+// NetDialer is a drop-in replacement for net.Dialer which connects to intercepted addresses over Ziti. Its Resolver
+// resolves intercepted hostnames to virtual IPs and all other names with the system resolver, so libraries which
+// resolve hostnames before dialing, or which accept a *net.Resolver or a DialContext function, can reach Ziti services
+// by their intercepted hostnames:
+//
+//	dialer := ziti.NewNetDialer(collection)
+//	pgxConfig.LookupFunc = dialer.Resolver.LookupHost
+//	pgxConfig.DialFunc = dialer.DialContext
+//
+// Addresses which aren't intercepted are dialed with the embedded net.Dialer, so its fields, such as Timeout and
+// KeepAlive, apply to them.
+type NetDialer struct {
+	net.Dialer
+	collection *CtxCollection
+	dns        *dns.Server
+}
+
+// NewNetDialer returns a NetDialer for the intercepts of the contexts in the given collection. Virtual IPs are
+// allocated from dns.DefaultIPv4Pool.
+func NewNetDialer(collection *CtxCollection) *NetDialer {
+	// the default configuration is always valid
+	server, _ := dns.NewServer(collection, nil)
+
+	result := &NetDialer{
+		collection: collection,
+		dns:        server,
+	}
+	result.Resolver = server.Resolver()
+	return result
+}
+
+// Dial connects to the given address. See DialContext.
+func (self *NetDialer) Dial(network, address string) (net.Conn, error) {
+	return self.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects over Ziti if the address is intercepted by one of the contexts, either directly or through a
+// virtual IP returned by the Resolver, in which case the hostname the IP was resolved from is dialed. Other addresses
+// are dialed with the embedded net.Dialer. Ziti dials give up when ctx is done, and their connect timeout is
+// shortened to the deadline of ctx or the embedded net.Dialer's Timeout, whichever comes first.
+func (self *NetDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, err
+	}
+
+	virtual := false
+	dstIp := ipOf(host)
+	if ip := net.ParseIP(host); ip != nil {
+		if hostname, found := self.dns.LookupHostname(ip); found {
+			host = hostname
+			virtual = true
+		}
+	}
+
+	if ztx, service := self.collection.getServiceForAddr(normalizeProtocol(network), host, uint16(port)); ztx != nil {
+		if self.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, self.Timeout)
+			defer cancel()
+		}
+
+		var conn net.Conn
+		if impl, ok := ztx.(*ContextImpl); ok {
+			conn, err = impl.dialServiceFromAddr(ctx, service, normalizeProtocol(network), host, dstIp, uint16(port))
+		} else {
+			conn, err = dialWithContext(ctx, func() (edge.Conn, error) {
+				return ztx.DialAddr(network, net.JoinHostPort(host, portString))
+			})
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to dial %s over ziti", address)
+		}
+		return conn, nil
+	}
+
+	if virtual {
+		return nil, errors.Errorf("address [%s:%s:%d] resolved from %s is not intercepted by any ziti context",
+			network, host, port, address)
+	}
+
+	return self.Dialer.DialContext(ctx, network, address)
+}
+
+// Close removes the dialer's virtual IPs from the collection. Addresses returned by the Resolver can't be dialed with
+// DialAddr of the collection afterwards.
+func (self *NetDialer) Close() error {
+	return self.dns.Close()
+}
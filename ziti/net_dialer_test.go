@@ -0,0 +1,134 @@
+package ziti
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NetDialer(t *testing.T) {
+	req := require.New(t)
+
+	address, err := edge.NewZitiAddress("db.ziti")
+	req.NoError(err)
+
+	ztx := &ContextImpl{
+		Id:         "test",
+		intercepts: cmap.New[*edge.InterceptV1Config](),
+	}
+	ztx.intercepts.Set("db", &edge.InterceptV1Config{
+		Addresses:  []edge.ZitiAddress{*address},
+		PortRanges: []*edge.PortRange{{Low: 5432, High: 5432}},
+		Protocols:  []string{"tcp"},
+		Service:    &rest_model.ServiceDetail{Name: ToPtr("db")},
+	})
+
+	collection := NewSdkCollection()
+	collection.Add(ztx)
+
+	dialer := NewNetDialer(collection)
+
+	t.Run("intercepted hostnames resolve to virtual ips", func(t *testing.T) {
+		req := require.New(t)
+		addrs, err := dialer.Resolver.LookupHost(context.Background(), "db.ziti")
+		req.NoError(err)
+		req.Equal([]string{"100.64.0.1"}, addrs)
+
+		ips, err := dialer.Resolver.LookupIP(context.Background(), "ip4", "DB.ziti")
+		req.NoError(err)
+		req.Len(ips, 1)
+		req.Equal("100.64.0.1", ips[0].String())
+	})
+
+	t.Run("other hostnames use the system resolver", func(t *testing.T) {
+		req := require.New(t)
+		addrs, err := dialer.Resolver.LookupHost(context.Background(), "localhost")
+		req.NoError(err)
+		req.NotEmpty(addrs)
+	})
+
+	t.Run("other addresses are dialed directly", func(t *testing.T) {
+		req := require.New(t)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = listener.Close() }()
+
+		conn, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+		req.NoError(err)
+		_ = conn.Close()
+	})
+
+	t.Run("virtual ips on ports which aren't intercepted are refused", func(t *testing.T) {
+		req := require.New(t)
+		_, err := dialer.Dial("tcp", "100.64.0.1:80")
+		req.ErrorContains(err, "resolved from 100.64.0.1:80 is not intercepted")
+	})
+
+	t.Run("the collection maps virtual ips back to hostnames until the dialer is closed", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("db.ziti", collection.hostnameLookups.resolve("100.64.0.1"))
+		req.Equal("other.ziti", collection.hostnameLookups.resolve("other.ziti"))
+		req.Equal("100.64.0.2", collection.hostnameLookups.resolve("100.64.0.2"))
+
+		req.NoError(dialer.Close())
+		req.Equal("100.64.0.1", collection.hostnameLookups.resolve("100.64.0.1"))
+	})
+}
+
+type closeRecordingConn struct {
+	edge.Conn
+	closed chan struct{}
+}
+
+func (self *closeRecordingConn) Close() error {
+	close(self.closed)
+	return nil
+}
+
+func Test_dialWithContext(t *testing.T) {
+	t.Run("completed dials are returned", func(t *testing.T) {
+		req := require.New(t)
+		conn := &closeRecordingConn{closed: make(chan struct{})}
+
+		dialCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		result, err := dialWithContext(dialCtx, func() (edge.Conn, error) {
+			return conn, nil
+		})
+		req.NoError(err)
+		req.Equal(conn, result)
+	})
+
+	t.Run("dials are abandoned when the context is done", func(t *testing.T) {
+		req := require.New(t)
+		conn := &closeRecordingConn{closed: make(chan struct{})}
+		release := make(chan struct{})
+
+		dialCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		result, err := dialWithContext(dialCtx, func() (edge.Conn, error) {
+			<-release
+			return conn, nil
+		})
+		req.ErrorIs(err, context.DeadlineExceeded)
+		req.Nil(result)
+		req.Less(time.Since(start), time.Second)
+
+		// the connection which completes late is closed
+		close(release)
+		select {
+		case <-conn.closed:
+		case <-time.After(time.Second):
+			req.Fail("late connection was not closed")
+		}
+	})
+}
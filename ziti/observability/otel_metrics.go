@@ -0,0 +1,116 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package observability
+
+import (
+	"context"
+	"github.com/openziti/metrics"
+	"github.com/pkg/errors"
+	metrics2 "github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+)
+
+const (
+	OtelAttrMetric   = "ziti.metric"
+	OtelAttrSourceId = "ziti.source_id"
+	OtelAttrQuantile = "quantile"
+)
+
+// RegisterOtelMetrics bridges the registries of the given sources to OpenTelemetry. As registry metrics are created
+// and disposed dynamically, a fixed set of observable instruments is registered and each registry metric is reported
+// as a series of them, identified by the ziti.metric attribute:
+//
+//   - ziti.gauge: gauge values
+//   - ziti.meter.count and ziti.meter.rate_1m: meter totals and one-minute rates
+//   - ziti.histogram.count and ziti.histogram.quantile: histogram and timer sample counts and quantiles
+//
+// Unregister the returned registration to stop reporting.
+func RegisterOtelMetrics(meter otelmetric.Meter, sources ...MetricsSource) (otelmetric.Registration, error) {
+	gauge, err := meter.Int64ObservableGauge("ziti.gauge",
+		otelmetric.WithDescription("ziti SDK gauge values"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create gauge instrument")
+	}
+
+	meterCount, err := meter.Int64ObservableCounter("ziti.meter.count",
+		otelmetric.WithDescription("ziti SDK meter event totals"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create meter count instrument")
+	}
+
+	meterRate, err := meter.Float64ObservableGauge("ziti.meter.rate_1m",
+		otelmetric.WithDescription("ziti SDK meter one-minute rates"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create meter rate instrument")
+	}
+
+	histogramCount, err := meter.Int64ObservableCounter("ziti.histogram.count",
+		otelmetric.WithDescription("ziti SDK histogram sample counts"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create histogram count instrument")
+	}
+
+	histogramQuantile, err := meter.Float64ObservableGauge("ziti.histogram.quantile",
+		otelmetric.WithDescription("ziti SDK histogram quantiles"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create histogram quantile instrument")
+	}
+
+	observeSummary := func(observer otelmetric.Observer, attrs []attribute.KeyValue, count int64, values []float64) {
+		observer.ObserveInt64(histogramCount, count, otelmetric.WithAttributes(attrs...))
+		for i, q := range Quantiles {
+			qAttrs := append(attrs[:len(attrs):len(attrs)], attribute.Float64(OtelAttrQuantile, q))
+			observer.ObserveFloat64(histogramQuantile, values[i], otelmetric.WithAttributes(qAttrs...))
+		}
+	}
+
+	callback := func(_ context.Context, observer otelmetric.Observer) error {
+		for _, source := range sources {
+			registry := source.Metrics()
+			if registry == nil {
+				continue
+			}
+
+			sourceId := registry.SourceId()
+			registry.EachMetric(func(name string, metric metrics.Metric) {
+				attrs := []attribute.KeyValue{
+					attribute.String(OtelAttrSourceId, sourceId),
+					attribute.String(OtelAttrMetric, name),
+				}
+
+				switch m := metric.(type) {
+				case metrics2.Gauge:
+					observer.ObserveInt64(gauge, m.Value(), otelmetric.WithAttributes(attrs...))
+				case metrics2.Timer:
+					snapshot := m.Snapshot()
+					observeSummary(observer, attrs, snapshot.Count(), snapshot.Percentiles(Quantiles))
+				case metrics2.Histogram:
+					snapshot := m.Snapshot()
+					observeSummary(observer, attrs, snapshot.Count(), snapshot.Percentiles(Quantiles))
+				case metrics2.Meter:
+					snapshot := m.Snapshot()
+					observer.ObserveInt64(meterCount, snapshot.Count(), otelmetric.WithAttributes(attrs...))
+					observer.ObserveFloat64(meterRate, snapshot.Rate1(), otelmetric.WithAttributes(attrs...))
+				}
+			})
+		}
+		return nil
+	}
+
+	return meter.RegisterCallback(callback, gauge, meterCount, meterRate, histogramCount, histogramQuantile)
+}
@@ -0,0 +1,115 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package observability
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TracerName = "github.com/openziti/sdk-golang/ziti"
+
+	TraceAttrErrorCode = "ziti.error.code"
+)
+
+// NewOtelTracer returns a ziti.Tracer which records SDK operations as OpenTelemetry spans. Set it on
+// ziti.Options.Tracer when creating a context.
+func NewOtelTracer(provider trace.TracerProvider) ziti.Tracer {
+	return &otelTracer{
+		tracer: provider.Tracer(TracerName),
+	}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (self *otelTracer) StartOperation(ctx context.Context, operation string) (context.Context, ziti.OperationSpan) {
+	ctx, span := self.tracer.Start(ctx, operation)
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (self *otelSpan) SetAttribute(key, value string) {
+	self.span.SetAttributes(attribute.String(key, value))
+}
+
+func (self *otelSpan) End(err error) {
+	if err != nil {
+		self.span.RecordError(err)
+		self.span.SetStatus(codes.Error, err.Error())
+		if code := ErrorCode(err); code != "" {
+			self.span.SetAttributes(attribute.String(TraceAttrErrorCode, code))
+		}
+	}
+	self.span.End()
+}
+
+// NewOtelPropagator returns a ziti.TracePropagator backed by the given OpenTelemetry propagator. If nil, the W3C trace
+// context propagator is used. Set it on ziti.Options.TracePropagator on both the dialing and hosting side.
+func NewOtelPropagator(propagator propagation.TextMapPropagator) ziti.TracePropagator {
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return &otelPropagator{propagator: propagator}
+}
+
+type otelPropagator struct {
+	propagator propagation.TextMapPropagator
+}
+
+func (self *otelPropagator) Inject(ctx context.Context, carrier map[string]string) {
+	self.propagator.Inject(ctx, propagation.MapCarrier(carrier))
+}
+
+func (self *otelPropagator) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return self.propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+type apiErrorPayload interface {
+	GetPayload() *rest_model.APIErrorEnvelope
+}
+
+// ErrorCode returns the Edge API error code carried by err, such as UNAUTHORIZED or NOT_FOUND, if there is one
+func ErrorCode(err error) string {
+	for err != nil {
+		if apiErr, ok := err.(apiErrorPayload); ok {
+			if payload := apiErr.GetPayload(); payload != nil && payload.Error != nil {
+				return payload.Error.Code
+			}
+			return ""
+		}
+
+		if cause, ok := err.(interface{ Cause() error }); ok {
+			err = cause.Cause()
+		} else if wrapped, ok := err.(interface{ Unwrap() error }); ok {
+			err = wrapped.Unwrap()
+		} else {
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package observability
+
+import (
+	"context"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestOtelPropagatorRoundTripThroughAppData(t *testing.T) {
+	req := require.New(t)
+
+	traceId, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	req.NoError(err)
+	spanId, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	req.NoError(err)
+
+	parent := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	propagator := NewOtelPropagator(nil)
+	carrier := map[string]string{}
+	propagator.Inject(parent, carrier)
+	req.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", carrier["traceparent"])
+
+	appData, err := edge.InjectTraceContext([]byte(`{"dst_port":"443"}`), carrier)
+	req.NoError(err)
+	req.JSONEq(`{"dst_port":"443","trace_context":{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}`, string(appData))
+
+	extracted := trace.SpanContextFromContext(propagator.Extract(context.Background(), edge.ExtractTraceContext(appData)))
+	req.True(extracted.IsRemote())
+	req.Equal(traceId, extracted.TraceID())
+	req.Equal(spanId, extracted.SpanID())
+}
+
+func TestInjectTraceContextRequiresJsonAppData(t *testing.T) {
+	req := require.New(t)
+
+	appData := []byte("raw bytes")
+	result, err := edge.InjectTraceContext(appData, map[string]string{"traceparent": "x"})
+	req.Error(err)
+	req.Equal(appData, result)
+	req.Nil(edge.ExtractTraceContext(result))
+}
@@ -0,0 +1,296 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package observability exports SDK metrics and operations to standard monitoring systems. It provides a Prometheus
+// text format http.Handler for a context's metrics.Registry, an OpenTelemetry metrics bridge for the same registry
+// and an OpenTelemetry implementation of ziti.Tracer.
+package observability
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/openziti/metrics"
+	metrics2 "github.com/rcrowley/go-metrics"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	DefaultNamespace      = "ziti"
+)
+
+var Quantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// MetricsSource provides a metrics registry. ziti.Context implements it. The registry is looked up on every scrape,
+// as a context does not create its registry until it has authenticated, so nil is allowed.
+type MetricsSource interface {
+	Metrics() metrics.Registry
+}
+
+// NewPrometheusHandler returns an http.Handler which renders the registries of the given sources in the Prometheus
+// text exposition format. Each series is labeled with the registry's source id.
+func NewPrometheusHandler(sources ...MetricsSource) http.Handler {
+	return &prometheusHandler{
+		namespace: DefaultNamespace,
+		sources:   sources,
+	}
+}
+
+type prometheusHandler struct {
+	namespace string
+	sources   []MetricsSource
+}
+
+func (self *prometheusHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", PrometheusContentType)
+	out := bufio.NewWriter(w)
+
+	var registries []metrics.Registry
+	for _, source := range self.sources {
+		if registry := source.Metrics(); registry != nil {
+			registries = append(registries, registry)
+		}
+	}
+
+	WritePrometheus(out, self.namespace, registries...)
+	_ = out.Flush()
+}
+
+// labelRule moves the variable parts of registry metric names into labels, so metrics such as per router latencies
+// form a single family instead of a family per router. Names start with prefix, followed by the label values and,
+// if suffixes is set, one of the suffixes, which is kept in the family name. The first label takes any dots left over
+// after the remaining labels have each taken one name segment from the right.
+type labelRule struct {
+	prefix   string
+	labels   []string
+	suffixes []string
+}
+
+var labelRules = []*labelRule{
+	{
+		prefix: "latency.",
+		labels: []string{"router_url"},
+	},
+	{
+		prefix:   "edge.router.",
+		labels:   []string{"router"},
+		suffixes: []string{"bytes.in", "bytes.out", "msgs.in", "msgs.out"},
+	},
+	{
+		prefix:   "edge.conn.",
+		labels:   []string{"router", "conn_id"},
+		suffixes: []string{"bytes.in", "bytes.out", "msgs.in", "msgs.out", "opened", "last_activity"},
+	},
+}
+
+// apply returns the family name and labels for the given metric name, or false if the rule doesn't match
+func (self *labelRule) apply(name string) (string, []string, bool) {
+	if !strings.HasPrefix(name, self.prefix) {
+		return "", nil, false
+	}
+
+	rest := name[len(self.prefix):]
+	family := strings.TrimSuffix(self.prefix, ".")
+
+	if len(self.suffixes) > 0 {
+		matched := false
+		for _, suffix := range self.suffixes {
+			if strings.HasSuffix(rest, "."+suffix) {
+				rest = strings.TrimSuffix(rest, "."+suffix)
+				family = self.prefix + suffix
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", nil, false
+		}
+	}
+
+	values := make([]string, len(self.labels))
+	for i := len(self.labels) - 1; i > 0; i-- {
+		idx := strings.LastIndexByte(rest, '.')
+		if idx < 0 {
+			return "", nil, false
+		}
+		values[i] = rest[idx+1:]
+		rest = rest[:idx]
+	}
+	values[0] = rest
+
+	var labels []string
+	for i, label := range self.labels {
+		if values[i] == "" {
+			return "", nil, false
+		}
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(values[i])))
+	}
+
+	return family, labels, true
+}
+
+// splitMetricName returns the family name of a registry metric name and the labels taken from it
+func splitMetricName(name string) (string, []string) {
+	for _, rule := range labelRules {
+		if family, labels, ok := rule.apply(name); ok {
+			return family, labels
+		}
+	}
+	return name, nil
+}
+
+type promFamily struct {
+	name       string
+	metricType string
+	help       string
+	samples    []string
+}
+
+type promWriter struct {
+	namespace string
+	families  map[string]*promFamily
+}
+
+func (self *promWriter) family(name, metricType, help string) *promFamily {
+	family, found := self.families[name]
+	if !found {
+		family = &promFamily{
+			name:       name,
+			metricType: metricType,
+			help:       help,
+		}
+		self.families[name] = family
+	} else if family.metricType != metricType {
+		// a family has a single type, metrics which disagree are dropped rather than producing an invalid exposition
+		return nil
+	}
+	return family
+}
+
+func (self *promWriter) sample(name, metricType, help, labels string, value float64) {
+	if family := self.family(name, metricType, help); family != nil {
+		family.samples = append(family.samples, fmt.Sprintf("%s{%s} %v", name, labels, value))
+	}
+}
+
+func (self *promWriter) summary(name, help, labels string, values []float64, sum int64, count int64) {
+	family := self.family(name, "summary", help)
+	if family == nil {
+		return
+	}
+	for i, q := range Quantiles {
+		family.samples = append(family.samples, fmt.Sprintf("%s{%s,quantile=\"%v\"} %v", name, labels, q, values[i]))
+	}
+	family.samples = append(family.samples,
+		fmt.Sprintf("%s_sum{%s} %v", name, labels, sum),
+		fmt.Sprintf("%s_count{%s} %v", name, labels, count))
+}
+
+func (self *promWriter) add(registry metrics.Registry) {
+	all := map[string]metrics.Metric{}
+	var names []string
+	registry.EachMetric(func(name string, metric metrics.Metric) {
+		all[name] = metric
+		names = append(names, name)
+	})
+	sort.Strings(names)
+
+	sourceLabel := fmt.Sprintf(`source_id="%s"`, escapeLabelValue(registry.SourceId()))
+
+	for _, name := range names {
+		familyName, nameLabels := splitMetricName(name)
+		promName := PrometheusName(self.namespace, familyName)
+		labels := strings.Join(append([]string{sourceLabel}, nameLabels...), ",")
+		help := "ziti sdk metric " + familyName
+
+		switch m := all[name].(type) {
+		case metrics2.Gauge:
+			self.sample(promName, "gauge", help, labels, float64(m.Value()))
+		case metrics2.Timer:
+			snapshot := m.Snapshot()
+			self.summary(promName, help, labels, snapshot.Percentiles(Quantiles), snapshot.Sum(), snapshot.Count())
+		case metrics2.Histogram:
+			snapshot := m.Snapshot()
+			self.summary(promName, help, labels, snapshot.Percentiles(Quantiles), snapshot.Sum(), snapshot.Count())
+		case metrics2.Meter:
+			snapshot := m.Snapshot()
+			self.sample(promName+"_total", "counter", help+" count", labels, float64(snapshot.Count()))
+			self.sample(promName+"_m1_rate", "gauge", help+" one minute rate", labels, snapshot.Rate1())
+		}
+	}
+}
+
+func (self *promWriter) write(out io.Writer) {
+	var names []string
+	for name := range self.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := self.families[name]
+		_, _ = fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", family.name, escapeHelp(family.help), family.name, family.metricType)
+		for _, sample := range family.samples {
+			_, _ = fmt.Fprintln(out, sample)
+		}
+	}
+}
+
+// WritePrometheus writes the contents of the registries to out in the Prometheus text exposition format. Metric names
+// are prefixed with the namespace and have characters that are not valid in Prometheus names replaced by underscores.
+// Router urls and router names in metric names are moved into labels. Samples of all registries are
+// grouped into families, each labeled with the source id of its registry.
+func WritePrometheus(out io.Writer, namespace string, registries ...metrics.Registry) {
+	writer := &promWriter{
+		namespace: namespace,
+		families:  map[string]*promFamily{},
+	}
+	for _, registry := range registries {
+		writer.add(registry)
+	}
+	writer.write(out)
+}
+
+// PrometheusName converts a registry metric name to a valid Prometheus metric name
+func PrometheusName(namespace, name string) string {
+	var b strings.Builder
+	if namespace != "" {
+		b.WriteString(namespace)
+		b.WriteByte('_')
+	}
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
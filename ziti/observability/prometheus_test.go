@@ -0,0 +1,86 @@
+package observability
+
+import (
+	"github.com/openziti/metrics"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testSource struct {
+	registry metrics.Registry
+}
+
+func (self *testSource) Metrics() metrics.Registry {
+	return self.registry
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	req := require.New(t)
+
+	registry := metrics.NewRegistry("ctx1", nil)
+	registry.Meter("edge.router.er1.bytes.in").Mark(42)
+	registry.Meter("edge.router.er.two.bytes.in").Mark(7)
+	registry.Meter("edge.conn.read_queue.overflow").Mark(3)
+	registry.Meter("edge.conn.er1.5.bytes.out").Mark(11)
+	registry.Meter("edge.conn.er1.6.bytes.out").Mark(12)
+	registry.Histogram("latency.tls:er1:3022").Update(100)
+
+	other := metrics.NewRegistry("ctx2", nil)
+	other.Histogram("latency.tls:er2:3022").Update(200)
+
+	handler := NewPrometheusHandler(&testSource{registry: registry}, &testSource{}, &testSource{registry: other})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	req.Equal(PrometheusContentType, recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+
+	req.Contains(body, "# HELP ziti_edge_router_bytes_in_total ziti sdk metric edge.router.bytes.in count\n# TYPE ziti_edge_router_bytes_in_total counter\n")
+	req.Contains(body, `ziti_edge_router_bytes_in_total{source_id="ctx1",router="er1"} 42`)
+	req.Contains(body, `ziti_edge_router_bytes_in_total{source_id="ctx1",router="er.two"} 7`)
+	req.Contains(body, "# TYPE ziti_edge_conn_read_queue_overflow_total counter\n")
+	req.Contains(body, `ziti_edge_conn_read_queue_overflow_total{source_id="ctx1"} 3`)
+	req.Contains(body, `ziti_edge_conn_bytes_out_total{source_id="ctx1",router="er1",conn_id="5"} 11`)
+	req.Contains(body, `ziti_edge_conn_bytes_out_total{source_id="ctx1",router="er1",conn_id="6"} 12`)
+	req.Contains(body, "# TYPE ziti_latency summary\n")
+	req.Contains(body, `ziti_latency{source_id="ctx1",router_url="tls:er1:3022",quantile="0.5"} 100`)
+	req.Contains(body, `ziti_latency_count{source_id="ctx1",router_url="tls:er1:3022"} 1`)
+	req.Contains(body, `ziti_latency{source_id="ctx2",router_url="tls:er2:3022",quantile="0.5"} 200`)
+
+	// each family is described once, however many routers, connections or sources it has samples for
+	req.Equal(1, strings.Count(body, "# TYPE ziti_edge_router_bytes_in_total "))
+	req.Equal(1, strings.Count(body, "# TYPE ziti_edge_conn_bytes_out_total "))
+	req.Equal(1, strings.Count(body, "# TYPE ziti_latency "))
+	req.Equal(1, strings.Count(body, "# HELP ziti_latency "))
+
+	// output is sorted by family name, so scrapes are stable
+	req.Less(strings.Index(body, "ziti_edge_router_bytes_in_total"), strings.Index(body, "ziti_latency"))
+}
+
+func TestSplitMetricName(t *testing.T) {
+	req := require.New(t)
+
+	family, labels := splitMetricName("latency.wss://router.example.com:443")
+	req.Equal("latency", family)
+	req.Equal([]string{`router_url="wss://router.example.com:443"`}, labels)
+
+	family, labels = splitMetricName("edge.router.er1.msgs.out")
+	req.Equal("edge.router.msgs.out", family)
+	req.Equal([]string{`router="er1"`}, labels)
+
+	family, labels = splitMetricName("edge.conn.er.two.7.last_activity")
+	req.Equal("edge.conn.last_activity", family)
+	req.Equal([]string{`router="er.two"`, `conn_id="7"`}, labels)
+
+	family, labels = splitMetricName("edge.conn.read_queue.depth")
+	req.Equal("edge.conn.read_queue.depth", family)
+	req.Empty(labels)
+}
+
+func TestPrometheusName(t *testing.T) {
+	req := require.New(t)
+	req.Equal("ziti_latency_wss___router_example_com_443", PrometheusName("ziti", "latency.wss://router.example.com:443"))
+	req.Equal("a_b", PrometheusName("", "a-b"))
+}
@@ -1,7 +1,12 @@
 package ziti
 
 import (
+	"context"
 	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	"github.com/openziti/transport/v2/wss"
+	"strings"
 	"time"
 )
 
@@ -27,12 +32,131 @@ type Options struct {
 	// Use `zitiContext.AddListener(<eventName>, handler)` where `eventName` may be EventServiceAdded, EventServiceChanged, EventServiceRemoved.
 	OnServiceUpdate     serviceCB
 	EdgeRouterUrlFilter func(string) bool
+
+	// Tracer, if set, is notified of authentication, session creation, router connection, dial and bind operations.
+	Tracer Tracer
+
+	// TracePropagator, if set, propagates trace context from dialers to the connections accepted by hosting
+	// applications.
+	TracePropagator TracePropagator
+
+	// RouterReconnectInitialInterval and RouterReconnectMaxInterval bound the exponential backoff used to reconnect to
+	// edge routers in active sessions after a connection drops or fails. They default to
+	// DefaultRouterReconnectInitialInterval and DefaultRouterReconnectMaxInterval.
+	RouterReconnectInitialInterval time.Duration
+	RouterReconnectMaxInterval     time.Duration
+
+	// RouterProxy, if set, returns the proxy to use to connect to the edge router with the given url, or nil to connect
+	// directly. Controller requests use the proxy from the environment regardless. Use edge.ProxyFromEnvironment to
+	// have routers do the same.
+	RouterProxy func(routerUrl string) (*edge.ProxyConfiguration, error)
+
+	// PreferWebSocketRouters causes edge routers to be connected to using WebSockets (wss) for routers which have a
+	// WebSocket listener. Otherwise, WebSockets are only used for routers which have no other listener.
+	PreferWebSocketRouters bool
+
+	// EventBufferSize is the number of events buffered for each Eventer.Subscribe channel, defaulting to
+	// DefaultEventBufferSize. Events are dropped for subscribers that fall further behind.
+	EventBufferSize int
+
+	// PostureProviders supply the data sent in posture responses. Providers which aren't set collect data from the
+	// local host.
+	PostureProviders *posture.Providers
+
+	// PosturePollInterval is how often posture data is refreshed, defaulting to posture.DefaultPollInterval. On Linux,
+	// changes to network interfaces and watched processes are sent as they happen and polling is a fallback.
+	PosturePollInterval time.Duration
+
+	// SimulatePosture evaluates posture without submitting posture responses to the controller, for diagnostics. The
+	// responses which would have been submitted are reported by Context.PostureStatus, along with whether process
+	// queries pass against the local posture data, see posture.QueryStatus.LocalPassing. Services with posture checks
+	// which need responses will fail to dial.
+	SimulatePosture bool
+
+	// MfaPosturePromptLead is how long before an MFA posture check of a dialed or bound service times out that
+	// EventMfaTotpCode is emitted to request a new code, defaulting to DefaultMfaPosturePromptLead.
+	MfaPosturePromptLead time.Duration
+
+	// AuthQueryTimeout bounds how long auth query handlers have to answer a query, across all attempts, defaulting to
+	// DefaultAuthQueryTimeout.
+	AuthQueryTimeout time.Duration
+
+	// AuthQueryMaxAttempts is how many times an auth query handler is invoked for a query before authentication fails,
+	// defaulting to DefaultAuthQueryMaxAttempts.
+	AuthQueryMaxAttempts int
+
+	// NonInteractive causes authentication to fail right away with ErrNoAuthQueryHandler when there is no registered
+	// handler for an auth query, rather than leaving the context partially authenticated until an event listener
+	// answers. It suits daemons, which have nobody to answer prompts.
+	NonInteractive bool
 }
 
 func (self *Options) isEdgeRouterUrlAccepted(url string) bool {
 	return self.EdgeRouterUrlFilter == nil || self.EdgeRouterUrlFilter(url)
 }
 
+// getEdgeRouterUrls returns the urls of the given edge router which should be connected to
+func (self *Options) getEdgeRouterUrls(edgeRouter *rest_model.SessionEdgeRouter) []string {
+	var webSocketUrls, otherUrls []string
+	for _, u := range edgeRouter.Urls {
+		if !self.isEdgeRouterUrlAccepted(u) {
+			continue
+		}
+		if strings.HasPrefix(u, wss.Type+":") {
+			webSocketUrls = append(webSocketUrls, u)
+		} else {
+			otherUrls = append(otherUrls, u)
+		}
+	}
+
+	if len(otherUrls) == 0 || (self.PreferWebSocketRouters && len(webSocketUrls) > 0) {
+		return webSocketUrls
+	}
+	return otherUrls
+}
+
+func (self *Options) getEventBufferSize() int {
+	if self.EventBufferSize <= 0 {
+		return DefaultEventBufferSize
+	}
+	return self.EventBufferSize
+}
+
+func (self *Options) getRouterReconnectInitialInterval() time.Duration {
+	if self.RouterReconnectInitialInterval <= 0 {
+		return DefaultRouterReconnectInitialInterval
+	}
+	return self.RouterReconnectInitialInterval
+}
+
+func (self *Options) getMfaPosturePromptLead() time.Duration {
+	if self.MfaPosturePromptLead <= 0 {
+		return DefaultMfaPosturePromptLead
+	}
+	return self.MfaPosturePromptLead
+}
+
+func (self *Options) getAuthQueryTimeout() time.Duration {
+	if self.AuthQueryTimeout <= 0 {
+		return DefaultAuthQueryTimeout
+	}
+	return self.AuthQueryTimeout
+}
+
+func (self *Options) getAuthQueryMaxAttempts() int {
+	if self.AuthQueryMaxAttempts <= 0 {
+		return DefaultAuthQueryMaxAttempts
+	}
+	return self.AuthQueryMaxAttempts
+}
+
+func (self *Options) getRouterReconnectMaxInterval() time.Duration {
+	if self.RouterReconnectMaxInterval <= 0 {
+		return DefaultRouterReconnectMaxInterval
+	}
+	return self.RouterReconnectMaxInterval
+}
+
 var DefaultOptions = &Options{
 	RefreshInterval: 5 * time.Minute,
 	OnServiceUpdate: nil,
@@ -42,6 +166,18 @@ type DialOptions struct {
 	ConnectTimeout time.Duration
 	Identity       string
 	AppData        []byte
+
+	// TraceContext, if set, is the parent of the dial's trace span. If a TracePropagator is configured, its trace
+	// context is sent to the hosting side in the app data, which must be empty or a JSON object for this to work. It is
+	// only used for tracing, its deadline and cancellation don't apply to the dial.
+	TraceContext context.Context
+
+	// ReadQueue configures buffering of received data for the connection. If nil, a small blocking queue is used.
+	ReadQueue *edge.ReadQueueOptions
+
+	// Liveness configures an idle timeout and keepalive probes for the connection. If nil, the connection is only
+	// closed by either side or by its router connection closing. Expired connections emit EventConnectionExpired.
+	Liveness *edge.LivenessOptions
 }
 
 func (d DialOptions) GetConnectTimeout() time.Duration {
@@ -56,6 +192,25 @@ type ListenOptions struct {
 	Identity              string
 	BindUsingEdgeIdentity bool
 	ManualStart           bool
+
+	// ReadQueue configures buffering of received data for each accepted connection. If nil, a small blocking queue
+	// is used.
+	ReadQueue *edge.ReadQueueOptions
+
+	// Liveness configures an idle timeout and keepalive probes for each accepted connection. Expired connections
+	// emit EventConnectionExpired.
+	Liveness *edge.LivenessOptions
+
+	// TraceContext, if set, is the parent of the trace spans of the listener's session creation, router connections
+	// and binds. It is only used for tracing.
+	TraceContext context.Context
+}
+
+func (self *ListenOptions) getTraceContext() context.Context {
+	if self.TraceContext == nil {
+		return context.Background()
+	}
+	return self.TraceContext
 }
 
 func DefaultListenOptions() *ListenOptions {
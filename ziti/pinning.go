@@ -0,0 +1,146 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"strings"
+)
+
+const (
+	PinTargetController = "controller"
+	PinTargetRouter     = "router"
+)
+
+// PinSet restricts which certificates are trusted for a TLS peer, in addition to normal chain verification. Each pin
+// is the base64 encoded SHA-256 hash of a certificate's DER encoded SubjectPublicKeyInfo, as produced by SpkiPin or by
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// A connection is accepted if any certificate in the peer's verified chain matches a pin, so pinning an intermediate or
+// root CA key allows leaf certificates to be rotated freely.
+type PinSet struct {
+	Pins []string `json:"pins"`
+
+	// ReportOnly causes violations to be logged and emitted as EventPinViolation, without failing the connection. It
+	// allows pins to be rolled out and verified before they are enforced.
+	ReportOnly bool `json:"reportOnly,omitempty"`
+}
+
+// PinViolation describes a TLS peer which presented no certificate matching its pin set. When the pin set is enforced
+// it is also the error returned from the TLS handshake.
+type PinViolation struct {
+	// Target is PinTargetController or PinTargetRouter
+	Target string
+
+	// Address is the controller API url or the edge router url
+	Address string
+
+	// RouterName is the name of the edge router, for router violations
+	RouterName string
+
+	// PeerPins are the pins of the certificates presented by the peer, leaf first
+	PeerPins []string
+
+	ReportOnly bool
+}
+
+func (self *PinViolation) Error() string {
+	return fmt.Sprintf("no certificate presented by %s %s matches its pinned public keys (presented: %s)",
+		self.Target, self.Address, strings.Join(self.PeerPins, ", "))
+}
+
+// SpkiPin returns the pin of the given certificate, for use in a PinSet
+func SpkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func (self *PinSet) isEnabled() bool {
+	return self != nil && len(self.Pins) > 0
+}
+
+// verifier returns a tls.Config VerifyConnection callback which checks the peer against the pin set
+func (self *PinSet) verifier(target, address, routerName string, onViolation func(*PinViolation)) func(tls.ConnectionState) error {
+	pins := map[string]struct{}{}
+	for _, pin := range self.Pins {
+		pins[strings.TrimSpace(pin)] = struct{}{}
+	}
+
+	return func(state tls.ConnectionState) error {
+		// only certificates in a verified chain are proven to authenticate the peer, any other certificates it sends
+		// could be public ones copied from elsewhere. When chain verification is skipped only the leaf, whose key is
+		// proven by the handshake, is considered.
+		var certs []*x509.Certificate
+		for _, chain := range state.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+			certs = append(certs, state.PeerCertificates[0])
+		}
+
+		for _, cert := range certs {
+			if _, found := pins[SpkiPin(cert)]; found {
+				return nil
+			}
+		}
+
+		violation := &PinViolation{
+			Target:     target,
+			Address:    address,
+			RouterName: routerName,
+			ReportOnly: self.ReportOnly,
+		}
+		for _, cert := range state.PeerCertificates {
+			violation.PeerPins = append(violation.PeerPins, SpkiPin(cert))
+		}
+
+		log := pfxlog.Logger().WithField("target", target).WithField("address", address)
+		if self.ReportOnly {
+			log.Warn(violation.Error())
+		} else {
+			log.Error(violation.Error())
+		}
+
+		if onViolation != nil {
+			onViolation(violation)
+		}
+
+		if self.ReportOnly {
+			return nil
+		}
+		return violation
+	}
+}
+
+// pinnedIdentity adds pin verification to the client TLS configuration of an identity
+type pinnedIdentity struct {
+	identity.Identity
+	verifyConnection func(tls.ConnectionState) error
+}
+
+func (self *pinnedIdentity) ClientTLSConfig() *tls.Config {
+	result := self.Identity.ClientTLSConfig().Clone()
+	result.VerifyConnection = self.verifyConnection
+	return result
+}
@@ -0,0 +1,149 @@
+package ziti
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PinSet_verifier(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	get := func(pins *PinSet) ([]*PinViolation, error) {
+		var violations []*PinViolation
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+				VerifyConnection: pins.verifier(PinTargetController, server.URL, "", func(violation *PinViolation) {
+					violations = append(violations, violation)
+				}),
+			},
+		}
+		defer transport.CloseIdleConnections()
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return violations, err
+	}
+
+	t.Run("matching pin is accepted", func(t *testing.T) {
+		req := require.New(t)
+		violations, err := get(&PinSet{Pins: []string{"bm90IGEgcGlu", SpkiPin(server.Certificate())}})
+		req.NoError(err)
+		req.Empty(violations)
+	})
+
+	t.Run("mismatched pin is refused", func(t *testing.T) {
+		req := require.New(t)
+		violations, err := get(&PinSet{Pins: []string{"bm90IGEgcGlu"}})
+		req.Error(err)
+		req.Len(violations, 1)
+		req.Equal(PinTargetController, violations[0].Target)
+		req.Equal([]string{SpkiPin(server.Certificate())}, violations[0].PeerPins)
+		req.False(violations[0].ReportOnly)
+	})
+
+	t.Run("mismatched pin is reported in report only mode", func(t *testing.T) {
+		req := require.New(t)
+		violations, err := get(&PinSet{Pins: []string{"bm90IGEgcGlu"}, ReportOnly: true})
+		req.NoError(err)
+		req.Len(violations, 1)
+		req.True(violations[0].ReportOnly)
+	})
+}
+
+func Test_PinSet_verifier_IgnoresUnverifiedCerts(t *testing.T) {
+	req := require.New(t)
+
+	root := newPinTestCert(t, "root", nil)
+	leaf := newPinTestCert(t, "leaf", root)
+	// a certificate unrelated to the verified chain, e.g. a public CA copied by an attacker
+	extra := newPinTestCert(t, "extra", nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.cert.Raw, extra.cert.Raw}, PrivateKey: leaf.key}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.cert)
+
+	get := func(pins *PinSet) error {
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:          pool,
+				VerifyConnection: pins.verifier(PinTargetController, server.URL, "", nil),
+			},
+		}
+		defer transport.CloseIdleConnections()
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	req.Error(get(&PinSet{Pins: []string{SpkiPin(extra.cert)}}))
+	req.NoError(get(&PinSet{Pins: []string{SpkiPin(root.cert)}}))
+	req.NoError(get(&PinSet{Pins: []string{SpkiPin(leaf.cert)}}))
+}
+
+type pinTestCert struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newPinTestCert(t *testing.T, name string, parent *pinTestCert) *pinTestCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	signer, parentCert := key, template
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, parentCert = parent.key, parent.cert
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, signer)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &pinTestCert{key: key, cert: cert}
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	"sort"
+)
+
+// ServicePostureStatus is a snapshot of the posture policies of a service
+type ServicePostureStatus struct {
+	ServiceId   string
+	ServiceName string
+	Policies    []*PolicyPostureStatus
+}
+
+// IsPassing returns true if the controller considers any of the service's posture policies passing, or the service
+// has none
+func (self *ServicePostureStatus) IsPassing() bool {
+	for _, policy := range self.Policies {
+		if policy.IsPassing {
+			return true
+		}
+	}
+	return len(self.Policies) == 0
+}
+
+// PolicyPostureStatus is a snapshot of the posture queries of a service policy. A policy passes when all of its queries
+// pass.
+type PolicyPostureStatus struct {
+	PolicyId   string
+	PolicyType rest_model.DialBind
+	IsPassing  bool
+	Queries    []*posture.QueryStatus
+}
+
+func (context *ContextImpl) PostureStatus() []*ServicePostureStatus {
+	cache := context.CtrlClt.PostureCache
+	cache.Evaluate()
+
+	var result []*ServicePostureStatus
+	context.services.IterCb(func(_ string, svc *rest_model.ServiceDetail) {
+		status := &ServicePostureStatus{}
+		if svc.ID != nil {
+			status.ServiceId = *svc.ID
+		}
+		if svc.Name != nil {
+			status.ServiceName = *svc.Name
+		}
+
+		for _, querySet := range svc.PostureQueries {
+			if querySet == nil {
+				continue
+			}
+
+			policy := &PolicyPostureStatus{
+				PolicyType: querySet.PolicyType,
+			}
+			if querySet.PolicyID != nil {
+				policy.PolicyId = *querySet.PolicyID
+			}
+			if querySet.IsPassing != nil {
+				policy.IsPassing = *querySet.IsPassing
+			}
+
+			for _, query := range querySet.PostureQueries {
+				if query != nil {
+					policy.Queries = append(policy.Queries, cache.QueryStatus(query))
+				}
+			}
+			status.Policies = append(status.Policies, policy)
+		}
+
+		result = append(result, status)
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ServiceName < result[j].ServiceName
+	})
+
+	return result
+}
@@ -0,0 +1,73 @@
+package ziti
+
+import (
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PostureStatus(t *testing.T) {
+	req := require.New(t)
+
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	ztx := &ContextImpl{
+		services: cmap.New[*rest_model.ServiceDetail](),
+		CtrlClt: &CtrlClient{
+			PostureCache: posture.NewCacheWithOptions(nil, closeNotify, &posture.CacheOptions{
+				Simulate: true,
+				Providers: &posture.Providers{
+					Domain: posture.DomainProviderFunc(func() (string, error) {
+						return "corp.example.com", nil
+					}),
+				},
+			}),
+		},
+	}
+
+	domainType := rest_model.PostureCheckTypeDOMAIN
+	domainQuery := &rest_model.PostureQuery{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr("domain")},
+		IsPassing:  ToPtr(false),
+		QueryType:  &domainType,
+	}
+
+	ztx.services.Set("web", &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr("web-id")},
+		Name:       ToPtr("web"),
+		PostureQueries: []*rest_model.PostureQueries{{
+			PolicyID:       ToPtr("policy"),
+			PolicyType:     rest_model.DialBindDial,
+			IsPassing:      ToPtr(false),
+			PostureQueries: []*rest_model.PostureQuery{domainQuery},
+		}},
+	})
+	ztx.services.Set("api", &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr("api-id")},
+		Name:       ToPtr("api"),
+	})
+
+	status := ztx.PostureStatus()
+	req.Len(status, 2)
+
+	req.Equal("api", status[0].ServiceName)
+	req.Empty(status[0].Policies)
+	req.True(status[0].IsPassing())
+
+	web := status[1]
+	req.Equal("web-id", web.ServiceId)
+	req.False(web.IsPassing())
+	req.Len(web.Policies, 1)
+	req.Equal("policy", web.Policies[0].PolicyId)
+	req.Equal(rest_model.DialBindDial, web.Policies[0].PolicyType)
+	req.Len(web.Policies[0].Queries, 1)
+
+	query := web.Policies[0].Queries[0]
+	req.False(query.IsPassing)
+	req.Len(query.Current, 1)
+	req.Equal("corp.example.com", *query.Current[0].(*rest_model.PostureResponseDomainCreate).Domain)
+}
@@ -0,0 +1,365 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"context"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/pkg/errors"
+	metrics2 "github.com/rcrowley/go-metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RouterState string
+
+const (
+	// RouterStateConnecting means a connection attempt to the router is in progress
+	RouterStateConnecting RouterState = "connecting"
+
+	// RouterStateUp means the router is connected and answering latency probes
+	RouterStateUp RouterState = "up"
+
+	// RouterStateDegraded means the router is connected, but its last latency probe timed out
+	RouterStateDegraded RouterState = "degraded"
+
+	// RouterStateDown means the router is not connected and no reconnect is scheduled
+	RouterStateDown RouterState = "down"
+
+	// RouterStateBackoff means the router is not connected and a reconnect is scheduled for RouterInfo.NextAttempt
+	RouterStateBackoff RouterState = "backoff"
+
+	DefaultRouterReconnectInitialInterval = time.Second
+	DefaultRouterReconnectMaxInterval     = time.Minute
+)
+
+var (
+	errRouterConnClosed     = errors.New("router connection closed")
+	errRouterLatencyTimeout = errors.New("latency probe timed out")
+)
+
+// RouterInfo is a point in time snapshot of the state of an edge router known to a context
+type RouterInfo struct {
+	Name           string
+	Url            string
+	State          RouterState
+	StateChangedAt time.Time
+	ConnectedAt    time.Time
+
+	// Failures is the number of consecutive failed connection attempts
+	Failures    int
+	LastError   error
+	NextAttempt time.Time
+
+	// LatencyP50, LatencyP90 and LatencyP99 are computed from the connect time and latency probes of the current
+	// connection. They are zero if the router is not connected.
+	LatencyP50 time.Duration
+	LatencyP90 time.Duration
+	LatencyP99 time.Duration
+}
+
+type pooledRouter struct {
+	name           string
+	url            string
+	state          RouterState
+	stateChangedAt time.Time
+	connectedAt    time.Time
+	failures       int
+	lastError      error
+	nextAttempt    time.Time
+	inSession      bool
+	backoff        *backoff.ExponentialBackOff
+	retry          *time.Timer
+	latency        metrics2.Histogram
+	waiters        []chan *edgeRouterConnResult
+}
+
+func (self *pooledRouter) setState(state RouterState) {
+	if self.state != state {
+		self.state = state
+		self.stateChangedAt = time.Now()
+	}
+}
+
+func (self *pooledRouter) isConnected() bool {
+	return self.state == RouterStateUp || self.state == RouterStateDegraded
+}
+
+func (self *pooledRouter) stopRetry() {
+	if self.retry != nil {
+		self.retry.Stop()
+		self.retry = nil
+	}
+	self.nextAttempt = time.Time{}
+}
+
+// routerPool tracks the state of every edge router used by the sessions of a context. It de-duplicates concurrent
+// connection attempts and reconnects dropped or failed routers with exponential backoff, for as long as they are
+// part of an active session.
+type routerPool struct {
+	context *ContextImpl
+	lock    sync.Mutex
+	routers map[string]*pooledRouter // ingress url -> router
+}
+
+func newRouterPool(context *ContextImpl) *routerPool {
+	return &routerPool{
+		context: context,
+		routers: map[string]*pooledRouter{},
+	}
+}
+
+func (self *routerPool) getOrCreate(name, url string) *pooledRouter {
+	router, found := self.routers[url]
+	if !found {
+		router = &pooledRouter{
+			name:           name,
+			url:            url,
+			state:          RouterStateDown,
+			stateChangedAt: time.Now(),
+			backoff:        self.newBackoff(),
+		}
+		self.routers[url] = router
+	}
+	return router
+}
+
+func (self *routerPool) newBackoff() *backoff.ExponentialBackOff {
+	result := backoff.NewExponentialBackOff()
+	result.InitialInterval = self.context.options.getRouterReconnectInitialInterval()
+	result.MaxInterval = self.context.options.getRouterReconnectMaxInterval()
+	result.MaxElapsedTime = 0
+	result.Reset()
+	return result
+}
+
+// beginConnect returns true if the caller should go ahead and connect to the router. If a connection attempt is
+// already in progress, false is returned and ret, if not nil, is notified when that attempt finishes.
+func (self *routerPool) beginConnect(name, url string, ret chan *edgeRouterConnResult) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	router := self.getOrCreate(name, url)
+	router.inSession = true
+
+	if router.state == RouterStateConnecting {
+		if ret != nil {
+			router.waiters = append(router.waiters, ret)
+		}
+		return false
+	}
+
+	if !router.isConnected() {
+		router.stopRetry()
+		router.setState(RouterStateConnecting)
+	}
+	return true
+}
+
+func (self *routerPool) connectFinished(name, url string, result *edgeRouterConnResult) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	router := self.getOrCreate(name, url)
+	for _, waiter := range router.waiters {
+		select {
+		case waiter <- result:
+		default:
+		}
+	}
+	router.waiters = nil
+
+	if result.err == nil && result.routerConnection != nil {
+		if result.routerConnection.IsClosed() {
+			// the connection closed before its result was handed back. If onClosed has already run, it has scheduled
+			// a reconnect, otherwise it will.
+			if router.state == RouterStateConnecting {
+				router.lastError = errRouterConnClosed
+				self.scheduleReconnect(router)
+			}
+			return
+		}
+
+		if !router.isConnected() {
+			router.setState(RouterStateUp)
+			router.connectedAt = time.Now()
+		}
+		router.failures = 0
+		router.lastError = nil
+		router.backoff.Reset()
+		return
+	}
+
+	router.failures++
+	router.lastError = result.err
+	self.scheduleReconnect(router)
+}
+
+// onConnected records the latency histogram of a newly established router connection
+func (self *routerPool) onConnected(url string, latency metrics2.Histogram) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if router, found := self.routers[url]; found {
+		router.latency = latency
+	}
+}
+
+func (self *routerPool) onClosed(url string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	router, found := self.routers[url]
+	if !found {
+		return
+	}
+
+	router.latency = nil
+	router.connectedAt = time.Time{}
+	router.lastError = errRouterConnClosed
+	router.setState(RouterStateDown)
+	self.scheduleReconnect(router)
+}
+
+func (self *routerPool) onLatencyResult(url string, success bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	router, found := self.routers[url]
+	if !found {
+		return
+	}
+
+	if success && router.state == RouterStateDegraded {
+		router.setState(RouterStateUp)
+	} else if !success && router.state == RouterStateUp {
+		router.lastError = errRouterLatencyTimeout
+		router.setState(RouterStateDegraded)
+	}
+}
+
+// scheduleReconnect must be called with the lock held
+func (self *routerPool) scheduleReconnect(router *pooledRouter) {
+	router.stopRetry()
+
+	if !router.inSession {
+		delete(self.routers, router.url)
+		return
+	}
+
+	if self.context.closed.Load() {
+		router.setState(RouterStateDown)
+		return
+	}
+
+	delay := router.backoff.NextBackOff()
+	router.nextAttempt = time.Now().Add(delay)
+	router.setState(RouterStateBackoff)
+	router.retry = time.AfterFunc(delay, func() {
+		self.reconnect(router.url)
+	})
+
+	pfxlog.Logger().WithField("router", router.name).WithField("url", router.url).WithError(router.lastError).
+		Debugf("reconnecting to router in %v", delay)
+}
+
+func (self *routerPool) reconnect(url string) {
+	self.lock.Lock()
+	router, found := self.routers[url]
+	if !found || router.state != RouterStateBackoff {
+		self.lock.Unlock()
+		return
+	}
+
+	router.retry = nil
+	router.nextAttempt = time.Time{}
+	name := router.name
+
+	if self.context.closed.Load() || self.context.CtrlClt.GetCurrentApiSession() == nil {
+		router.setState(RouterStateDown)
+		self.lock.Unlock()
+		return
+	}
+	self.lock.Unlock()
+
+	self.context.connectEdgeRouter(context.Background(), name, url, nil)
+}
+
+// retain marks the given routers as being part of an active session. Other routers are no longer reconnected, and
+// are forgotten once they are not connected.
+func (self *routerPool) retain(urls map[string]string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for url, router := range self.routers {
+		_, router.inSession = urls[url]
+		if !router.inSession && (router.state == RouterStateDown || router.state == RouterStateBackoff) {
+			router.stopRetry()
+			delete(self.routers, url)
+		}
+	}
+}
+
+// reset forgets all routers and cancels any scheduled reconnects
+func (self *routerPool) reset() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, router := range self.routers {
+		router.stopRetry()
+	}
+	self.routers = map[string]*pooledRouter{}
+}
+
+func (self *routerPool) snapshot() []*RouterInfo {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var result []*RouterInfo
+	for _, router := range self.routers {
+		info := &RouterInfo{
+			Name:           router.name,
+			Url:            router.url,
+			State:          router.state,
+			StateChangedAt: router.stateChangedAt,
+			ConnectedAt:    router.connectedAt,
+			Failures:       router.failures,
+			LastError:      router.lastError,
+			NextAttempt:    router.nextAttempt,
+		}
+
+		if router.latency != nil {
+			percentiles := router.latency.Snapshot().Percentiles([]float64{0.5, 0.9, 0.99})
+			info.LatencyP50 = time.Duration(percentiles[0])
+			info.LatencyP90 = time.Duration(percentiles[1])
+			info.LatencyP99 = time.Duration(percentiles[2])
+		}
+
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name == result[j].Name {
+			return result[i].Url < result[j].Url
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
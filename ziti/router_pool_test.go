@@ -0,0 +1,107 @@
+package ziti
+
+import (
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_routerPool_stateTransitions(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options: &Options{
+			RouterReconnectInitialInterval: time.Hour,
+			RouterReconnectMaxInterval:     time.Hour,
+		},
+	}
+	pool := newRouterPool(ctx)
+	defer pool.reset()
+
+	const url = "tls:er1:3022"
+
+	req.True(pool.beginConnect("er1", url, nil))
+	req.Equal(RouterStateConnecting, pool.snapshot()[0].State)
+
+	// a second attempt while connecting waits for the first one
+	waiter := make(chan *edgeRouterConnResult, 1)
+	req.False(pool.beginConnect("er1", url, waiter))
+
+	connectErr := errors.New("connection refused")
+	pool.connectFinished("er1", url, &edgeRouterConnResult{routerUrl: url, err: connectErr})
+	req.Equal(connectErr, (<-waiter).err)
+
+	info := pool.snapshot()[0]
+	req.Equal(RouterStateBackoff, info.State)
+	req.Equal(1, info.Failures)
+	req.Equal(connectErr, info.LastError)
+	req.True(info.NextAttempt.After(time.Now()))
+
+	// a dial doesn't wait for the backoff to expire
+	req.True(pool.beginConnect("er1", url, nil))
+	req.True(pool.snapshot()[0].NextAttempt.IsZero())
+	pool.connectFinished("er1", url, &edgeRouterConnResult{routerUrl: url, routerConnection: &testRouterConn{}})
+
+	info = pool.snapshot()[0]
+	req.Equal(RouterStateUp, info.State)
+	req.Equal(0, info.Failures)
+	req.NoError(info.LastError)
+	req.False(info.ConnectedAt.IsZero())
+
+	pool.onLatencyResult(url, false)
+	req.Equal(RouterStateDegraded, pool.snapshot()[0].State)
+	pool.onLatencyResult(url, true)
+	req.Equal(RouterStateUp, pool.snapshot()[0].State)
+
+	pool.onClosed(url)
+	info = pool.snapshot()[0]
+	req.Equal(RouterStateBackoff, info.State)
+	req.Equal(errRouterConnClosed, info.LastError)
+
+	// routers no longer in any session are forgotten once disconnected
+	pool.retain(map[string]string{})
+	req.Empty(pool.snapshot())
+}
+
+func Test_routerPool_closedBeforeConnectFinished(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options: &Options{
+			RouterReconnectInitialInterval: time.Hour,
+			RouterReconnectMaxInterval:     time.Hour,
+		},
+	}
+	pool := newRouterPool(ctx)
+	defer pool.reset()
+
+	const url = "tls:er1:3022"
+
+	req.True(pool.beginConnect("er1", url, nil))
+
+	// the connection closes while its connect result is still being handed back
+	pool.onClosed(url)
+	pool.connectFinished("er1", url, &edgeRouterConnResult{routerUrl: url, routerConnection: &testRouterConn{closed: true}})
+
+	info := pool.snapshot()[0]
+	req.Equal(RouterStateBackoff, info.State)
+	req.Equal(errRouterConnClosed, info.LastError)
+	req.True(info.ConnectedAt.IsZero())
+	req.True(info.NextAttempt.After(time.Now()))
+
+	// a closed connection which is handed back before onClosed runs doesn't mark the router up either
+	req.True(pool.beginConnect("er1", url, nil))
+	pool.connectFinished("er1", url, &edgeRouterConnResult{routerUrl: url, routerConnection: &testRouterConn{closed: true}})
+	req.Equal(RouterStateBackoff, pool.snapshot()[0].State)
+}
+
+type testRouterConn struct {
+	edge.RouterConn
+	closed bool
+}
+
+func (self *testRouterConn) IsClosed() bool {
+	return self.closed
+}
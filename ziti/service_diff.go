@@ -0,0 +1,180 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"github.com/openziti/edge-api/rest_model"
+	"reflect"
+	"sort"
+)
+
+// ServiceDiff describes how a service definition changed between two service refreshes. Config types and posture
+// query ids are sorted.
+type ServiceDiff struct {
+	PermissionsGained []rest_model.DialBind
+	PermissionsLost   []rest_model.DialBind
+
+	// ConfigsAdded, ConfigsRemoved and ConfigsChanged hold the config types whose config was added, removed or modified
+	ConfigsAdded   []string
+	ConfigsRemoved []string
+	ConfigsChanged []string
+
+	// PostureQueriesAdded, PostureQueriesRemoved and PostureQueriesChanged hold posture query ids. A query changes
+	// when its definition or whether it is passing changes.
+	PostureQueriesAdded   []string
+	PostureQueriesRemoved []string
+	PostureQueriesChanged []string
+
+	// PosturePoliciesPassingChanged holds the ids of posture policies which started or stopped passing
+	PosturePoliciesPassingChanged []string
+
+	EncryptionRequiredChanged bool
+
+	// OtherChanged is set if any other part of the service changed, such as its name, terminator strategy, role
+	// attributes or tags
+	OtherChanged bool
+}
+
+// HasChanges returns true if anything differs between the service definitions
+func (self *ServiceDiff) HasChanges() bool {
+	return len(self.PermissionsGained) > 0 || len(self.PermissionsLost) > 0 ||
+		len(self.ConfigsAdded) > 0 || len(self.ConfigsRemoved) > 0 || len(self.ConfigsChanged) > 0 ||
+		len(self.PostureQueriesAdded) > 0 || len(self.PostureQueriesRemoved) > 0 || len(self.PostureQueriesChanged) > 0 ||
+		len(self.PosturePoliciesPassingChanged) > 0 || self.EncryptionRequiredChanged || self.OtherChanged
+}
+
+// GainedPermission returns true if the service can now be dialed or bound, where it couldn't before
+func (self *ServiceDiff) GainedPermission(permission rest_model.DialBind) bool {
+	return containsPermission(self.PermissionsGained, permission)
+}
+
+// LostPermission returns true if the service can no longer be dialed or bound
+func (self *ServiceDiff) LostPermission(permission rest_model.DialBind) bool {
+	return containsPermission(self.PermissionsLost, permission)
+}
+
+func containsPermission(permissions []rest_model.DialBind, permission rest_model.DialBind) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffServices compares two definitions of the same service. Values which change on every refresh without the
+// service changing, such as the time remaining on posture query timeouts, are ignored.
+func DiffServices(previous, current *rest_model.ServiceDetail) *ServiceDiff {
+	diff := &ServiceDiff{}
+
+	diff.PermissionsGained = permissionsMissingFrom(previous.Permissions, current.Permissions)
+	diff.PermissionsLost = permissionsMissingFrom(current.Permissions, previous.Permissions)
+
+	diff.ConfigsAdded, diff.ConfigsRemoved, diff.ConfigsChanged = diffKeyed(previous.Config, current.Config)
+
+	previousQueries, previousPassing := indexPostureQueries(previous.PostureQueries)
+	currentQueries, currentPassing := indexPostureQueries(current.PostureQueries)
+	diff.PostureQueriesAdded, diff.PostureQueriesRemoved, diff.PostureQueriesChanged = diffKeyed(previousQueries, currentQueries)
+
+	for policyId, passing := range currentPassing {
+		if previous, found := previousPassing[policyId]; found && previous != passing {
+			diff.PosturePoliciesPassingChanged = append(diff.PosturePoliciesPassingChanged, policyId)
+		}
+	}
+	sort.Strings(diff.PosturePoliciesPassingChanged)
+
+	diff.EncryptionRequiredChanged = boolValue(previous.EncryptionRequired) != boolValue(current.EncryptionRequired)
+
+	diff.OtherChanged = !reflect.DeepEqual(withoutDiffedFields(previous), withoutDiffedFields(current))
+
+	return diff
+}
+
+// permissionsMissingFrom returns the permissions in b which are not in a
+func permissionsMissingFrom(a, b rest_model.DialBindArray) []rest_model.DialBind {
+	var result []rest_model.DialBind
+	for _, permission := range b {
+		if !containsPermission(a, permission) && !containsPermission(result, permission) {
+			result = append(result, permission)
+		}
+	}
+	return result
+}
+
+func diffKeyed[T any](previous, current map[string]T) (added, removed, changed []string) {
+	for key, currentValue := range current {
+		if previousValue, found := previous[key]; !found {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(previousValue, currentValue) {
+			changed = append(changed, key)
+		}
+	}
+
+	for key := range previous {
+		if _, found := current[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return
+}
+
+// indexPostureQueries returns posture queries by id, with their remaining timeout cleared, and whether each posture
+// policy is passing
+func indexPostureQueries(querySets []*rest_model.PostureQueries) (map[string]rest_model.PostureQuery, map[string]bool) {
+	queries := map[string]rest_model.PostureQuery{}
+	passing := map[string]bool{}
+
+	for _, querySet := range querySets {
+		if querySet == nil {
+			continue
+		}
+
+		if querySet.PolicyID != nil {
+			passing[*querySet.PolicyID] = boolValue(querySet.IsPassing)
+		}
+
+		for _, query := range querySet.PostureQueries {
+			if query == nil || query.ID == nil {
+				continue
+			}
+			q := *query
+			q.TimeoutRemaining = nil
+			queries[*query.ID] = q
+		}
+	}
+
+	return queries, passing
+}
+
+func withoutDiffedFields(service *rest_model.ServiceDetail) rest_model.ServiceDetail {
+	result := *service
+	result.Permissions = nil
+	result.Config = nil
+	result.Configs = nil
+	result.PostureQueries = nil
+	result.EncryptionRequired = nil
+	result.Links = nil
+	return result
+}
+
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
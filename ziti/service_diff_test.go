@@ -0,0 +1,86 @@
+package ziti
+
+import (
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DiffServices(t *testing.T) {
+	newService := func() *rest_model.ServiceDetail {
+		return &rest_model.ServiceDetail{
+			BaseEntity:         rest_model.BaseEntity{ID: ToPtr("svc-id")},
+			Name:               ToPtr("svc"),
+			EncryptionRequired: ToPtr(true),
+			Permissions:        rest_model.DialBindArray{rest_model.DialBindDial},
+			Config: map[string]map[string]interface{}{
+				"intercept.v1": {"addresses": []interface{}{"svc.ziti"}},
+				"host.v1":      {"port": 80},
+			},
+			PostureQueries: []*rest_model.PostureQueries{{
+				PolicyID:  ToPtr("policy1"),
+				IsPassing: ToPtr(true),
+				PostureQueries: []*rest_model.PostureQuery{{
+					BaseEntity:       rest_model.BaseEntity{ID: ToPtr("mfa1")},
+					IsPassing:        ToPtr(true),
+					Timeout:          ToPtr(int64(300)),
+					TimeoutRemaining: ToPtr(int64(200)),
+				}},
+			}},
+		}
+	}
+
+	t.Run("identical services have no changes", func(t *testing.T) {
+		req := require.New(t)
+		current := newService()
+		current.PostureQueries[0].PostureQueries[0].TimeoutRemaining = ToPtr(int64(100))
+		req.False(DiffServices(newService(), current).HasChanges())
+	})
+
+	t.Run("permission changes are reported", func(t *testing.T) {
+		req := require.New(t)
+		current := newService()
+		current.Permissions = rest_model.DialBindArray{rest_model.DialBindBind}
+		diff := DiffServices(newService(), current)
+		req.True(diff.HasChanges())
+		req.True(diff.GainedPermission(rest_model.DialBindBind))
+		req.True(diff.LostPermission(rest_model.DialBindDial))
+		req.False(diff.OtherChanged)
+	})
+
+	t.Run("config changes are reported by type", func(t *testing.T) {
+		req := require.New(t)
+		current := newService()
+		delete(current.Config, "host.v1")
+		current.Config["intercept.v1"] = map[string]interface{}{"addresses": []interface{}{"other.ziti"}}
+		current.Config["client.v1"] = map[string]interface{}{}
+		diff := DiffServices(newService(), current)
+		req.Equal([]string{"client.v1"}, diff.ConfigsAdded)
+		req.Equal([]string{"host.v1"}, diff.ConfigsRemoved)
+		req.Equal([]string{"intercept.v1"}, diff.ConfigsChanged)
+	})
+
+	t.Run("posture and encryption changes are reported", func(t *testing.T) {
+		req := require.New(t)
+		current := newService()
+		current.EncryptionRequired = ToPtr(false)
+		current.PostureQueries[0].IsPassing = ToPtr(false)
+		current.PostureQueries[0].PostureQueries[0].IsPassing = ToPtr(false)
+		diff := DiffServices(newService(), current)
+		req.True(diff.EncryptionRequiredChanged)
+		req.Equal([]string{"policy1"}, diff.PosturePoliciesPassingChanged)
+		req.Equal([]string{"mfa1"}, diff.PostureQueriesChanged)
+		req.Empty(diff.PostureQueriesAdded)
+		req.False(diff.OtherChanged)
+	})
+
+	t.Run("other changes are reported", func(t *testing.T) {
+		req := require.New(t)
+		current := newService()
+		current.TerminatorStrategy = ToPtr("random")
+		diff := DiffServices(newService(), current)
+		req.True(diff.OtherChanged)
+		req.True(diff.HasChanges())
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"crypto"
 	"crypto/dsa" //nolint:staticcheck
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/asn1"
 	"encoding/binary"
 	"github.com/google/uuid"
 	"github.com/michaelquigley/pfxlog"
@@ -15,11 +17,19 @@ import (
 )
 
 const (
-	Format1Rsa   byte = 1
-	Format2Dsa   byte = 2
-	Format3Ecdsa byte = 3
+	Format1Rsa byte = 1
+
+	// Deprecated: DSA is insecure and deprecated by the Go standard library. Identity secrets signed with DSA are
+	// still produced and verified for existing keys, but new identities should use ECDSA, Ed25519 or RSA keys.
+	Format2Dsa byte = 2
+
+	Format3Ecdsa   byte = 3
+	Format4Ed25519 byte = 4
 )
 
+// AssertIdentityWithSecret signs a random nonce with the given private key, so that the holder of the matching public
+// key can verify the identity. The key may be any crypto.Signer with an RSA, ECDSA or Ed25519 public key, including
+// signers which keep the key outside the process. Support for *dsa.PrivateKey is deprecated.
 func AssertIdentityWithSecret(privateKey interface{}) ([]byte, error) {
 	var result []byte
 	nonceUUID := uuid.New()
@@ -28,18 +38,8 @@ func AssertIdentityWithSecret(privateKey interface{}) ([]byte, error) {
 	_, _ = hashF.Write(nonce)
 	nonce = hashF.Sum(nil)
 
-	if rsaPk, ok := privateKey.(*rsa.PrivateKey); ok {
-		result = append(result, Format1Rsa)
-		result = appendSizedSlice(result, nonce)
-		signature, err := rsa.SignPSS(rand.Reader, rsaPk, crypto.SHA512, nonce, nil)
-		if err != nil {
-			return nil, err
-		}
-		result = appendSizedSlice(result, signature)
-		return result, nil
-	}
-
 	if dsaPk, ok := privateKey.(*dsa.PrivateKey); ok {
+		pfxlog.Logger().Warn("signing identity secret with a deprecated DSA key, use an ECDSA, Ed25519 or RSA key instead")
 		result = append(result, Format2Dsa)
 		result = appendSizedSlice(result, nonce)
 		r, s, err := dsa.Sign(rand.Reader, dsaPk, nonce[:])
@@ -51,19 +51,52 @@ func AssertIdentityWithSecret(privateKey interface{}) ([]byte, error) {
 		return result, nil
 	}
 
-	if ecdsaPk, ok := privateKey.(*ecdsa.PrivateKey); ok {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unhandled private key type %v", reflect.TypeOf(privateKey))
+	}
+
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		result = append(result, Format1Rsa)
+		result = appendSizedSlice(result, nonce)
+		signature, err := signer.Sign(rand.Reader, nonce, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA512})
+		if err != nil {
+			return nil, err
+		}
+		result = appendSizedSlice(result, signature)
+		return result, nil
+	case *ecdsa.PublicKey:
 		result = append(result, Format3Ecdsa)
 		result = appendSizedSlice(result, nonce)
-		r, s, err := ecdsa.Sign(rand.Reader, ecdsaPk, nonce[:])
+		signature, err := signer.Sign(rand.Reader, nonce, crypto.SHA512)
 		if err != nil {
 			return nil, err
 		}
-		result = appendSizedSlice(result, r.Bytes())
-		result = appendSizedSlice(result, s.Bytes())
+
+		// signers return ASN.1 encoded signatures, the secret carries the r and s components
+		ecdsaSignature := &struct {
+			R, S *big.Int
+		}{}
+		if _, err = asn1.Unmarshal(signature, ecdsaSignature); err != nil {
+			return nil, errors.Wrap(err, "could not decode ecdsa signature")
+		}
+		result = appendSizedSlice(result, ecdsaSignature.R.Bytes())
+		result = appendSizedSlice(result, ecdsaSignature.S.Bytes())
+		return result, nil
+	case ed25519.PublicKey:
+		result = append(result, Format4Ed25519)
+		result = appendSizedSlice(result, nonce)
+		// Ed25519 signs the message itself, which is the hashed nonce, rather than a digest of it
+		signature, err := signer.Sign(rand.Reader, nonce, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		result = appendSizedSlice(result, signature)
 		return result, nil
 	}
 
-	return nil, errors.Errorf("unhandled private key type %v", reflect.TypeOf(privateKey))
+	return nil, errors.Errorf("unhandled public key type %v of signer %v", reflect.TypeOf(signer.Public()), reflect.TypeOf(privateKey))
 }
 
 func GetVerifier(val []byte) (Verifier, error) {
@@ -139,6 +172,20 @@ func GetVerifier(val []byte) (Verifier, error) {
 		}, nil
 	}
 
+	if secretType == Format4Ed25519 {
+		signature, val, err := consumeBytesValue("signature", val)
+		if err != nil {
+			return nil, err
+		}
+		if len(val) != 0 {
+			return nil, errors.Errorf("encoding error: still %v unconsumed bytes remaining in identity secret", len(val))
+		}
+		return &ed25519Verifier{
+			nonce:     nonce,
+			signature: signature,
+		}, nil
+	}
+
 	return nil, errors.Errorf("unsupported identity secret format %v", secretType)
 }
 
@@ -187,6 +234,19 @@ func (v *ecdsaVerifier) Verify(publicKey interface{}) bool {
 	return false
 }
 
+type ed25519Verifier struct {
+	nonce     []byte
+	signature []byte
+}
+
+func (v *ed25519Verifier) Verify(publicKey interface{}) bool {
+	if ed25519PubKey, ok := publicKey.(ed25519.PublicKey); ok {
+		return len(ed25519PubKey) == ed25519.PublicKeySize && ed25519.Verify(ed25519PubKey, v.nonce, v.signature)
+	}
+	pfxlog.Logger().Warnf("incorrect public key type. expected ed25519.PublicKey, but was %v", reflect.TypeOf(publicKey))
+	return false
+}
+
 func appendSizedSlice(slice []byte, val []byte) []byte {
 	size := len(val)
 	sizeBuf := make([]byte, 4)
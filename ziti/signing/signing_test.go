@@ -1,11 +1,15 @@
 package signing
 
 import (
+	"crypto"
+	"crypto/dsa" //nolint:staticcheck
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"github.com/stretchr/testify/require"
+	"io"
 	"testing"
 )
 
@@ -24,6 +28,88 @@ func Test_SignAndVerifyEcdsa(t *testing.T) {
 	testKeyPair(t, key, key.Public())
 }
 
+func Test_SignAndVerifyRsaSigner(t *testing.T) {
+	req := require.New(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	req.NoError(err)
+	testKeyPair(t, &opaqueSigner{signer: key}, key.Public())
+}
+
+func Test_SignAndVerifyEcdsaSigner(t *testing.T) {
+	req := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	testKeyPair(t, &opaqueSigner{signer: key}, key.Public())
+}
+
+func Test_SignAndVerifyEd25519(t *testing.T) {
+	req := require.New(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+	testKeyPair(t, privateKey, publicKey)
+
+	sig, err := AssertIdentityWithSecret(privateKey)
+	req.NoError(err)
+	req.Equal(Format4Ed25519, sig[0])
+}
+
+func Test_SignAndVerifyEd25519Signer(t *testing.T) {
+	req := require.New(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+	testKeyPair(t, &opaqueSigner{signer: privateKey}, publicKey)
+}
+
+func Test_VerifyEd25519WithWrongKey(t *testing.T) {
+	req := require.New(t)
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	sig, err := AssertIdentityWithSecret(privateKey)
+	req.NoError(err)
+
+	verifier, err := GetVerifier(sig)
+	req.NoError(err)
+	req.False(verifier.Verify(otherPublicKey))
+	req.False(verifier.Verify(ecdsaKey.Public()))
+
+	_, err = GetVerifier(append(sig, 0))
+	req.Error(err)
+}
+
+func Test_SignAndVerifyDsa(t *testing.T) {
+	req := require.New(t)
+	key := &dsa.PrivateKey{}
+	req.NoError(dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160))
+	req.NoError(dsa.GenerateKey(key, rand.Reader))
+	testKeyPair(t, key, &key.PublicKey)
+}
+
+func Test_SignWithUnsupportedKey(t *testing.T) {
+	req := require.New(t)
+	_, err := AssertIdentityWithSecret("not a key")
+	req.Error(err)
+}
+
+// opaqueSigner hides the key type, like a signer backed by an external process
+type opaqueSigner struct {
+	signer crypto.Signer
+}
+
+func (self *opaqueSigner) Public() crypto.PublicKey {
+	return self.signer.Public()
+}
+
+func (self *opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return self.signer.Sign(rand, digest, opts)
+}
+
 func testKeyPair(t *testing.T, privateKey interface{}, publicKey interface{}) {
 	req := require.New(t)
 	sig, err := AssertIdentityWithSecret(privateKey)
@@ -17,6 +17,7 @@
 package ziti
 
 import (
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	"net/url"
@@ -38,6 +39,9 @@ type Versions struct {
 type EnrollmentClaims struct {
 	EnrollmentMethod string            `json:"em"`
 	SignatureCert    *x509.Certificate `json:"-"`
+
+	// SignatureKey is the public key which verified the signature of the token
+	SignatureKey crypto.PublicKey `json:"-"`
 	jwt.StandardClaims
 }
 
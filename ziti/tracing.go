@@ -0,0 +1,76 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import "context"
+
+const (
+	OperationAuthenticate  = "ziti.authenticate"
+	OperationSessionCreate = "ziti.session.create"
+	OperationRouterConnect = "ziti.router.connect"
+	OperationDial          = "ziti.dial"
+	OperationBind          = "ziti.bind"
+
+	TraceAttrService     = "ziti.service"
+	TraceAttrSessionType = "ziti.session.type"
+	TraceAttrRouter      = "ziti.router"
+	TraceAttrRouterUrl   = "ziti.router.url"
+	TraceAttrConnId      = "ziti.conn.id"
+)
+
+// Tracer is notified when a context starts an operation against the controller or an edge router, such as
+// authenticating, creating a service session, connecting to a router, dialing or binding. It is set via
+// Options.Tracer. The observability package provides an OpenTelemetry implementation.
+type Tracer interface {
+	// StartOperation begins tracing the named operation. The returned context carries the operation, so that
+	// operations started with it are recorded as its children.
+	StartOperation(ctx context.Context, operation string) (context.Context, OperationSpan)
+}
+
+// OperationSpan represents a single traced operation. End must be called exactly once.
+type OperationSpan interface {
+	SetAttribute(key, value string)
+	End(err error)
+}
+
+// TracePropagator carries trace context across a Ziti connection. On dial, Inject writes the trace context of the
+// DialOptions.TraceContext into a carrier which is sent in the dial's app data. On accept, Extract turns the received
+// carrier back into a context, available from accepted connections through edge.TraceContextProvider. It is set via
+// Options.TracePropagator. The observability package provides an OpenTelemetry implementation.
+type TracePropagator interface {
+	Inject(ctx context.Context, carrier map[string]string)
+	Extract(ctx context.Context, carrier map[string]string) context.Context
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartOperation(ctx context.Context, _ string) (context.Context, OperationSpan) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+
+func (noopSpan) End(error) {}
+
+func (self *Options) getTracer() Tracer {
+	if self.Tracer == nil {
+		return noopTracer{}
+	}
+	return self.Tracer
+}
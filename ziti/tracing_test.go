@@ -0,0 +1,79 @@
+package ziti
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpanKey struct{}
+
+type recordedSpan struct {
+	operation string
+	parent    *recordedSpan
+	ended     bool
+}
+
+func (self *recordedSpan) SetAttribute(string, string) {}
+
+func (self *recordedSpan) End(error) {
+	self.ended = true
+}
+
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (self *recordingTracer) StartOperation(ctx context.Context, operation string) (context.Context, OperationSpan) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	span := &recordedSpan{operation: operation, parent: parent}
+
+	self.lock.Lock()
+	self.spans = append(self.spans, span)
+	self.lock.Unlock()
+
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func Test_connectEdgeRouter_tracesAsChild(t *testing.T) {
+	req := require.New(t)
+
+	tracer := &recordingTracer{}
+	ztx := &ContextImpl{
+		options:           &Options{Tracer: tracer},
+		routerConnections: cmap.New[edge.RouterConn](),
+	}
+	ztx.routerPool = newRouterPool(ztx)
+	defer ztx.routerPool.reset()
+
+	const url = "tls:er1:3022"
+	ztx.routerConnections.Set(url, &testRouterConn{})
+
+	dialCtx, dialSpan := tracer.StartOperation(context.Background(), OperationDial)
+
+	result := make(chan *edgeRouterConnResult, 1)
+	ztx.connectEdgeRouter(dialCtx, "er1", url, result)
+	req.NotNil((<-result).routerConnection)
+
+	req.Len(tracer.spans, 2)
+	connectSpan := tracer.spans[1]
+	req.Equal(OperationRouterConnect, connectSpan.operation)
+	req.Same(dialSpan, connectSpan.parent)
+	req.True(connectSpan.ended)
+}
+
+func Test_ListenOptions_traceContext(t *testing.T) {
+	req := require.New(t)
+
+	options := DefaultListenOptions()
+	req.Equal(context.Background(), options.getTraceContext())
+
+	traceCtx := context.WithValue(context.Background(), recordedSpanKey{}, &recordedSpan{})
+	options.TraceContext = traceCtx
+	req.Equal(traceCtx, options.getTraceContext())
+}
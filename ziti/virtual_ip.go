@@ -0,0 +1,85 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"net"
+	"sync"
+)
+
+// hostnameLookups holds the lookups DialAddr uses to map virtual IPs back to hostnames. The zero value is ready to use.
+type hostnameLookups struct {
+	lock          sync.Mutex
+	registrations []*hostnameLookupRegistration
+}
+
+// hostnameLookupRegistration identifies a registration, as lookups aren't comparable
+type hostnameLookupRegistration struct {
+	lookup func(ip net.IP) (string, bool)
+}
+
+func (self *hostnameLookups) register(lookup func(ip net.IP) (string, bool)) func() {
+	registration := &hostnameLookupRegistration{lookup: lookup}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.registrations = append(self.registrations, registration)
+
+	return func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		for i, current := range self.registrations {
+			if current == registration {
+				self.registrations = append(self.registrations[:i:i], self.registrations[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// resolve returns the hostname the given host was handed out for if it is a virtual IP, or the host itself otherwise
+func (self *hostnameLookups) resolve(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	self.lock.Lock()
+	registrations := self.registrations
+	self.lock.Unlock()
+
+	for _, registration := range registrations {
+		if hostname, found := registration.lookup(ip); found {
+			return hostname
+		}
+	}
+	return host
+}
+
+// RegisterHostnameLookup registers a lookup, such as dns.Server.LookupHostname, which DialAddr uses to map virtual
+// IPs back to the hostnames they were handed out for, so that addresses returned by a resolver can be dialed. The
+// returned func removes the lookup.
+func (context *ContextImpl) RegisterHostnameLookup(lookup func(ip net.IP) (string, bool)) func() {
+	return context.hostnameLookups.register(lookup)
+}
+
+// RegisterHostnameLookup registers a lookup with the collection. DialAddr maps virtual IPs back to their hostnames
+// before matching intercepts, so one lookup covers the services of every context in the collection. The returned
+// func removes the lookup.
+func (set *CtxCollection) RegisterHostnameLookup(lookup func(ip net.IP) (string, bool)) func() {
+	return set.hostnameLookups.register(lookup)
+}
@@ -17,7 +17,7 @@
 package ziti
 
 import (
-	"encoding/json"
+	ctx "context"
 	"fmt"
 	"github.com/go-openapi/strfmt"
 	"github.com/kataras/go-events"
@@ -27,7 +27,6 @@ import (
 	apis "github.com/openziti/sdk-golang/edge-apis"
 	"math"
 	"net"
-	"reflect"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -116,6 +115,10 @@ type Context interface {
 	// GetServiceForAddr finds the service with intercept that matches best to given address
 	GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error)
 
+	// GetServiceForHostname finds the service with the intercept address that best matches the given hostname, on any
+	// protocol and port. False is returned if no intercept matches.
+	GetServiceForHostname(hostname string) (*rest_model.ServiceDetail, bool)
+
 	// RefreshServices forces the context to refresh the list of services the current authenticating identity has access
 	// to.
 	RefreshServices() error
@@ -131,6 +134,19 @@ type Context interface {
 	// Metrics will return the current context's metrics Registry.
 	Metrics() metrics.Registry
 
+	// Routers returns a snapshot of the state of the edge routers used by the context's sessions, including latency
+	// percentiles and the last connection error of each.
+	Routers() []*RouterInfo
+
+	// Connections returns a snapshot of all open dial, bind and accepted connections across all edge router
+	// connections. It is useful for seeing what a process is doing and for finding leaked connections.
+	Connections() []*edge.ConnInfo
+
+	// PostureStatus returns the posture policies of each service, whether the controller considers them passing, and
+	// the posture data last submitted, and currently collected, for each of their queries. Posture data is refreshed
+	// first. Passing states are those of the last service refresh.
+	PostureStatus() []*ServicePostureStatus
+
 	// Close closes any connections open to edge routers
 	Close()
 
@@ -138,6 +154,12 @@ type Context interface {
 	// Replaced with event functionality. Use `zitiContext.AddListener(MfaTotpCode, handler)` instead.
 	AddZitiMfaHandler(handler func(query *rest_model.AuthQueryDetail, resp MfaCodeResponse) error)
 
+	// RegisterAuthQueryHandler registers a handler answering auth queries of the given provider and format during
+	// authentication. Handlers registered with an empty format answer queries of any format of the provider. Handlers
+	// are bounded by Options.AuthQueryTimeout and Options.AuthQueryMaxAttempts. The returned function unregisters the
+	// handler.
+	RegisterAuthQueryHandler(provider rest_model.MfaProviders, format rest_model.MfaFormats, handler AuthQueryHandler) func()
+
 	// EnrollZitiMfa will attempt to enable TOTP 2FA on the currently authenticating identity if not already enrolled.
 	EnrollZitiMfa() (*rest_model.DetailMfa, error)
 
@@ -147,6 +169,13 @@ type Context interface {
 	// RemoveZitiMfa will attempt to remove TOTP 2FA for the current identity
 	RemoveZitiMfa(code string) error
 
+	// NewZitiMfaRecoveryCodes will replace the recovery codes of the current identity's TOTP 2FA. The previous codes
+	// can no longer be used. Read the new codes with GetZitiMfaRecoveryCodes.
+	NewZitiMfaRecoveryCodes(code string) error
+
+	// GetZitiMfaRecoveryCodes returns the recovery codes of the current identity's TOTP 2FA
+	GetZitiMfaRecoveryCodes(code string) ([]string, error)
+
 	// GetId returns a unique context id
 	GetId() string
 
@@ -162,6 +191,9 @@ type ContextImpl struct {
 	options           *Options
 	Id                string
 	routerConnections cmap.ConcurrentMap[string, edge.RouterConn]
+	routerPool        *routerPool
+	routerPins        *PinSet
+	eventBus          *eventBus
 
 	CtrlClt *CtrlClient
 
@@ -169,13 +201,21 @@ type ContextImpl struct {
 	sessions   cmap.ConcurrentMap[string, *rest_model.SessionDetail] // svcID:type -> Session
 	intercepts cmap.ConcurrentMap[string, *edge.InterceptV1Config]
 
+	interceptIndex atomic.Pointer[edge.InterceptIndex]
+
+	serviceConfigs cmap.ConcurrentMap[string, map[string]interface{}] // name -> config type -> parsed config
+	configRegistry *configRegistry                                    // nil uses defaultConfigRegistry
+
+	mfaPosture mfaPostureState
+
 	metrics metrics.Registry
 
 	firstAuthOnce sync.Once
 
 	closed            atomic.Bool
 	closeNotify       chan struct{}
-	authQueryHandlers map[string]func(query *rest_model.AuthQueryDetail, response MfaCodeResponse) error
+	authQueryHandlers *authQueryRegistry
+	hostnameLookups   hostnameLookups
 
 	events.EventEmmiter
 }
@@ -294,6 +334,101 @@ func (context *ContextImpl) AddRouterDisconnectedListener(handler func(Context,
 	}
 }
 
+func (context *ContextImpl) AddConnectionExpiredListener(handler func(Context, *edge.ConnInfo, error)) func() {
+	listener := func(args ...interface{}) {
+		conn, ok := args[0].(*edge.ConnInfo)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", conn, args[0])
+		}
+
+		reason, ok := args[1].(error)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[1] to %T was %T", reason, args[1])
+		}
+
+		handler(context, conn, reason)
+	}
+
+	context.AddListener(EventConnectionExpired, listener)
+
+	return func() {
+		context.RemoveListener(EventConnectionExpired, listener)
+	}
+}
+
+func (context *ContextImpl) AddPinViolationListener(handler func(Context, *PinViolation)) func() {
+	listener := func(args ...interface{}) {
+		violation, ok := args[0].(*PinViolation)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", violation, args[0])
+		}
+
+		handler(context, violation)
+	}
+
+	context.AddListener(EventPinViolation, listener)
+
+	return func() {
+		context.RemoveListener(EventPinViolation, listener)
+	}
+}
+
+func (context *ContextImpl) AddServiceConfigInvalidListener(handler func(Context, *rest_model.ServiceDetail, string, error)) func() {
+	listener := func(args ...interface{}) {
+		details, ok := args[0].(*rest_model.ServiceDetail)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", details, args[0])
+		}
+
+		configType, ok := args[1].(string)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[1] to %T was %T", configType, args[1])
+		}
+
+		err, ok := args[2].(error)
+
+		if !ok {
+			pfxlog.Logger().Fatalf("could not convert args[2] to %T was %T", err, args[2])
+		}
+
+		handler(context, details, configType, err)
+	}
+
+	context.AddListener(EventServiceConfigInvalid, listener)
+
+	return func() {
+		context.RemoveListener(EventServiceConfigInvalid, listener)
+	}
+}
+
+func (context *ContextImpl) onPinViolation(violation *PinViolation) {
+	context.publish(&PinViolationEvent{Violation: violation})
+}
+
+// livenessOptions returns a copy of the given options which also emits EventConnectionExpired when a connection
+// expires
+func (context *ContextImpl) livenessOptions(options *edge.LivenessOptions) *edge.LivenessOptions {
+	if !options.IsEnabled() {
+		return nil
+	}
+
+	result := *options
+	result.OnExpired = func(conn edge.Conn, reason error) {
+		if provider, ok := conn.(connInfoProvider); ok {
+			context.publish(&ConnectionExpiredEvent{Conn: provider.GetConnInfo(), Reason: reason})
+		}
+		if options.OnExpired != nil {
+			options.OnExpired(conn, reason)
+		}
+	}
+	return &result
+}
+
 func (context *ContextImpl) AddMfaTotpCodeListener(handler func(Context, *rest_model.AuthQueryDetail, MfaCodeResponse)) func() {
 	listener := func(args ...interface{}) {
 		authQuery, ok := args[0].(*rest_model.AuthQueryDetail)
@@ -444,8 +579,15 @@ func (context *ContextImpl) Sessions() ([]*rest_model.SessionDetail, error) {
 
 func (context *ContextImpl) OnClose(routerConn edge.RouterConn) {
 	logrus.Debugf("connection to router [%s] was closed", routerConn.Key())
-	context.Emit(EventRouterDisconnected, routerConn.GetRouterName(), routerConn.Key())
-	context.routerConnections.Remove(routerConn.Key())
+	context.publish(&RouterDisconnectedEvent{Name: routerConn.GetRouterName(), Address: routerConn.Key()})
+
+	// a duplicate connection may be closed in favour of one already in use, which should be left alone
+	removed := context.routerConnections.RemoveCb(routerConn.Key(), func(key string, v edge.RouterConn, exists bool) bool {
+		return exists && v == routerConn
+	})
+	if removed {
+		context.routerPool.onClosed(routerConn.Key())
+	}
 }
 
 func (context *ContextImpl) processServiceUpdates(services []*rest_model.ServiceDetail) {
@@ -464,7 +606,7 @@ func (context *ContextImpl) processServiceUpdates(services []*rest_model.Service
 			if context.options.OnServiceUpdate != nil {
 				context.options.OnServiceUpdate(ServiceRemoved, svc)
 			}
-			context.Emit(EventServiceRemoved, svc)
+			context.publish(&ServiceRemovedEvent{Service: svc})
 
 			context.deleteServiceSessions(*svc.ID)
 
@@ -474,31 +616,36 @@ func (context *ContextImpl) processServiceUpdates(services []*rest_model.Service
 	for _, deletedKey := range deletes {
 		context.services.Remove(deletedKey)
 		context.intercepts.Remove(deletedKey)
+		context.serviceConfigs.Remove(deletedKey)
 	}
 
 	// Adds and Updates
 	for _, s := range services {
 		isChange := false
-		valuesDiffer := false
+		var diff *ServiceDiff
 
 		_ = context.services.Upsert(*s.Name, s, func(exist bool, valueInMap *rest_model.ServiceDetail, newValue *rest_model.ServiceDetail) *rest_model.ServiceDetail {
 			isChange = exist
 			if isChange {
-				valuesDiffer = !reflect.DeepEqual(newValue, valueInMap)
+				diff = DiffServices(valueInMap, newValue)
 			}
 
 			return newValue
 		})
 
-		if isChange {
-			context.Emit(EventServiceChanged, s)
-		} else {
-			context.Emit(EventServiceAdded, s)
+		if !isChange || diff.HasChanges() {
+			context.parseServiceConfigs(s)
+		}
+
+		if !isChange {
+			context.publish(&ServiceAddedEvent{Service: s})
+		} else if diff.HasChanges() {
+			context.publish(&ServiceChangedEvent{Service: s, Diff: diff})
 		}
 
 		if context.options.OnServiceUpdate != nil {
 			if isChange {
-				if valuesDiffer {
+				if diff.HasChanges() {
 					context.options.OnServiceUpdate(ServiceChanged, s)
 				}
 			} else {
@@ -525,6 +672,8 @@ func (context *ContextImpl) processServiceUpdates(services []*rest_model.Service
 		}
 	}
 
+	context.rebuildInterceptIndex()
+
 	serviceQueryMap := map[string]map[string]rest_model.PostureQuery{} //serviceId -> queryId -> query
 
 	context.services.IterCb(func(key string, svc *rest_model.ServiceDetail) {
@@ -542,6 +691,7 @@ func (context *ContextImpl) processServiceUpdates(services []*rest_model.Service
 	})
 
 	context.CtrlClt.PostureCache.SetServiceQueryMap(serviceQueryMap)
+	context.updateMfaPosture()
 }
 
 func (context *ContextImpl) refreshSessions() {
@@ -558,10 +708,8 @@ func (context *ContextImpl) refreshSessions() {
 			toDelete = append(toDelete, *session.ID)
 		} else {
 			for _, er := range s.EdgeRouters {
-				for _, u := range er.Urls {
-					if context.options.isEdgeRouterUrlAccepted(u) {
-						edgeRouters[u] = *er.Name
-					}
+				for _, u := range context.options.getEdgeRouterUrls(er) {
+					edgeRouters[u] = *er.Name
 				}
 			}
 		}
@@ -571,8 +719,20 @@ func (context *ContextImpl) refreshSessions() {
 		context.sessions.Remove(id)
 	}
 
+	context.routerPool.retain(edgeRouters)
+
 	for u, name := range edgeRouters {
-		go context.connectEdgeRouter(name, u, nil)
+		go context.connectEdgeRouter(ctx.Background(), name, u, nil)
+	}
+}
+
+// prewarmEdgeRouters starts connecting to all the edge routers of a new session in the background, so that later
+// dials don't have to wait for them
+func (context *ContextImpl) prewarmEdgeRouters(session *rest_model.SessionDetail) {
+	for _, er := range session.EdgeRouters {
+		for _, u := range context.options.getEdgeRouterUrls(er) {
+			go context.connectEdgeRouter(ctx.Background(), *er.Name, u, nil)
+		}
 	}
 }
 
@@ -581,7 +741,7 @@ func (context *ContextImpl) RefreshServices() error {
 }
 
 func (context *ContextImpl) refreshServices(forceCheck bool) error {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(ctx.Background()); err != nil {
 		return fmt.Errorf("failed to refresh services: %v", err)
 	}
 
@@ -686,7 +846,7 @@ func (context *ContextImpl) EnsureAuthenticated(options edge.ConnOptions) error
 }
 
 func (context *ContextImpl) GetCurrentIdentity() (*rest_model.IdentityDetail, error) {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(ctx.Background()); err != nil {
 		return nil, errors.Wrap(err, "failed to establish api session")
 	}
 
@@ -703,26 +863,36 @@ func (context *ContextImpl) setUnauthenticated() {
 	context.CloseAllEdgeRouterConns()
 
 	if willEmit {
-		context.Emit(EventAuthenticationStateUnauthenticated, prevApiSession)
+		context.publish(&AuthStateEvent{State: AuthStateUnauthenticated, ApiSession: prevApiSession})
 	}
 }
 
-func (context *ContextImpl) authenticate() error {
+// authenticate authenticates with the controller, traced as a child of the operation carried by traceCtx
+func (context *ContextImpl) authenticate(traceCtx ctx.Context) error {
+	_, span := context.options.getTracer().StartOperation(traceCtx, OperationAuthenticate)
+	err := context.doAuthenticate()
+	span.End(err)
+	return err
+}
+
+func (context *ContextImpl) doAuthenticate() error {
 	logrus.Debug("attempting to authenticate")
 	context.services = cmap.New[*rest_model.ServiceDetail]()
 	context.sessions = cmap.New[*rest_model.SessionDetail]()
 	context.intercepts = cmap.New[*edge.InterceptV1Config]()
+	context.interceptIndex.Store(nil)
+	context.serviceConfigs = cmap.New[map[string]interface{}]()
 
 	context.setUnauthenticated()
 
-	apiSession, err := context.CtrlClt.Authenticate()
+	apiSession, err := context.CtrlClt.authenticate(context.getConfigRegistry())
 
 	if err != nil {
 		return err
 	}
 
 	if len(apiSession.AuthQueries) != 0 {
-		context.Emit(EventAuthenticationStatePartial, apiSession)
+		context.publish(&AuthStateEvent{State: AuthStatePartial, ApiSession: apiSession})
 		for _, authQuery := range apiSession.AuthQueries {
 			if err := context.handleAuthQuery(authQuery); err != nil {
 				return err
@@ -739,10 +909,16 @@ func (context *ContextImpl) Reauthenticate() error {
 	context.CtrlClt.CurrentAPISessionDetail = nil
 	context.CtrlClt.ApiSessionCertificate = nil
 
-	return context.authenticate()
+	return context.authenticate(ctx.Background())
 }
 
 func (context *ContextImpl) Authenticate() error {
+	return context.refreshOrAuthenticate(ctx.Background())
+}
+
+// refreshOrAuthenticate refreshes the current api session if there is one, otherwise authenticates. Authentication is
+// traced as a child of the operation carried by traceCtx.
+func (context *ContextImpl) refreshOrAuthenticate(traceCtx ctx.Context) error {
 	if context.CtrlClt.GetCurrentApiSession() != nil {
 		logrus.Debug("previous apiSession detected, checking if valid")
 		if _, err := context.CtrlClt.Refresh(); err == nil {
@@ -753,10 +929,12 @@ func (context *ContextImpl) Authenticate() error {
 		}
 	}
 
-	return context.authenticate()
+	return context.authenticate(traceCtx)
 }
 
 func (context *ContextImpl) CloseAllEdgeRouterConns() {
+	context.routerPool.reset()
+
 	for entry := range context.routerConnections.IterBuffered() {
 		key, val := entry.Key, entry.Val
 		if !val.IsClosed() {
@@ -784,7 +962,7 @@ func (context *ContextImpl) onFullAuth() error {
 		context.metrics = metrics.NewRegistry(context.CtrlClt.GetCurrentApiSession().Identity.Name, metricsTags)
 	})
 
-	context.Emit(EventAuthenticationStateFull, context.CtrlClt.GetCurrentApiSession())
+	context.publish(&AuthStateEvent{State: AuthStateFull, ApiSession: context.CtrlClt.GetCurrentApiSession()})
 
 	// get services
 	if err := context.RefreshServices(); err != nil {
@@ -795,7 +973,10 @@ func (context *ContextImpl) onFullAuth() error {
 }
 
 func (context *ContextImpl) AddZitiMfaHandler(handler func(query *rest_model.AuthQueryDetail, response MfaCodeResponse) error) {
-	context.authQueryHandlers[string(rest_model.MfaProvidersZiti)] = handler
+	context.RegisterAuthQueryHandler(rest_model.MfaProvidersZiti, "", AuthQueryHandlerFunc(
+		func(_ ctx.Context, query *rest_model.AuthQueryDetail, response AuthQueryResponse) error {
+			return handler(query, MfaCodeResponse(response))
+		}))
 }
 
 func (context *ContextImpl) authenticateMfa(code string) error {
@@ -815,19 +996,25 @@ func (context *ContextImpl) authenticateMfa(code string) error {
 }
 
 func (context *ContextImpl) handleAuthQuery(authQuery *rest_model.AuthQueryDetail) error {
-	context.Emit(EventAuthQuery, authQuery)
+	context.publish(&AuthQueryEvent{Query: authQuery})
 
-	if *authQuery.Provider == rest_model.MfaProvidersZiti {
-		handler := context.authQueryHandlers[string(rest_model.MfaProvidersZiti)]
+	isZiti := authQuery.Provider != nil && *authQuery.Provider == rest_model.MfaProvidersZiti
+	response := context.authQueryResponse(authQuery)
 
-		context.Emit(EventMfaTotpCode, authQuery, MfaCodeResponse(context.authenticateMfa))
+	if isZiti {
+		context.publish(&MfaTotpCodeEvent{Query: authQuery, Response: MfaCodeResponse(response)})
+	}
 
-		if handler == nil {
-			pfxlog.Logger().Errorf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
-		} else {
-			return handler(authQuery, context.authenticateMfa)
-		}
+	if handler := context.authQueryHandlers.get(authQuery); handler != nil {
+		return context.answerAuthQuery(handler, authQuery, response)
+	}
+
+	if context.options.NonInteractive {
+		return &AuthQueryError{Query: authQuery, Err: ErrNoAuthQueryHandler}
+	}
 
+	if isZiti {
+		pfxlog.Logger().Errorf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
 		return nil
 	}
 
@@ -840,16 +1027,50 @@ func (context *ContextImpl) Dial(serviceName string) (edge.Conn, error) {
 }
 
 func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOptions) (edge.Conn, error) {
+	parent := options.TraceContext
+	if parent == nil {
+		parent = ctx.Background()
+	}
+
+	traceCtx, span := context.options.getTracer().StartOperation(parent, OperationDial)
+	span.SetAttribute(TraceAttrService, serviceName)
+	conn, err := context.dialWithOptions(traceCtx, serviceName, options)
+	if infoConn, ok := conn.(connInfoProvider); ok {
+		info := infoConn.GetConnInfo()
+		span.SetAttribute(TraceAttrRouter, info.RouterName)
+		span.SetAttribute(TraceAttrConnId, strconv.FormatUint(uint64(info.Id), 10))
+	}
+	span.End(err)
+	return conn, err
+}
+
+type connInfoProvider interface {
+	GetConnInfo() *edge.ConnInfo
+}
+
+func (context *ContextImpl) dialWithOptions(traceCtx ctx.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
 	edgeDialOptions := &edge.DialOptions{
 		ConnectTimeout: options.ConnectTimeout,
 		Identity:       options.Identity,
 		AppData:        options.AppData,
+		ReadQueue:      options.ReadQueue,
+		Liveness:       context.livenessOptions(options.Liveness),
+	}
+
+	if propagator := context.options.TracePropagator; propagator != nil {
+		carrier := map[string]string{}
+		propagator.Inject(traceCtx, carrier)
+		if appData, err := edge.InjectTraceContext(options.AppData, carrier); err != nil {
+			pfxlog.Logger().WithError(err).Debugf("not propagating trace context when dialing service '%s'", serviceName)
+		} else {
+			edgeDialOptions.AppData = appData
+		}
 	}
 	if edgeDialOptions.GetConnectTimeout() == 0 {
 		edgeDialOptions.ConnectTimeout = 15 * time.Second
 	}
 
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(traceCtx); err != nil {
 		return nil, fmt.Errorf("failed to dial: %v", err)
 	}
 
@@ -865,13 +1086,17 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 	session, err := context.GetSession(*svc.ID)
 	if err != nil {
 		context.deleteServiceSessions(*svc.ID)
-		if session, err = context.createSessionWithBackoff(svc, SessionType(SessionDial), options); err != nil {
+		if session, err = context.createSessionWithBackoff(traceCtx, svc, SessionType(SessionDial), options); err != nil {
+			var mfaErr *MfaRequiredError
+			if errors.As(err, &mfaErr) {
+				return nil, mfaErr
+			}
 			return nil, errors.Wrapf(err, "unable to dial service '%v'", serviceName)
 		}
 	}
 
 	pfxlog.Logger().WithField("sessionId", *session.ID).WithField("sessionToken", session.Token).Debug("connecting with session")
-	conn, err := context.dialSession(svc, session, edgeDialOptions)
+	conn, err := context.dialSession(traceCtx, svc, session, edgeDialOptions)
 	if err == nil {
 		return conn, nil
 	}
@@ -883,13 +1108,17 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 	}
 
 	context.deleteServiceSessions(*svc.ID)
-	if session, refreshErr = context.createSessionWithBackoff(svc, SessionType(SessionDial), options); refreshErr != nil {
+	if session, refreshErr = context.createSessionWithBackoff(traceCtx, svc, SessionType(SessionDial), options); refreshErr != nil {
 		// couldn't create a new session, report the error
+		var mfaErr *MfaRequiredError
+		if errors.As(refreshErr, &mfaErr) {
+			return nil, mfaErr
+		}
 		return nil, errors.Wrapf(refreshErr, "unable to dial service '%s'", serviceName)
 	}
 
 	// retry with new session
-	conn, err = context.dialSession(svc, session, edgeDialOptions)
+	conn, err = context.dialSession(traceCtx, svc, session, edgeDialOptions)
 	if err == nil {
 		return conn, nil
 	}
@@ -897,57 +1126,108 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 	return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
 }
 
-// GetServiceForAddr finds the service with intercept that matches best to given address
+// GetServiceForAddr finds the service with intercept that matches best to given address. If several services match
+// equally well, the alphabetically first one is returned.
 func (context *ContextImpl) GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
-	var svc *rest_model.ServiceDetail
-	score := math.MaxInt
-	lowestFound := false
-	context.intercepts.IterCb(func(key string, intercept *edge.InterceptV1Config) {
-		if lowestFound {
-			return
-		}
+	intercept, score := context.getInterceptIndex().Match(network, hostname, port)
+	if intercept == nil {
+		return nil, -1, errors.Errorf("no service for address[%s:%s:%d]", network, hostname, port)
+	}
 
-		sc := intercept.Match(network, hostname, port)
-		if sc != -1 {
-			if score > sc {
-				score = sc
-				svc = intercept.Service
-			} else if score == sc && *intercept.Service.Name < *svc.Name { // if score is the same, pick alphabetically first service
-				score = sc
-				svc = intercept.Service
-			}
+	return intercept.Service, score, nil
+}
 
-			if sc == 0 {
-				lowestFound = true
-			}
-		}
+// GetServiceForHostname finds the service with the intercept address that best matches the given hostname, on any
+// protocol and port
+func (context *ContextImpl) GetServiceForHostname(hostname string) (*rest_model.ServiceDetail, bool) {
+	intercept, _ := context.getInterceptIndex().MatchAddress(hostname)
+	if intercept == nil {
+		return nil, false
+	}
+	return intercept.Service, true
+}
+
+// rebuildInterceptIndex indexes the current intercepts, so that lookups don't have to evaluate every intercept
+func (context *ContextImpl) rebuildInterceptIndex() *edge.InterceptIndex {
+	var intercepts []*edge.InterceptV1Config
+	context.intercepts.IterCb(func(key string, intercept *edge.InterceptV1Config) {
+		intercepts = append(intercepts, intercept)
 	})
 
-	if svc == nil {
-		return nil, -1, errors.Errorf("no service for address[%s:%s:%d]", network, hostname, port)
+	index := edge.NewInterceptIndex(intercepts)
+	context.interceptIndex.Store(index)
+	return index
+}
+
+func (context *ContextImpl) getInterceptIndex() *edge.InterceptIndex {
+	if index := context.interceptIndex.Load(); index != nil {
+		return index
+	}
+	return context.rebuildInterceptIndex()
+}
+
+// dialServiceFromAddr dials a service for an intercepted address, applying the dial options and source ip of the
+// service's intercept. The deadline of dialCtx shortens the connect timeout, and the dial is abandoned if dialCtx is
+// cancelled first. dialCtx is also the parent of the dial's trace span. ip is the IP address which was dialed, if any,
+// see interceptDialOptions.
+func (context *ContextImpl) dialServiceFromAddr(dialCtx ctx.Context, service *rest_model.ServiceDetail, network, host, ip string, port uint16) (edge.Conn, error) {
+	intercept, _ := context.intercepts.Get(*service.Name)
+
+	identityName := ""
+	if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil && apiSession.Identity != nil {
+		identityName = apiSession.Identity.Name
+	}
+
+	options, err := interceptDialOptions(intercept, identityName, network, host, ip, port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to dial service '%s'", *service.Name)
 	}
 
-	return svc, score, nil
+	if deadline, ok := dialCtx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, errors.Wrapf(ctx.DeadlineExceeded, "unable to dial service '%s'", *service.Name)
+		}
+		if remaining < options.ConnectTimeout {
+			options.ConnectTimeout = remaining
+		}
+	}
+	options.TraceContext = dialCtx
+
+	return dialWithContext(dialCtx, func() (edge.Conn, error) {
+		return context.DialWithOptions(*service.Name, options)
+	})
 }
 
-func (context *ContextImpl) dialServiceFromAddr(service, network, host string, port uint16) (edge.Conn, error) {
-	appdata := make(map[string]any)
-	appdata["dst_protocol"] = network
-	appdata["dst_port"] = strconv.Itoa(int(port))
-	ip := net.ParseIP(host)
-	if len(ip) != 0 {
-		appdata["dst_ip"] = host
-	} else {
-		appdata["dst_hostname"] = host
+// dialWithContext runs dial until it completes or dialCtx is done. A connection completed after dialCtx is done is
+// closed.
+func dialWithContext(dialCtx ctx.Context, dial func() (edge.Conn, error)) (edge.Conn, error) {
+	if dialCtx.Done() == nil {
+		return dial()
 	}
 
-	options := &DialOptions{
-		ConnectTimeout: 5 * time.Second,
+	type dialResult struct {
+		conn edge.Conn
+		err  error
 	}
-	appdataJson, _ := json.Marshal(appdata)
-	options.AppData = appdataJson
 
-	return context.DialWithOptions(service, options)
+	results := make(chan dialResult, 1)
+	go func() {
+		conn, err := dial()
+		results <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.conn, result.err
+	case <-dialCtx.Done():
+		go func() {
+			if result := <-results; result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, dialCtx.Err()
+	}
 }
 
 func (context *ContextImpl) DialAddr(network string, addr string) (edge.Conn, error) {
@@ -963,26 +1243,28 @@ func (context *ContextImpl) DialAddr(network string, addr string) (edge.Conn, er
 	}
 
 	network = normalizeProtocol(network)
+	ip := ipOf(host)
+	host = context.hostnameLookups.resolve(host)
 
 	svc, _, err := context.GetServiceForAddr(network, host, uint16(port))
 	if err != nil {
 		return nil, err
 	}
 
-	return context.dialServiceFromAddr(*svc.Name, network, host, uint16(port))
+	return context.dialServiceFromAddr(ctx.Background(), svc, network, host, ip, uint16(port))
 }
 
-func (context *ContextImpl) dialSession(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
-	edgeConnFactory, err := context.getEdgeRouterConn(session, options)
+func (context *ContextImpl) dialSession(traceCtx ctx.Context, service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
+	edgeConnFactory, err := context.getEdgeRouterConn(traceCtx, session, options)
 	if err != nil {
 		return nil, err
 	}
 	return edgeConnFactory.Connect(service, session, options)
 }
 
-func (context *ContextImpl) ensureApiSession() error {
+func (context *ContextImpl) ensureApiSession(traceCtx ctx.Context) error {
 	if context.CtrlClt.GetCurrentApiSession() == nil {
-		if err := context.Authenticate(); err != nil {
+		if err := context.refreshOrAuthenticate(traceCtx); err != nil {
 			return fmt.Errorf("no apiSession, authentication attempt failed: %v", err)
 		}
 	}
@@ -994,7 +1276,7 @@ func (context *ContextImpl) Listen(serviceName string) (edge.Listener, error) {
 }
 
 func (context *ContextImpl) ListenWithOptions(serviceName string, options *ListenOptions) (edge.Listener, error) {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(options.getTraceContext()); err != nil {
 		return nil, fmt.Errorf("failed to listen: %v", err)
 	}
 
@@ -1013,6 +1295,14 @@ func (context *ContextImpl) listenSession(service *rest_model.ServiceDetail, opt
 		Identity:              options.Identity,
 		BindUsingEdgeIdentity: options.BindUsingEdgeIdentity,
 		ManualStart:           options.ManualStart,
+		ReadQueue:             options.ReadQueue,
+		Liveness:              context.livenessOptions(options.Liveness),
+	}
+
+	if propagator := context.options.TracePropagator; propagator != nil {
+		edgeListenOptions.TraceExtractor = func(carrier map[string]string) ctx.Context {
+			return propagator.Extract(ctx.Background(), carrier)
+		}
 	}
 
 	if edgeListenOptions.ConnectTimeout == 0 {
@@ -1023,11 +1313,11 @@ func (context *ContextImpl) listenSession(service *rest_model.ServiceDetail, opt
 		edgeListenOptions.MaxConnections = 1
 	}
 
-	listenerMgr := newListenerManager(service, context, edgeListenOptions)
+	listenerMgr := newListenerManager(options.getTraceContext(), service, context, edgeListenOptions)
 	return listenerMgr.listener
 }
 
-func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail, options edge.ConnOptions) (edge.RouterConn, error) {
+func (context *ContextImpl) getEdgeRouterConn(traceCtx ctx.Context, session *rest_model.SessionDetail, options edge.ConnOptions) (edge.RouterConn, error) {
 	logger := pfxlog.Logger().WithField("sessionId", *session.ID)
 
 	if refreshedSession, err := context.refreshSession(*session.ID); err != nil {
@@ -1069,10 +1359,8 @@ func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail,
 	}
 
 	for _, edgeRouter := range unconnected {
-		for _, routerUrl := range edgeRouter.Urls {
-			if context.options.isEdgeRouterUrlAccepted(routerUrl) {
-				go context.connectEdgeRouter(*edgeRouter.Name, routerUrl, ch)
-			}
+		for _, routerUrl := range context.options.getEdgeRouterUrls(edgeRouter) {
+			go context.connectEdgeRouter(traceCtx, *edgeRouter.Name, routerUrl, ch)
 		}
 	}
 
@@ -1096,10 +1384,22 @@ func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail,
 	}
 }
 
-func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret chan *edgeRouterConnResult) {
+// connectEdgeRouter connects to the given router url, traced as a child of the operation carried by traceCtx
+func (context *ContextImpl) connectEdgeRouter(traceCtx ctx.Context, routerName, ingressUrl string, ret chan *edgeRouterConnResult) {
 	logger := pfxlog.Logger()
 
+	if !context.routerPool.beginConnect(routerName, ingressUrl, ret) {
+		logger.Debugf("connection to router[%s@%s] already in progress", routerName, ingressUrl)
+		return
+	}
+
+	_, span := context.options.getTracer().StartOperation(traceCtx, OperationRouterConnect)
+	span.SetAttribute(TraceAttrRouter, routerName)
+	span.SetAttribute(TraceAttrRouterUrl, ingressUrl)
+
 	retF := func(res *edgeRouterConnResult) {
+		span.End(res.err)
+		context.routerPool.connectFinished(routerName, ingressUrl, res)
 		select {
 		case ret <- res:
 		default:
@@ -1122,11 +1422,32 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret
 		return
 	}
 
+	if context.options.RouterProxy != nil {
+		proxy, err := context.options.RouterProxy(ingressUrl)
+		if err == nil && proxy != nil {
+			logger.Debugf("connecting to router[%s@%s] through %s proxy %s", routerName, ingressUrl, proxy.Type, proxy.Address)
+			ingAddr, err = edge.NewProxiedAddress(ingAddr, proxy)
+		}
+		if err != nil {
+			logger.WithError(err).Errorf("failed to configure proxy for url[%s]", ingressUrl)
+			retF(&edgeRouterConnResult{routerUrl: ingressUrl, err: err})
+			return
+		}
+	}
+
 	pfxlog.Logger().Debugf("connection to edge router using api session token %v", *context.CtrlClt.GetCurrentApiSession().Token)
 	id, err := context.CtrlClt.GetIdentity()
 
 	if err != nil {
 		retF(&edgeRouterConnResult{routerUrl: ingressUrl, err: err})
+		return
+	}
+
+	if context.routerPins != nil {
+		id = &pinnedIdentity{
+			Identity:         id,
+			verifyConnection: context.routerPins.verifier(PinTargetRouter, ingressUrl, routerName, context.onPinViolation),
+		}
 	}
 
 	dialer := channel.NewClassicDialer(identity.NewIdentity(id), ingAddr, map[int32][]byte{
@@ -1161,7 +1482,7 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret
 
 	logger.Debugf("connected to %s", ingressUrl)
 
-	context.Emit(EventRouterConnected, edgeConn.GetRouterName(), edgeConn.Key())
+	context.publish(&RouterConnectedEvent{Name: edgeConn.GetRouterName(), Address: edgeConn.Key()})
 
 	useConn := context.routerConnections.Upsert(ingressUrl, edgeConn,
 		func(exist bool, oldV edge.RouterConn, newV edge.RouterConn) edge.RouterConn {
@@ -1175,6 +1496,7 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret
 			}
 			h := context.metrics.Histogram("latency." + ingressUrl)
 			h.Update(int64(connectTime))
+			context.routerPool.onConnected(ingressUrl, h.(metrics2.Histogram))
 
 			latencyProbeConfig := &latency.ProbeConfig{
 				Channel:  ch,
@@ -1182,9 +1504,11 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret
 				Timeout:  LatencyCheckTimeout,
 				ResultHandler: func(resultNanos int64) {
 					h.Update(resultNanos)
+					context.routerPool.onLatencyResult(ingressUrl, true)
 				},
 				TimeoutHandler: func() {
 					logrus.Errorf("latency timeout after [%s]", LatencyCheckTimeout)
+					context.routerPool.onLatencyResult(ingressUrl, false)
 					if ch.GetTimeSinceLastRead() > LatencyCheckInterval {
 						// No traffic on channel, no response. Close the channel
 						logrus.Error("no read traffic on channel since before latency probe was sent, closing channel")
@@ -1204,7 +1528,7 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string, ret
 }
 
 func (context *ContextImpl) GetServiceId(name string) (string, bool, error) {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(ctx.Background()); err != nil {
 		return "", false, fmt.Errorf("failed to get service id: %v", err)
 	}
 
@@ -1216,7 +1540,7 @@ func (context *ContextImpl) GetServiceId(name string) (string, bool, error) {
 }
 
 func (context *ContextImpl) GetService(name string) (*rest_model.ServiceDetail, bool) {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(ctx.Background()); err != nil {
 		pfxlog.Logger().Warnf("failed to get service: %v", err)
 		return nil, false
 	}
@@ -1229,7 +1553,7 @@ func (context *ContextImpl) GetService(name string) (*rest_model.ServiceDetail,
 }
 
 func (context *ContextImpl) GetServices() ([]rest_model.ServiceDetail, error) {
-	if err := context.ensureApiSession(); err != nil {
+	if err := context.ensureApiSession(ctx.Background()); err != nil {
 		return nil, fmt.Errorf("failed to get services: %v", err)
 	}
 
@@ -1268,6 +1592,7 @@ func (context *ContextImpl) getOrCreateSession(serviceId string, sessionType Ses
 	}
 
 	context.CtrlClt.PostureCache.AddActiveService(serviceId)
+	context.updateMfaPosture()
 	session, err := context.CtrlClt.CreateSession(serviceId, sessionType)
 
 	if err != nil {
@@ -1277,7 +1602,7 @@ func (context *ContextImpl) getOrCreateSession(serviceId string, sessionType Ses
 	return session, nil
 }
 
-func (context *ContextImpl) createSessionWithBackoff(service *rest_model.ServiceDetail, sessionType SessionType, options edge.ConnOptions) (*rest_model.SessionDetail, error) {
+func (context *ContextImpl) createSessionWithBackoff(traceCtx ctx.Context, service *rest_model.ServiceDetail, sessionType SessionType, options edge.ConnOptions) (*rest_model.SessionDetail, error) {
 	expBackoff := backoff.NewExponentialBackOff()
 	expBackoff.InitialInterval = 50 * time.Millisecond
 	expBackoff.MaxInterval = 10 * time.Second
@@ -1285,8 +1610,12 @@ func (context *ContextImpl) createSessionWithBackoff(service *rest_model.Service
 
 	var session *rest_model.SessionDetail
 	operation := func() error {
-		s, err := context.createSession(service, sessionType)
+		s, err := context.createSession(traceCtx, service, sessionType)
 		if err != nil {
+			// retrying won't help until an MFA code is provided
+			if mfaErr := context.mfaRequiredError(service, sessionType, err); mfaErr != nil {
+				return backoff.Permanent(mfaErr)
+			}
 			return err
 		}
 		session = s
@@ -1301,7 +1630,17 @@ func (context *ContextImpl) createSessionWithBackoff(service *rest_model.Service
 	return session, backoff.Retry(operation, expBackoff)
 }
 
-func (context *ContextImpl) createSession(service *rest_model.ServiceDetail, sessionType SessionType) (*rest_model.SessionDetail, error) {
+// createSession creates a session for the service, traced as a child of the operation carried by traceCtx
+func (context *ContextImpl) createSession(traceCtx ctx.Context, service *rest_model.ServiceDetail, sessionType SessionType) (*rest_model.SessionDetail, error) {
+	sessionCtx, span := context.options.getTracer().StartOperation(traceCtx, OperationSessionCreate)
+	span.SetAttribute(TraceAttrService, *service.Name)
+	span.SetAttribute(TraceAttrSessionType, string(sessionType))
+	session, err := context.doCreateSession(sessionCtx, service, sessionType)
+	span.End(err)
+	return session, err
+}
+
+func (context *ContextImpl) doCreateSession(traceCtx ctx.Context, service *rest_model.ServiceDetail, sessionType SessionType) (*rest_model.SessionDetail, error) {
 	start := time.Now()
 	logger := pfxlog.Logger()
 	logger.Debugf("establishing %s session to service %s", sessionType, *service.Name)
@@ -1309,7 +1648,7 @@ func (context *ContextImpl) createSession(service *rest_model.ServiceDetail, ses
 	if err != nil {
 		logger.WithError(err).Warnf("failure creating %s session to service %s", sessionType, *service.Name)
 		if _, ok := err.(*rest_session.CreateSessionUnauthorized); ok {
-			if err := context.Authenticate(); err != nil {
+			if err := context.refreshOrAuthenticate(traceCtx); err != nil {
 				if _, ok := err.(*authentication.AuthenticateUnauthorized); ok {
 					return nil, backoff.Permanent(err)
 				}
@@ -1335,6 +1674,10 @@ func (context *ContextImpl) refreshSession(id string) (*rest_model.SessionDetail
 func (context *ContextImpl) cacheSession(op string, session *rest_model.SessionDetail) {
 	sessionKey := fmt.Sprintf("%s:%s", session.Service.ID, *session.Type)
 
+	if op == "create" {
+		context.prewarmEdgeRouters(session)
+	}
+
 	if *session.Type == SessionDial {
 		if op == "create" {
 			context.sessions.Set(sessionKey, session)
@@ -1361,6 +1704,8 @@ func (context *ContextImpl) deleteServiceSessions(svcId string) {
 func (context *ContextImpl) Close() {
 	if context.closed.CompareAndSwap(false, true) {
 		close(context.closeNotify)
+		context.eventBus.close()
+		context.mfaPosture.stop()
 
 		context.CloseAllEdgeRouterConns()
 
@@ -1371,6 +1716,18 @@ func (context *ContextImpl) Metrics() metrics.Registry {
 	return context.metrics
 }
 
+func (context *ContextImpl) Routers() []*RouterInfo {
+	return context.routerPool.snapshot()
+}
+
+func (context *ContextImpl) Connections() []*edge.ConnInfo {
+	var result []*edge.ConnInfo
+	for entry := range context.routerConnections.IterBuffered() {
+		result = append(result, entry.Val.GetConnections()...)
+	}
+	return result
+}
+
 func (context *ContextImpl) EnrollZitiMfa() (*rest_model.DetailMfa, error) {
 	return context.CtrlClt.EnrollMfa()
 }
@@ -1382,10 +1739,19 @@ func (context *ContextImpl) RemoveZitiMfa(code string) error {
 	return context.CtrlClt.RemoveMfa(code)
 }
 
-func newListenerManager(service *rest_model.ServiceDetail, context *ContextImpl, options *edge.ListenOptions) *listenerManager {
+func (context *ContextImpl) NewZitiMfaRecoveryCodes(code string) error {
+	return context.CtrlClt.NewMfaRecoveryCodes(code)
+}
+
+func (context *ContextImpl) GetZitiMfaRecoveryCodes(code string) ([]string, error) {
+	return context.CtrlClt.GetMfaRecoveryCodes(code)
+}
+
+func newListenerManager(traceCtx ctx.Context, service *rest_model.ServiceDetail, context *ContextImpl, options *edge.ListenOptions) *listenerManager {
 	now := time.Now()
 
 	listenerMgr := &listenerManager{
+		traceCtx:          traceCtx,
 		service:           service,
 		context:           context,
 		options:           options,
@@ -1404,6 +1770,7 @@ func newListenerManager(service *rest_model.ServiceDetail, context *ContextImpl,
 }
 
 type listenerManager struct {
+	traceCtx           ctx.Context
 	service            *rest_model.ServiceDetail
 	context            *ContextImpl
 	session            *rest_model.SessionDetail
@@ -1483,7 +1850,13 @@ func (mgr *listenerManager) createListener(routerConnection edge.RouterConn, ses
 	start := time.Now()
 	logger := pfxlog.Logger()
 	svc := mgr.listener.GetService()
+
+	_, span := mgr.context.options.getTracer().StartOperation(mgr.traceCtx, OperationBind)
+	span.SetAttribute(TraceAttrService, *svc.Name)
+	span.SetAttribute(TraceAttrRouter, routerConnection.GetRouterName())
 	listener, err := routerConnection.Listen(svc, session, mgr.options)
+	span.End(err)
+
 	elapsed := time.Since(start)
 	if err == nil {
 		logger.Debugf("listener established to %v in %vms", routerConnection.Key(), elapsed.Milliseconds())
@@ -1537,18 +1910,14 @@ func (mgr *listenerManager) makeMoreListeners() {
 			continue
 		}
 
-		for _, routerUrl := range edgeRouter.Urls {
-			if !mgr.context.options.isEdgeRouterUrlAccepted(routerUrl) {
-				continue
-			}
-
+		for _, routerUrl := range mgr.context.options.getEdgeRouterUrls(edgeRouter) {
 			if _, ok := mgr.connects[routerUrl]; ok {
 				// this url already has a connect in progress
 				continue
 			}
 
 			mgr.connects[routerUrl] = time.Now()
-			go mgr.context.connectEdgeRouter(*edgeRouter.Name, routerUrl, mgr.connectChan)
+			go mgr.context.connectEdgeRouter(mgr.traceCtx, *edgeRouter.Name, routerUrl, mgr.connectChan)
 		}
 	}
 }
@@ -1606,7 +1975,7 @@ func (mgr *listenerManager) refreshSession() {
 }
 
 func (mgr *listenerManager) createSessionWithBackoff() {
-	session, err := mgr.context.createSessionWithBackoff(mgr.service, SessionType(SessionBind), mgr.options)
+	session, err := mgr.context.createSessionWithBackoff(mgr.traceCtx, mgr.service, SessionType(SessionBind), mgr.options)
 	if session != nil {
 		mgr.session = session
 		mgr.sessionRefreshTime = time.Now()
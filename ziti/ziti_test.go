@@ -1,6 +1,7 @@
 package ziti
 
 import (
+	"context"
 	"fmt"
 	"github.com/kataras/go-events"
 	"github.com/openziti/edge-api/rest_model"
@@ -30,13 +31,15 @@ func Test_contextImpl_processServiceUpdates(t *testing.T) {
 		options: &Options{
 			OnServiceUpdate: servUpdate,
 		},
-		services:   cmap.New[*rest_model.ServiceDetail](),
-		sessions:   cmap.New[*rest_model.SessionDetail](),
-		intercepts: cmap.New[*edge.InterceptV1Config](),
+		services:       cmap.New[*rest_model.ServiceDetail](),
+		sessions:       cmap.New[*rest_model.SessionDetail](),
+		intercepts:     cmap.New[*edge.InterceptV1Config](),
+		serviceConfigs: cmap.New[map[string]interface{}](),
 		CtrlClt: &CtrlClient{
 			PostureCache: posture.NewCache(nil, closeNotify),
 		},
 		EventEmmiter: events.New(),
+		eventBus:     newEventBus(),
 	}
 
 	var services []*rest_model.ServiceDetail
@@ -91,10 +94,19 @@ func Test_contextImpl_processServiceUpdates(t *testing.T) {
 		},
 	}
 	callbacks = make(map[string]ServiceEventType)
+	changes := ctx.Subscribe(context.Background(), EventNames(EventServiceChanged))
 	ctx.processServiceUpdates(updates)
 
 	assert.Equal(t, len(services), len(callbacks))
 	assert.Equal(t, ServiceChanged, callbacks[*services[0].Name])
+
+	change := (<-changes).(*ServiceChangedEvent)
+	assert.Equal(t, *services[0].Name, *change.Service.Name)
+	assert.Equal(t, []rest_model.DialBind{rest_model.DialBindDial}, change.Diff.PermissionsGained)
+
+	// refreshing unchanged services doesn't report changes
+	ctx.processServiceUpdates(updates)
+	assert.Empty(t, changes)
 }
 
 func Test_AddressMatch(t *testing.T) {
@@ -177,10 +189,11 @@ func Test_AddressMatch(t *testing.T) {
 	}
 
 	ctx := &ContextImpl{
-		options:    &Options{},
-		services:   cmap.New[*rest_model.ServiceDetail](),
-		sessions:   cmap.New[*rest_model.SessionDetail](),
-		intercepts: cmap.New[*edge.InterceptV1Config](),
+		options:        &Options{},
+		services:       cmap.New[*rest_model.ServiceDetail](),
+		sessions:       cmap.New[*rest_model.SessionDetail](),
+		intercepts:     cmap.New[*edge.InterceptV1Config](),
+		serviceConfigs: cmap.New[map[string]interface{}](),
 		CtrlClt: &CtrlClient{
 			PostureCache: posture.NewCache(nil, nil),
 		},